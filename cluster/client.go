@@ -0,0 +1,431 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ErrCoordinator is returned when the Coordinator answers with an unexpected
+// status.
+var ErrCoordinator = errors.NewKind("coordinator answered %d: %s")
+
+// ClientOpts represents configuration options for a Client.
+type ClientOpts struct {
+	// Worker is the name the Client identifies with to the Coordinator,
+	// by default the host name and the process ID.
+	Worker string
+	// LockRetry is the time to wait before trying again to lock a
+	// location locked by another job.
+	LockRetry time.Duration
+	// HTTPClient is used to send the requests to the Coordinator.
+	HTTPClient *http.Client
+	// Logger is used to log the heartbeats errors.
+	Logger log.Logger
+}
+
+const lockRetry = time.Second
+
+// Client leases jobs from a Coordinator and keeps alive their leases until
+// they're finished.
+type Client struct {
+	url    string
+	mu     sync.Mutex
+	leases map[string]*Lease
+	opts   *ClientOpts
+}
+
+// NewClient builds a new Client of the Coordinator served on the given url.
+func NewClient(url string, opts *ClientOpts) *Client {
+	if opts == nil {
+		opts = &ClientOpts{}
+	}
+
+	if opts.Worker == "" {
+		host, _ := os.Hostname()
+		opts.Worker = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	if opts.LockRetry <= 0 {
+		opts.LockRetry = lockRetry
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		leases: make(map[string]*Lease),
+		opts:   opts,
+	}
+}
+
+func (c *Client) do(
+	ctx context.Context,
+	method, path string,
+	body, out interface{},
+) (int, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.url+path, &buf)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.opts.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK && out != nil {
+		return res.StatusCode, json.NewDecoder(res.Body).Decode(out)
+	}
+
+	if res.StatusCode >= http.StatusBadRequest &&
+		res.StatusCode != http.StatusConflict &&
+		res.StatusCode != http.StatusGone {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, ErrCoordinator.New(
+			res.StatusCode, strings.TrimSpace(string(msg)),
+		)
+	}
+
+	return res.StatusCode, nil
+}
+
+// Lease leases the next job from the Coordinator and keeps alive its lease
+// until it's finished. If there are no jobs it waits for new ones until the
+// context is done, returning a gitcollector.ErrNewJobsNotFound. Once the
+// Coordinator has no more jobs it returns a gitcollector.ErrJobSource.
+func (c *Client) Lease(ctx context.Context) (*Lease, error) {
+	req := &leaseRequest{Worker: c.opts.Worker}
+	if deadline, ok := ctx.Deadline(); ok {
+		// the coordinator answers before the request is cancelled,
+		// otherwise the leased job would be lost until it expires.
+		req.Wait = (time.Until(deadline) / 2).String()
+	}
+
+	var l Lease
+	status, err := c.do(ctx, http.MethodPost, "/leases", req, &l)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, gitcollector.ErrNewJobsNotFound.New()
+		}
+
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+	case http.StatusGone:
+		return nil, gitcollector.ErrJobSource.New()
+	default:
+		return nil, gitcollector.ErrNewJobsNotFound.New()
+	}
+
+	timeout, err := time.ParseDuration(l.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	l.stop = make(chan struct{})
+	l.lost = make(chan struct{})
+
+	c.mu.Lock()
+	c.leases[l.ID] = &l
+	c.mu.Unlock()
+
+	go c.keepAlive(&l, timeout)
+	return &l, nil
+}
+
+// keepAlive sends the heartbeats of the given lease until it's finished. The
+// lease is lost once the Coordinator answers it expired, or when no heartbeat
+// could be sent in time to keep it alive, as the Coordinator expires it and
+// its locations can be locked by other jobs.
+func (c *Client) keepAlive(l *Lease, timeout time.Duration) {
+	interval := timeout / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger := c.opts.Logger.New(log.Fields{"lease": l.ID, "job": l.Job.ID})
+	alive := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.stop:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		status, err := c.do(ctx, http.MethodPost,
+			"/leases/"+l.ID+"/heartbeat", nil, nil,
+		)
+		cancel()
+
+		if err == nil && status != http.StatusGone {
+			alive = time.Now()
+			continue
+		}
+
+		if err != nil {
+			logger.Warningf("couldn't send heartbeat: %s", err.Error())
+			// the next heartbeat would arrive once the lease
+			// expired.
+			if time.Since(alive)+interval < timeout {
+				continue
+			}
+		}
+
+		logger.Warningf("lease lost")
+		if c.forget(l) {
+			close(l.lost)
+		}
+
+		return
+	}
+}
+
+// forget stops keeping alive the given lease.
+func (c *Client) forget(l *Lease) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.leases[l.ID]; !ok {
+		return false
+	}
+
+	delete(c.leases, l.ID)
+	close(l.stop)
+	return true
+}
+
+func (c *Client) finish(l *Lease, action string, body interface{}) error {
+	if !c.forget(l) {
+		return ErrLeaseLost.New(l.ID)
+	}
+
+	status, err := c.do(context.Background(), http.MethodPost,
+		"/leases/"+l.ID+"/"+action, body, nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusGone {
+		return ErrLeaseLost.New(l.ID)
+	}
+
+	return nil
+}
+
+// Done tells the Coordinator the job of the given lease was processed.
+func (c *Client) Done(l *Lease) error {
+	return c.finish(l, "done", nil)
+}
+
+// Fail tells the Coordinator the job of the given lease failed. Jobs failing
+// with a transient error can be delivered again.
+func (c *Client) Fail(l *Lease, cause error, transient bool) error {
+	return c.finish(l, "fail", &failRequest{
+		Error:     cause.Error(),
+		Transient: transient,
+	})
+}
+
+// Release tells the Coordinator the job of the given lease wasn't processed
+// and it must be delivered again.
+func (c *Client) Release(l *Lease) error {
+	return c.finish(l, "release", nil)
+}
+
+// Close releases all the leases of the Client.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	leases := make([]*Lease, 0, len(c.leases))
+	for _, l := range c.leases {
+		leases = append(leases, l)
+	}
+	c.mu.Unlock()
+
+	var err error
+	for _, l := range leases {
+		if rerr := c.Release(l); rerr != nil && !ErrLeaseLost.Is(rerr) {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+// Lock locks the given location for the job of the given lease, waiting
+// while it's locked by another job until the context is done. The returned
+// function unlocks it.
+func (c *Client) Lock(
+	ctx context.Context,
+	l *Lease,
+	loc borges.LocationID,
+) (func(), error) {
+	path := "/leases/" + l.ID + "/locks/" + url.PathEscape(string(loc))
+	for {
+		status, err := c.do(ctx, http.MethodPut, path, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		switch status {
+		case http.StatusNoContent:
+		case http.StatusGone:
+			return nil, ErrLeaseLost.New(l.ID)
+		case http.StatusConflict:
+			select {
+			case <-time.After(c.opts.LockRetry):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		default:
+			return nil, ErrCoordinator.New(status, "location not locked")
+		}
+
+		return func() {
+			_, err := c.do(context.Background(), http.MethodDelete,
+				path, nil, nil,
+			)
+			if err != nil {
+				c.opts.Logger.Warningf(
+					"couldn't unlock location %s: %s",
+					loc, err.Error(),
+				)
+			}
+		}, nil
+	}
+}
+
+// NewJobScheduleFn builds a new gitcollector.ScheduleFn that schedules the
+// jobs leased from the Coordinator by the given Client. The jobs are filled
+// with the given setup function and they lock their locations through the
+// Coordinator. Once processed, the result is sent to the Coordinator, which
+// delivers again the jobs failing with a transient error, so the
+// gitcollector.WorkerPool processing them shouldn't retry them.
+func NewJobScheduleFn(
+	c *Client,
+	setup func(*library.Job) error,
+	transient gitcollector.TransientErrorFn,
+) gitcollector.JobScheduleFn {
+	return func(ctx context.Context) (gitcollector.Job, error) {
+		l, err := c.Lease(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		job := &library.Job{
+			ID:         l.Job.ID,
+			Type:       l.Job.Type,
+			LocationID: l.Job.Location,
+			Priority:   l.Job.Priority,
+		}
+		job.SetEndpoints(l.Job.Endpoints)
+
+		if err := setup(job); err != nil {
+			c.Fail(l, err, false)
+			return nil, gitcollector.ErrNewJobsNotFound.New()
+		}
+
+		job.Locker = func(
+			ctx context.Context,
+			loc borges.LocationID,
+		) (func(), error) {
+			return c.Lock(ctx, l, loc)
+		}
+
+		process := job.ProcessFn
+		job.ProcessFn = func(ctx context.Context, j *library.Job) error {
+			return processLeased(ctx, c, l, j, process, transient)
+		}
+
+		return job, nil
+	}
+}
+
+// processLeased processes the job of the given lease, it's cancelled if the
+// lease is lost.
+func processLeased(
+	ctx context.Context,
+	c *Client,
+	l *Lease,
+	job *library.Job,
+	process library.JobFn,
+	transient gitcollector.TransientErrorFn,
+) error {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-l.lost:
+			cancel()
+		case <-jobCtx.Done():
+		}
+	}()
+
+	err := process(jobCtx, job)
+
+	var rerr error
+	switch {
+	case err == nil:
+		rerr = c.Done(l)
+	case isClosed(l.lost):
+		return ErrLeaseLost.Wrap(err, l.ID)
+	case gitcollector.Cancelled(ctx):
+		// it was cancelled on purpose, it mustn't be delivered again.
+		rerr = c.Fail(l, err, false)
+	case ctx.Err() != nil:
+		// the worker was stopped, the job didn't fail by itself.
+		rerr = c.Release(l)
+	default:
+		rerr = c.Fail(l, err, transient != nil && transient(err))
+	}
+
+	if rerr != nil {
+		c.opts.Logger.Warningf("couldn't finish lease %s: %s",
+			l.ID, rerr.Error())
+	}
+
+	return err
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
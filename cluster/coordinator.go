@@ -0,0 +1,463 @@
+// Package cluster distributes the jobs of a library.Queue between several
+// gitcollector processes. A Coordinator leases the jobs over http to remote
+// workers, which keep their leases alive with heartbeats and lock the
+// locations they write so no location is written by two workers at once.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrLeaseLost is returned when the lease of a job expired or it's
+	// unknown to the Coordinator.
+	ErrLeaseLost = errors.NewKind("lease %s lost")
+
+	// ErrLocationLocked is returned when a location is locked by the job
+	// of another lease.
+	ErrLocationLocked = errors.NewKind("location %s locked by another job")
+
+	// ErrJobFailed is recorded in the library.Queue for the jobs which
+	// failed in a remote worker.
+	ErrJobFailed = errors.NewKind("job failed in worker %s: %s")
+)
+
+// CoordinatorOpts represents configuration options for a Coordinator.
+type CoordinatorOpts struct {
+	// LeaseTimeout is the time a lease is kept without heartbeats. Once
+	// it expires its job is delivered again and its locks released.
+	LeaseTimeout time.Duration
+	// PollTimeout is the maximum time a lease request waits for a job.
+	PollTimeout time.Duration
+	// MaxAttempts is the maximum number of times a job is delivered,
+	// only jobs failing with a transient error or which lease expired
+	// are delivered again.
+	MaxAttempts int
+	// Logger is used to log the leases.
+	Logger log.Logger
+}
+
+const (
+	leaseTimeout = time.Minute
+	pollTimeout  = 30 * time.Second
+)
+
+// Coordinator leases the jobs of a library.Queue to remote workers.
+type Coordinator struct {
+	mu       sync.Mutex
+	queue    *library.Queue
+	leases   map[string]*lease
+	locks    map[borges.LocationID]string
+	attempts map[string]int
+	polling  int
+	done     chan struct{}
+	finished chan struct{}
+	doneOnce sync.Once
+	opts     *CoordinatorOpts
+}
+
+type lease struct {
+	id      string
+	job     *library.Job
+	worker  string
+	expires time.Time
+	locks   map[borges.LocationID]struct{}
+}
+
+// NewCoordinator builds a new Coordinator of the jobs of the given Queue. It
+// checks in background the leases expiration until it's closed.
+func NewCoordinator(q *library.Queue, opts *CoordinatorOpts) *Coordinator {
+	if opts == nil {
+		opts = &CoordinatorOpts{}
+	}
+
+	if opts.LeaseTimeout <= 0 {
+		opts.LeaseTimeout = leaseTimeout
+	}
+
+	if opts.PollTimeout <= 0 {
+		opts.PollTimeout = pollTimeout
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
+	c := &Coordinator{
+		queue:    q,
+		leases:   make(map[string]*lease),
+		locks:    make(map[borges.LocationID]string),
+		attempts: make(map[string]int),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		opts:     opts,
+	}
+
+	go c.expireLoop()
+	return c
+}
+
+func (c *Coordinator) expireLoop() {
+	ticker := time.NewTicker(c.opts.LeaseTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			c.expire()
+			c.checkFinished()
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Finished returns a channel closed once all the jobs of the Queue were
+// processed and no more jobs will be enqueued.
+func (c *Coordinator) Finished() <-chan struct{} {
+	return c.finished
+}
+
+// Close stops checking the leases expiration.
+func (c *Coordinator) Close() {
+	c.doneOnce.Do(func() { close(c.done) })
+}
+
+// Leased returns the number of leased jobs.
+func (c *Coordinator) Leased() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.leases)
+}
+
+func (c *Coordinator) checkFinished() {
+	if len(c.leases) > 0 || c.polling > 0 || !c.queue.Exhausted() {
+		return
+	}
+
+	select {
+	case <-c.finished:
+	default:
+		close(c.finished)
+	}
+}
+
+// expire delivers again the jobs of the expired leases.
+func (c *Coordinator) expire() {
+	now := time.Now()
+	for id, l := range c.leases {
+		if now.Before(l.expires) {
+			continue
+		}
+
+		c.opts.Logger.With(log.Fields{
+			"lease":  id,
+			"job":    l.job.ID,
+			"worker": l.worker,
+		}).Warningf("lease expired")
+
+		c.finish(l, ErrLeaseLost.New(id), true)
+	}
+}
+
+// finish ends the given lease releasing its locks. A failed job is delivered
+// again if the error is transient and it has attempts left.
+func (c *Coordinator) finish(l *lease, cause error, transient bool) {
+	delete(c.leases, l.id)
+	for loc := range l.locks {
+		delete(c.locks, loc)
+	}
+
+	var err error
+	switch {
+	case cause == nil:
+		delete(c.attempts, l.job.ID)
+		err = c.queue.Done(l.job.ID)
+	case transient && c.attempts[l.job.ID] < c.opts.MaxAttempts:
+		err = c.queue.Release(l.job.ID)
+	default:
+		delete(c.attempts, l.job.ID)
+		err = c.queue.Fail(l.job.ID, cause)
+	}
+
+	if err != nil {
+		c.opts.Logger.Errorf(err, "couldn't record job %s", l.job.ID)
+	}
+}
+
+// Lease is a job leased to a remote worker.
+type Lease struct {
+	ID      string `json:"id"`
+	Job     Job    `json:"job"`
+	Timeout string `json:"timeout"`
+
+	stop chan struct{}
+	lost chan struct{}
+}
+
+// Job is the description of a library.Job sent to the remote workers.
+type Job struct {
+	ID        string            `json:"id"`
+	Type      library.JobType   `json:"type"`
+	Endpoints []string          `json:"endpoints"`
+	Location  borges.LocationID `json:"location,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+}
+
+// CoordinatorStatus is the status of the Coordinator.
+type CoordinatorStatus struct {
+	Queued int `json:"queued"`
+	Leased int `json:"leased"`
+	Locked int `json:"locked"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+	Wait   string `json:"wait,omitempty"`
+}
+
+type failRequest struct {
+	Error     string `json:"error"`
+	Transient bool   `json:"transient"`
+}
+
+// ServeHTTP implements the http.Handler interface. The endpoints are:
+//
+//	GET /status: the number of queued, leased and locked jobs.
+//	POST /leases: leases the next job to a worker.
+//	POST /leases/{id}/heartbeat: keeps alive the lease.
+//	POST /leases/{id}/done: the job was processed.
+//	POST /leases/{id}/fail: the job failed.
+//	POST /leases/{id}/release: the job must be delivered again.
+//	PUT /leases/{id}/locks/{location}: locks a location for the job.
+//	DELETE /leases/{id}/locks/{location}: unlocks a location.
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "status" && r.Method == http.MethodGet:
+		c.status(w)
+	case path == "leases" && r.Method == http.MethodPost:
+		c.lease(w, r)
+	case len(parts) == 3 && parts[0] == "leases" &&
+		r.Method == http.MethodPost:
+		c.update(w, r, parts[1], parts[2])
+	case len(parts) == 4 && parts[0] == "leases" && parts[2] == "locks":
+		c.lock(w, r, parts[1], borges.LocationID(parts[3]))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *Coordinator) status(w http.ResponseWriter) {
+	c.mu.Lock()
+	status := CoordinatorStatus{
+		Queued: c.queue.Jobs(library.JobEnqueued),
+		Leased: len(c.leases),
+		Locked: len(c.locks),
+	}
+	c.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (c *Coordinator) lease(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wait := c.opts.PollTimeout
+	if d, err := time.ParseDuration(req.Wait); err == nil && d < wait {
+		wait = d
+	}
+
+	// the coordinator isn't finished while a job may be leased.
+	c.mu.Lock()
+	c.expire()
+	c.polling++
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.polling--
+		c.checkFinished()
+		c.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	job, err := c.queue.Lease(ctx)
+	switch {
+	case err == nil:
+	case gitcollector.ErrNewJobsNotFound.Is(err):
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case gitcollector.ErrJobSource.Is(err):
+		// the released jobs are queued again.
+		if c.Leased() > 0 {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusGone)
+		}
+
+		return
+	default:
+		c.opts.Logger.Errorf(err, "couldn't lease job")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		c.queue.Release(job.ID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	l := &lease{
+		id:      id.String(),
+		job:     job,
+		worker:  req.Worker,
+		expires: time.Now().Add(c.opts.LeaseTimeout),
+		locks:   make(map[borges.LocationID]struct{}),
+	}
+
+	c.mu.Lock()
+	c.leases[l.id] = l
+	c.attempts[job.ID]++
+	c.mu.Unlock()
+
+	c.opts.Logger.With(log.Fields{
+		"lease":  l.id,
+		"job":    job.ID,
+		"worker": req.Worker,
+	}).Debugf("job leased")
+
+	// a lease not received by the worker expires and its job is
+	// delivered again.
+	writeJSON(w, http.StatusOK, &Lease{
+		ID: l.id,
+		Job: Job{
+			ID:        job.ID,
+			Type:      job.Type,
+			Endpoints: job.Endpoints(),
+			Location:  job.LocationID,
+			Priority:  job.Priority,
+		},
+		Timeout: c.opts.LeaseTimeout.String(),
+	})
+}
+
+func (c *Coordinator) update(
+	w http.ResponseWriter,
+	r *http.Request,
+	id, action string,
+) {
+	var req failRequest
+	if action == "fail" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	l, ok := c.leases[id]
+	if !ok {
+		http.Error(w, ErrLeaseLost.New(id).Error(), http.StatusGone)
+		return
+	}
+
+	switch action {
+	case "heartbeat":
+		l.expires = time.Now().Add(c.opts.LeaseTimeout)
+	case "done":
+		c.finish(l, nil, false)
+	case "fail":
+		c.finish(l, ErrJobFailed.New(l.worker, req.Error), req.Transient)
+	case "release":
+		// the job wasn't processed, so it isn't an attempt.
+		c.attempts[l.job.ID]--
+		c.finish(l, ErrLeaseLost.New(id), true)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	c.checkFinished()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) lock(
+	w http.ResponseWriter,
+	r *http.Request,
+	id string,
+	loc borges.LocationID,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	l, ok := c.leases[id]
+	if !ok {
+		http.Error(w, ErrLeaseLost.New(id).Error(), http.StatusGone)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if owner, ok := c.locks[loc]; ok && owner != id {
+			http.Error(w,
+				ErrLocationLocked.New(loc).Error(),
+				http.StatusConflict,
+			)
+			return
+		}
+
+		c.locks[loc] = id
+		l.locks[loc] = struct{}{}
+	case http.MethodDelete:
+		if c.locks[loc] == id {
+			delete(c.locks, loc)
+		}
+
+		delete(l.locks, loc)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
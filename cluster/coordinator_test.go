@@ -0,0 +1,248 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCoordinator(
+	t *testing.T,
+	jobs int,
+	opts *CoordinatorOpts,
+) (*Coordinator, *library.Queue, string, func()) {
+	dir, err := ioutil.TempDir("", "gitcollector-cluster")
+	require.NoError(t, err)
+
+	q, err := library.OpenQueue(dir, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < jobs; i++ {
+		job := &library.Job{Type: library.JobDownload}
+		job.SetEndpoints([]string{
+			fmt.Sprintf("https://github.com/org/repo%d", i),
+		})
+		require.NoError(t, q.Enqueue(job))
+	}
+
+	c := NewCoordinator(q, opts)
+	server := httptest.NewServer(c)
+	return c, q, server.URL, func() {
+		server.Close()
+		c.Close()
+		q.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	var require = require.New(t)
+
+	c, q, url, close := newTestCoordinator(t, 3, &CoordinatorOpts{
+		LeaseTimeout: 200 * time.Millisecond,
+		PollTimeout:  50 * time.Millisecond,
+		MaxAttempts:  2,
+	})
+	defer close()
+
+	ctx := context.Background()
+	client := NewClient(url, &ClientOpts{LockRetry: 10 * time.Millisecond})
+	other := NewClient(url, nil)
+
+	l1, err := client.Lease(ctx)
+	require.NoError(err)
+	l2, err := other.Lease(ctx)
+	require.NoError(err)
+	require.NotEqual(l1.Job.ID, l2.Job.ID)
+
+	// a lease without heartbeats expires.
+	res, err := http.Post(url+"/leases", "application/json",
+		strings.NewReader(`{"worker": "dead"}`))
+	require.NoError(err)
+	var dead Lease
+	require.NoError(json.NewDecoder(res.Body).Decode(&dead))
+	res.Body.Close()
+	require.Equal(3, c.Leased())
+
+	// a location is only locked by one job at a time.
+	unlock, err := client.Lock(ctx, l1, "foo")
+	require.NoError(err)
+	lockCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = other.Lock(lockCtx, l2, "foo")
+	cancel()
+	require.Equal(context.DeadlineExceeded, err)
+	unlock()
+	unlock, err = other.Lock(ctx, l2, "foo")
+	require.NoError(err)
+	unlock()
+
+	// the heartbeats keep the rest of leases alive.
+	time.Sleep(400 * time.Millisecond)
+	require.Equal(2, c.Leased())
+	require.NoError(client.Done(l1))
+	require.NoError(other.Fail(l2, fmt.Errorf("foo"), true))
+
+	l3, err := client.Lease(ctx)
+	require.NoError(err)
+	require.Equal(dead.Job.ID, l3.Job.ID)
+	err = client.Done(&Lease{ID: dead.ID})
+	require.True(ErrLeaseLost.Is(err))
+	require.NoError(client.Done(l3))
+
+	// the transient failure is delivered again until its last attempt.
+	l2, err = client.Lease(ctx)
+	require.NoError(err)
+	require.NoError(client.Fail(l2, fmt.Errorf("foo"), true))
+	require.Equal(1, q.Jobs(library.JobFailed))
+
+	_, err = client.Lease(ctx)
+	require.True(gitcollector.ErrJobSource.Is(err))
+
+	select {
+	case <-c.Finished():
+	case <-time.After(time.Second):
+		require.Fail("coordinator not finished")
+	}
+}
+
+func TestNewJobScheduleFn(t *testing.T) {
+	var require = require.New(t)
+
+	const jobs = 20
+	_, q, url, close := newTestCoordinator(t, jobs, &CoordinatorOpts{
+		MaxAttempts: 2,
+	})
+	defer close()
+
+	var (
+		mu        sync.Mutex
+		processed = make(map[string]int)
+		locked    int32
+		overlaps  int32
+		attempts  int32
+	)
+
+	process := func(ctx context.Context, job *library.Job) error {
+		unlock, err := job.LockLocation(ctx, "loc")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if !atomic.CompareAndSwapInt32(&locked, 0, 1) {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		defer atomic.StoreInt32(&locked, 0)
+
+		// the first attempt of every job fails.
+		if atomic.AddInt32(&attempts, 1)%2 == 1 {
+			return fmt.Errorf("transient")
+		}
+
+		mu.Lock()
+		processed[job.Endpoints()[0]]++
+		mu.Unlock()
+		return nil
+	}
+
+	setup := func(job *library.Job) error {
+		job.ProcessFn = process
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		client := NewClient(url, &ClientOpts{
+			Worker:    fmt.Sprintf("worker%d", i),
+			LockRetry: time.Millisecond,
+		})
+
+		wp := gitcollector.NewWorkerPool(
+			NewJobScheduleFn(client, setup, func(error) bool {
+				return true
+			}),
+			&gitcollector.WorkerPoolOpts{SchedulerCapacity: 1},
+		)
+		wp.SetWorkers(3)
+		wp.Run()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wp.Wait()
+		}()
+	}
+
+	wg.Wait()
+	require.Zero(atomic.LoadInt32(&overlaps))
+	require.Zero(q.Jobs(library.JobEnqueued))
+	require.Len(processed, jobs-q.Jobs(library.JobFailed))
+	for ep, n := range processed {
+		require.Equal(1, n, ep)
+	}
+}
+
+// brokenTransport fails the requests while it's broken.
+type brokenTransport struct {
+	broken int32
+}
+
+func (t *brokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&t.broken) == 1 {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientLeaseLost(t *testing.T) {
+	var require = require.New(t)
+
+	_, _, url, close := newTestCoordinator(t, 1, &CoordinatorOpts{
+		LeaseTimeout: 150 * time.Millisecond,
+	})
+	defer close()
+
+	transport := &brokenTransport{}
+	client := NewClient(url, &ClientOpts{
+		HTTPClient: &http.Client{Transport: transport},
+	})
+
+	l, err := client.Lease(context.Background())
+	require.NoError(err)
+
+	// the lease is given up when the heartbeats can't be sent, as the
+	// coordinator expires it.
+	atomic.StoreInt32(&transport.broken, 1)
+	select {
+	case <-l.lost:
+	case <-time.After(time.Second):
+		require.Fail("lease not lost")
+	}
+
+	require.True(ErrLeaseLost.Is(client.Done(l)))
+
+	// only the expected answer locks a location.
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		},
+	))
+	defer server.Close()
+
+	_, err = NewClient(server.URL, nil).Lock(context.Background(), l, "foo")
+	require.True(ErrCoordinator.Is(err))
+}
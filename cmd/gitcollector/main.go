@@ -14,5 +14,13 @@ var app = cli.New("gitcollector", version, build, "source{d} tool to download re
 
 func main() {
 	app.AddCommand(&subcmd.DownloadCmd{})
+	app.AddCommand(&subcmd.UpdateCmd{})
+	app.AddCommand(&subcmd.DeadLetterCmd{})
+	app.AddCommand(&subcmd.ServeCmd{})
+	app.AddCommand(&subcmd.ListCmd{})
+	app.AddCommand(&subcmd.VerifyCmd{})
+	app.AddCommand(&subcmd.ExportCmd{})
+	app.AddCommand(&subcmd.ImportCmd{})
+	app.AddCommand(&subcmd.WorkerCmd{})
 	app.RunMain()
 }
@@ -0,0 +1,114 @@
+package subcmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/gitcollector/server"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// jobQueue guards a jobs channel so jobs can be sent to it from the admin API
+// while the providers may close it.
+type jobQueue struct {
+	mu     sync.RWMutex
+	jobs   chan gitcollector.Job
+	closed bool
+}
+
+func newJobQueue(jobs chan gitcollector.Job) *jobQueue {
+	return &jobQueue{jobs: jobs}
+}
+
+func (q *jobQueue) put(job gitcollector.Job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return fmt.Errorf("no more jobs are accepted")
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return fmt.Errorf("jobs queue is full")
+	}
+}
+
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+}
+
+// serveAdmin serves the admin API of the pool on AdminAddr if it's set. The
+// given job types can be enqueued into the queue. The returned function
+// stops the server.
+func (o *CommonOpts) serveAdmin(
+	wp *gitcollector.WorkerPool,
+	lib borges.Library,
+	queue *jobQueue,
+	queued func() int,
+	types ...library.JobType,
+) (func(), error) {
+	if o.AdminAddr == "" {
+		return func() {}, nil
+	}
+
+	handler := server.NewAdminHandler(wp, &server.AdminOpts{
+		Enqueue: adminEnqueue(lib, queue, types),
+		Queued:  queued,
+		Logger:  log.New(log.Fields{"server": "admin"}),
+	})
+
+	return listenAndServe(o.AdminAddr, "admin api", handler)
+}
+
+// adminEnqueue returns a function to send the jobs of the given types to the
+// queue. Update jobs are sent to the location of their repository.
+func adminEnqueue(
+	lib borges.Library,
+	queue *jobQueue,
+	types []library.JobType,
+) func(*library.Job) error {
+	return func(job *library.Job) error {
+		var allowed bool
+		for _, typ := range types {
+			if job.Type == typ {
+				allowed = true
+			}
+		}
+
+		if !allowed {
+			return server.ErrEnqueueNotSupported.New(job.Type)
+		}
+
+		if job.Type == library.JobUpdate {
+			id, err := library.NewRepositoryID(job.Endpoints()[0])
+			if err != nil {
+				return err
+			}
+
+			ok, _, locID, err := lib.Has(id)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return borges.ErrRepositoryNotExists.New(id)
+			}
+
+			job.LocationID = locID
+		}
+
+		return queue.put(job)
+	}
+}
@@ -0,0 +1,788 @@
+package subcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/credentials"
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/src-d/gitcollector/downloader"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/gitcollector/metrics"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// CommonOpts are the options shared by the subcommands working on a library.
+type CommonOpts struct {
+	LibPath          string        `long:"library" description:"path where download to" env:"GITCOLLECTOR_LIBRARY" required:"true"`
+	LibBucket        int           `long:"bucket" description:"library bucketization level" env:"GITCOLLECTOR_LIBRARY_BUCKET" default:"2"`
+	TmpPath          string        `long:"tmp" description:"directory to place generated temporal files" default:"/tmp" env:"GITCOLLECTOR_TMP"`
+	Workers          int           `long:"workers" description:"number of workers, default to GOMAXPROCS" env:"GITCOLLECTOR_WORKERS"`
+	HalfCPU          bool          `long:"half-cpu" description:"set the number of workers to half of the set workers" env:"GITCOLLECTOR_HALF_CPU"`
+	Token            string        `long:"token" env:"GITHUB_TOKEN" description:"github tokens separated by comma, the one with most remaining quota is used on every request"`
+	TokenFile        string        `long:"token-file" env:"GITHUB_TOKEN_FILE" description:"file with a github token per line, they are used along with the --token ones"`
+	GitHubURL        string        `long:"github-url" env:"GITHUB_URL" description:"API url of a github enterprise server, e.g. https://github.example.com/api/v3/, the /api/v3/ path is used if the url has none"`
+	GitHubUploadURL  string        `long:"github-upload-url" env:"GITHUB_UPLOAD_URL" description:"upload url of the github enterprise server, default to the /api/uploads/ path of its host"`
+	GitHubGitHost    string        `long:"github-git-host" env:"GITHUB_GIT_HOST" description:"host of the git endpoints of the github repositories, default to the host of --github-url or github.com"`
+	GitHubAppID      int64         `long:"github-app-id" env:"GITHUB_APP_ID" description:"ID of a github app to authenticate the github requests and endpoints as its installations instead of with tokens"`
+	GitHubAppKey     string        `long:"github-app-key" env:"GITHUB_APP_KEY_FILE" description:"PEM file with the private key of the --github-app-id app"`
+	CABundle         string        `long:"ca-bundle" env:"GITCOLLECTOR_CA_BUNDLE" description:"PEM file with CA certificates to trust along with the system ones on the https connections, e.g. the self-signed one of a github enterprise server"`
+	MetricsDBURI     string        `long:"metrics-db" env:"GITCOLLECTOR_METRICS_DB_URI" description:"uri to a database where metrics will be sent"`
+	MetricsDBTable   string        `long:"metrics-db-table" env:"GITCOLLECTOR_METRICS_DB_TABLE" default:"gitcollector_metrics" description:"table name where the metrics will be added"`
+	MetricsSync      int64         `long:"metrics-sync-timeout" env:"GITCOLLECTOR_METRICS_SYNC" default:"30" description:"timeout in seconds to send metrics"`
+	MetricsAddr      string        `long:"metrics-addr" env:"GITCOLLECTOR_METRICS_ADDR" description:"address to serve prometheus metrics on, e.g. :9090"`
+	AdminAddr        string        `long:"admin-addr" env:"GITCOLLECTOR_ADMIN_ADDR" description:"address to serve the admin api to control the workers and jobs on, e.g. localhost:9091"`
+	Credentials      string        `long:"credentials" env:"GITCOLLECTOR_CREDENTIALS_FILE" description:"JSON file with a list of rules mapping host and organization glob patterns to tokens"`
+	Netrc            string        `long:"netrc" env:"GITCOLLECTOR_NETRC" description:"netrc file with credentials for the http endpoints, e.g. ~/.netrc"`
+	CredentialHelper string        `long:"credential-helper" env:"GITCOLLECTOR_CREDENTIAL_HELPER" description:"git credential helper to retrieve the credentials for the http endpoints, e.g. store or !/path/to/helper"`
+	CredentialPath   bool          `long:"credential-helper-use-http-path" env:"GITCOLLECTOR_CREDENTIAL_HELPER_USE_HTTP_PATH" description:"request the credentials of every repository to the --credential-helper instead of once per host, as the git credential.useHttpPath option"`
+	SSHKey           string        `long:"ssh-key" env:"GITCOLLECTOR_SSH_KEY" description:"private key to authenticate on ssh endpoints, the ssh-agent is used if it isn't set"`
+	SSHKeyPassword   string        `long:"ssh-key-password" env:"GITCOLLECTOR_SSH_KEY_PASSWORD" description:"password to decrypt the --ssh-key"`
+	SSHUser          string        `long:"ssh-user" env:"GITCOLLECTOR_SSH_USER" default:"git" description:"user for the ssh endpoints without one"`
+	SSHKnownHosts    string        `long:"ssh-known-hosts" env:"GITCOLLECTOR_SSH_KNOWN_HOSTS" description:"known_hosts files to verify the ssh hosts separated by comma, default to SSH_KNOWN_HOSTS or ~/.ssh/known_hosts"`
+	SSHInsecure      bool          `long:"ssh-insecure-ignore-host-key" env:"GITCOLLECTOR_SSH_INSECURE_IGNORE_HOST_KEY" description:"don't verify the keys of the ssh hosts"`
+	StateDir         string        `long:"state-dir" env:"GITCOLLECTOR_STATE_DIR" description:"directory to persist the jobs queue, an interrupted run will resume the pending jobs"`
+	Scheduler        string        `long:"scheduler" env:"GITCOLLECTOR_SCHEDULER" choice:"fifo" choice:"fair" default:"fifo" description:"order to process the jobs, fair takes them in turns from every organization"`
+	Weights          string        `long:"weights" env:"GITCOLLECTOR_WEIGHTS" description:"shares of the workers of the organizations with the fair scheduler separated by comma, e.g. src-d=3,bblfsh=2, the rest have a share of 1"`
+	DownloadWeight   int           `long:"download-weight" env:"GITCOLLECTOR_DOWNLOAD_WEIGHT" default:"1" description:"share of the workers of the download jobs against the update jobs with the fair scheduler"`
+	UpdateWeight     int           `long:"update-weight" env:"GITCOLLECTOR_UPDATE_WEIGHT" default:"1" description:"share of the workers of the update jobs against the download jobs with the fair scheduler"`
+	MaxAttempts      int           `long:"max-attempts" env:"GITCOLLECTOR_MAX_ATTEMPTS" default:"3" description:"maximum number of times a job failing with a transient error is processed"`
+	RetryBackoff     time.Duration `long:"retry-backoff" env:"GITCOLLECTOR_RETRY_BACKOFF" default:"1s" description:"time to wait before retrying a failed job, it's doubled on every retry"`
+	DeadLetter       string        `long:"dead-letter" env:"GITCOLLECTOR_DEAD_LETTER" description:"file to record the jobs which failed permanently"`
+	Catalog          string        `long:"catalog" env:"GITCOLLECTOR_CATALOG" description:"file to keep an index of the library contents, it's used by the list subcommand"`
+	JobTimeout       time.Duration `long:"job-timeout" env:"GITCOLLECTOR_JOB_TIMEOUT" description:"maximum time to process a job, e.g. 1h, no limit by default"`
+	CloneTimeout     time.Duration `long:"clone-timeout" env:"GITCOLLECTOR_CLONE_TIMEOUT" description:"maximum time to clone a repository to download"`
+	RootTimeout      time.Duration `long:"root-commit-timeout" env:"GITCOLLECTOR_ROOT_COMMIT_TIMEOUT" description:"maximum time to find the root commit of a cloned repository"`
+	PrepareTimeout   time.Duration `long:"prepare-timeout" env:"GITCOLLECTOR_PREPARE_TIMEOUT" description:"maximum time to copy a cloned repository to its location"`
+	FetchTimeout     time.Duration `long:"fetch-timeout" env:"GITCOLLECTOR_FETCH_TIMEOUT" description:"maximum time to fetch the changes of every remote"`
+	CommitTimeout    time.Duration `long:"commit-timeout" env:"GITCOLLECTOR_COMMIT_TIMEOUT" description:"maximum time since a location is locked to start committing the changes into it, they're rolled back once it expires"`
+	LockTimeout      time.Duration `long:"lock-timeout" env:"GITCOLLECTOR_LOCK_TIMEOUT" default:"1m" description:"maximum time to wait for a location locked by another process writing the library, 0 fails right away and a negative value waits indefinitely"`
+
+	locker    *library.FileLocker
+	transport *http.Transport
+	app       *discovery.GHApp
+}
+
+// openLibrary opens the siva library at LibPath, along with the FileLocker
+// used by the jobs to write it. It also creates a temporal directory under
+// TmpPath, the returned function removes it.
+func (o *CommonOpts) openLibrary(
+	name string,
+) (*siva.Library, billy.Filesystem, func(), error) {
+	info, err := os.Stat(o.LibPath)
+	if err != nil {
+		log.Errorf(err, "wrong path to locate the library")
+		return nil, nil, nil, err
+	}
+
+	if !info.IsDir() {
+		err := fmt.Errorf("%s isn't a directory", o.LibPath)
+		log.Errorf(err, "wrong path to locate the library")
+		return nil, nil, nil, err
+	}
+
+	fs := osfs.New(o.LibPath)
+	locker, err := library.NewFileLocker(o.LibPath, &library.FileLockerOpts{
+		Timeout: o.LockTimeout,
+	})
+	if err != nil {
+		log.Errorf(err, "unable to create the library locks")
+		return nil, nil, nil, err
+	}
+
+	tmpPath, err := ioutil.TempDir(o.TmpPath, "gitcollector-"+name)
+	if err != nil {
+		log.Errorf(err, "unable to create temporal directory")
+		return nil, nil, nil, err
+	}
+
+	cleanup := func() {
+		if err := os.RemoveAll(tmpPath); err != nil {
+			log.Warningf(
+				"couldn't remove temporal directory %s: %s",
+				tmpPath, err.Error(),
+			)
+		}
+	}
+
+	log.Debugf("temporal dir: %s", tmpPath)
+	temp := osfs.New(tmpPath)
+
+	lib, err := siva.NewLibrary("test", fs, &siva.LibraryOptions{
+		Bucket:        o.LibBucket,
+		Transactional: true,
+		TempFS:        temp,
+	})
+	if err != nil {
+		cleanup()
+		log.Errorf(err, "unable to create borges siva library")
+		return nil, nil, nil, err
+	}
+
+	o.locker = locker
+	return lib, temp, cleanup, nil
+}
+
+// openQueue opens the persistent jobs queue for the given command in the
+// StateDir. It returns nil if no StateDir was configured.
+func (o *CommonOpts) openQueue(name string) (*library.Queue, error) {
+	if o.StateDir == "" {
+		return nil, nil
+	}
+
+	q, err := library.OpenQueue(filepath.Join(o.StateDir, name), nil)
+	if err != nil {
+		log.Errorf(err, "unable to open jobs queue")
+		return nil, err
+	}
+
+	log.Debugf("jobs queue resumed: %d pending jobs",
+		q.Jobs(library.JobEnqueued))
+
+	return q, nil
+}
+
+func closeQueue(q *library.Queue) {
+	if q == nil {
+		return
+	}
+
+	if err := q.Close(); err != nil {
+		log.Warningf("couldn't close jobs queue: %s", err.Error())
+	}
+}
+
+// fairQueue builds the FairQueue to schedule the jobs if the fair Scheduler
+// was chosen. It returns nil otherwise.
+func (o *CommonOpts) fairQueue() (*library.FairQueue, error) {
+	if o.Scheduler != "fair" {
+		return nil, nil
+	}
+
+	if o.StateDir != "" {
+		err := fmt.Errorf("--state-dir can't be used with the fair scheduler")
+		log.Errorf(err, "wrong options")
+		return nil, err
+	}
+
+	weights := make(map[string]int)
+	for _, w := range strings.Split(o.Weights, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+
+		fields := strings.SplitN(w, "=", 2)
+		var weight int
+		if len(fields) == 2 {
+			weight, _ = strconv.Atoi(fields[1])
+		}
+
+		if weight <= 0 {
+			err := fmt.Errorf("wrong weight %q", w)
+			log.Errorf(err, "wrong options")
+			return nil, err
+		}
+
+		weights[strings.ToLower(fields[0])] = weight
+	}
+
+	return library.NewFairQueue(&library.FairQueueOpts{
+		Weights: weights,
+		TypeWeights: map[library.JobType]int{
+			library.JobDownload: o.DownloadWeight,
+			library.JobUpdate:   o.UpdateWeight,
+		},
+	}), nil
+}
+
+func closeFairQueue(q *library.FairQueue) {
+	if q != nil {
+		q.Close()
+	}
+}
+
+// schedulerCapacity returns the number of jobs scheduled ahead by the pool.
+// The fair scheduler orders the jobs when they're scheduled, so only one is
+// taken ahead.
+func (o *CommonOpts) schedulerCapacity() int {
+	if o.Scheduler == "fair" {
+		return 1
+	}
+
+	return 0
+}
+
+// githubTokens builds the pool with the tokens given by Token and TokenFile.
+func (o *CommonOpts) githubTokens() (*discovery.TokenPool, error) {
+	tokens := strings.Split(o.Token, ",")
+	if o.TokenFile != "" {
+		fromFile, err := discovery.LoadTokens(o.TokenFile)
+		if err != nil {
+			log.Errorf(err, "unable to load github tokens")
+			return nil, err
+		}
+
+		tokens = append(tokens, fromFile...)
+	}
+
+	pool := discovery.NewTokenPool(tokens...)
+	if pool.Len() > 0 {
+		log.Debugf("%d github tokens found", pool.Len())
+	}
+
+	return pool, nil
+}
+
+// githubIterOpts builds the options of the requests to the github API,
+// authenticated with the given tokens and sent to the GitHubURL server, if
+// it's set.
+func (o *CommonOpts) githubIterOpts(
+	tokens *discovery.TokenPool,
+) (*discovery.GHReposIterOpts, error) {
+	opts := &discovery.GHReposIterOpts{Tokens: tokens}
+	if o.GitHubURL != "" {
+		api, upload, err := discovery.ParseGHEnterpriseURLs(
+			o.GitHubURL, o.GitHubUploadURL,
+		)
+		if err != nil {
+			log.Errorf(err, "wrong github enterprise url")
+			return nil, err
+		}
+
+		opts.BaseURL, opts.UploadURL = api, upload
+	}
+
+	t, err := o.caTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != nil {
+		opts.Transport = t
+	}
+
+	if opts.App, err = o.githubApp(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// githubApp returns the github app given by GitHubAppID and GitHubAppKey, if
+// any. Its requests are sent to the GitHubURL server, if it's set.
+func (o *CommonOpts) githubApp() (*discovery.GHApp, error) {
+	if o.GitHubAppID == 0 || o.app != nil {
+		return o.app, nil
+	}
+
+	if o.GitHubAppKey == "" {
+		err := fmt.Errorf("--github-app-key is required along with " +
+			"--github-app-id")
+		log.Errorf(err, "wrong github app")
+		return nil, err
+	}
+
+	key, err := ioutil.ReadFile(o.GitHubAppKey)
+	if err != nil {
+		log.Errorf(err, "unable to read github app private key")
+		return nil, err
+	}
+
+	opts := &discovery.GHAppOpts{}
+	if o.GitHubURL != "" {
+		opts.BaseURL, _, err = discovery.ParseGHEnterpriseURLs(
+			o.GitHubURL, o.GitHubUploadURL,
+		)
+		if err != nil {
+			log.Errorf(err, "wrong github enterprise url")
+			return nil, err
+		}
+	}
+
+	t, err := o.caTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != nil {
+		opts.Transport = t
+	}
+
+	app, err := discovery.NewGHApp(o.GitHubAppID, key, opts)
+	if err != nil {
+		log.Errorf(err, "unable to load github app")
+		return nil, err
+	}
+
+	log.Debugf("authenticating as the github app %d", o.GitHubAppID)
+	o.app = app
+	return app, nil
+}
+
+// githubHost returns the host of the git endpoints of the github
+// repositories, the GitHubGitHost or the host of the GitHubURL server.
+func (o *CommonOpts) githubHost() string {
+	if o.GitHubGitHost != "" {
+		return strings.ToLower(o.GitHubGitHost)
+	}
+
+	api, err := url.Parse(o.GitHubURL)
+	if err != nil {
+		return discovery.DefaultGHHost
+	}
+
+	return discovery.GHGitHost(api)
+}
+
+// caTransport returns the http.Transport trusting the CABundle, if it's set.
+// It's also installed to clone and fetch the https endpoints.
+func (o *CommonOpts) caTransport() (*http.Transport, error) {
+	if o.CABundle == "" || o.transport != nil {
+		return o.transport, nil
+	}
+
+	t, err := discovery.NewCATransport(o.CABundle)
+	if err != nil {
+		log.Errorf(err, "unable to load CA bundle")
+		return nil, err
+	}
+
+	client.InstallProtocol("https", githttp.NewClient(&http.Client{
+		Transport: t,
+	}))
+
+	o.transport = t
+	return t, nil
+}
+
+// auth builds the authentication for the endpoints. The credentials for the
+// http endpoints are looked up in the credentials file, the environment, the
+// netrc file, the credential helper, the installations of the github app and
+// finally the given tokens.
+func (o *CommonOpts) auth(tokens credentials.Rules) (library.AuthMethodFn, error) {
+	if _, err := o.caTransport(); err != nil {
+		return nil, err
+	}
+
+	app, err := o.githubApp()
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []credentials.Source
+	if o.Credentials != "" {
+		rules, err := credentials.LoadRules(o.Credentials)
+		if err != nil {
+			log.Errorf(err, "unable to load credentials")
+			return nil, err
+		}
+
+		sources = append(sources, rules)
+	}
+
+	sources = append(sources, credentials.NewEnv(credentials.EnvPrefix))
+
+	if o.Netrc != "" {
+		netrc, err := credentials.LoadNetrc(o.Netrc)
+		if err != nil {
+			log.Errorf(err, "unable to load netrc file")
+			return nil, err
+		}
+
+		sources = append(sources, netrc)
+	}
+
+	if o.CredentialHelper != "" {
+		sources = append(sources, credentials.NewHelper(
+			o.CredentialHelper,
+			&credentials.HelperOpts{UseHTTPPath: o.CredentialPath},
+		))
+	}
+
+	if app != nil {
+		sources = append(sources,
+			credentials.NewGitHubApp(o.githubHost(), app),
+		)
+	}
+
+	if len(tokens) > 0 {
+		sources = append(sources, tokens)
+	}
+
+	return library.ChainAuth(
+		o.sshAuth(),
+		credentials.NewAuthMethodFn(sources...),
+	), nil
+}
+
+// sshAuth returns the authentication for the ssh endpoints.
+func (o *CommonOpts) sshAuth() library.AuthMethodFn {
+	var knownHosts []string
+	for _, f := range strings.Split(o.SSHKnownHosts, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			knownHosts = append(knownHosts, f)
+		}
+	}
+
+	return library.NewSSHAuthMethodFn(&library.SSHAuthOpts{
+		User:                  o.SSHUser,
+		KeyFile:               o.SSHKey,
+		KeyPassword:           o.SSHKeyPassword,
+		KnownHosts:            knownHosts,
+		InsecureIgnoreHostKey: o.SSHInsecure,
+	})
+}
+
+// retry returns the configuration to retry the failed jobs.
+func (o *CommonOpts) retry() *gitcollector.RetryOpts {
+	return &gitcollector.RetryOpts{
+		MaxAttempts: o.MaxAttempts,
+		MinBackoff:  o.RetryBackoff,
+		Transient:   library.IsTransientError,
+	}
+}
+
+// timeouts returns the timeouts of every phase of the jobs.
+func (o *CommonOpts) timeouts() map[library.Phase]time.Duration {
+	return map[library.Phase]time.Duration{
+		library.PhaseClone:      o.CloneTimeout,
+		library.PhaseRootCommit: o.RootTimeout,
+		library.PhasePrepare:    o.PrepareTimeout,
+		library.PhaseFetch:      o.FetchTimeout,
+		library.PhaseCommit:     o.CommitTimeout,
+	}
+}
+
+// jobFn wraps the given JobFn to process the jobs with the given
+// authentication, the configured timeouts and updating the catalog.
+func (o *CommonOpts) jobFn(
+	fn library.JobFn,
+	auth library.AuthMethodFn,
+	catalog *library.Catalog,
+) library.JobFn {
+	fn = library.WithTimeouts(library.WithAuth(fn, auth), o.timeouts())
+	return o.withLocker(library.WithCatalog(fn, catalog))
+}
+
+// withLocker makes the jobs lock the locations they write with the
+// FileLocker of the library, if it was opened.
+func (o *CommonOpts) withLocker(fn library.JobFn) library.JobFn {
+	if o.locker == nil {
+		return fn
+	}
+
+	return library.WithLocker(fn, o.locker.Lock)
+}
+
+// openDeadLetter opens the store to record the jobs which failed permanently.
+// It returns nil if no DeadLetter was configured.
+func (o *CommonOpts) openDeadLetter() (*library.DeadLetterStore, error) {
+	if o.DeadLetter == "" {
+		return nil, nil
+	}
+
+	dl, err := library.OpenDeadLetterStore(o.DeadLetter, &library.DeadLetterOpts{
+		Ignore: downloader.ErrRepoAlreadyExists.Is,
+	})
+	if err != nil {
+		log.Errorf(err, "unable to open dead letter")
+		return nil, err
+	}
+
+	return dl, nil
+}
+
+// poolDeadLetter avoids a nil *library.DeadLetterStore to be used as a non
+// nil gitcollector.DeadLetter.
+func poolDeadLetter(dl *library.DeadLetterStore) gitcollector.DeadLetter {
+	if dl == nil {
+		return nil
+	}
+
+	return dl
+}
+
+func closeDeadLetter(dl *library.DeadLetterStore) {
+	if dl == nil {
+		return
+	}
+
+	if err := dl.Close(); err != nil {
+		log.Warningf("couldn't close dead letter: %s", err.Error())
+	}
+}
+
+// openCatalog opens the index of the library contents. It returns nil if no
+// Catalog was configured.
+func (o *CommonOpts) openCatalog() (*library.Catalog, error) {
+	if o.Catalog == "" {
+		return nil, nil
+	}
+
+	c, err := library.OpenCatalog(
+		o.Catalog, osfs.New(o.LibPath), o.LibBucket,
+	)
+	if err != nil {
+		log.Errorf(err, "unable to open catalog")
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func closeCatalog(c *library.Catalog) {
+	if c == nil {
+		return
+	}
+
+	if err := c.Close(); err != nil {
+		log.Warningf("couldn't close catalog: %s", err.Error())
+	}
+}
+
+func (o *CommonOpts) workers() int {
+	workers := o.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(-1)
+	}
+
+	if o.HalfCPU && workers > 1 {
+		workers = workers / 2
+	}
+
+	return workers
+}
+
+// metrics builds the gitcollector.MetricsCollector for the given
+// organizations. If MetricsAddr is set the metrics are also served for
+// prometheus along with the quota of the given tokens, the returned function
+// stops the server. It returns a nil collector if no metrics were
+// configured.
+func (o *CommonOpts) metrics(
+	orgs []string,
+	gauges *poolGauges,
+	tokens *discovery.TokenPool,
+) (gitcollector.MetricsCollector, func(), error) {
+	var (
+		collectors []gitcollector.MetricsCollector
+		stop       = func() {}
+	)
+
+	if o.MetricsAddr != "" {
+		opts := &metrics.PrometheusOpts{
+			QueueDepth:    gauges.queued,
+			ActiveWorkers: gauges.active,
+		}
+
+		if tokens != nil && tokens.Len() > 0 {
+			opts.TokenQuotas = tokens.Quotas
+		}
+
+		pc, err := metrics.NewPrometheusCollector(opts)
+		if err != nil {
+			log.Errorf(err, "failed to setup prometheus metrics")
+			return nil, nil, err
+		}
+
+		stop, err = servePrometheus(o.MetricsAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the prometheus collector goes first, the collectors by
+		// organization modify the jobs they receive.
+		collectors = append(collectors, pc)
+	}
+
+	if o.MetricsDBURI != "" {
+		mc, err := setupMetrics(
+			o.MetricsDBURI,
+			o.MetricsDBTable,
+			orgs,
+			o.MetricsSync,
+		)
+		if err != nil {
+			stop()
+			log.Errorf(err, "failed to setup metrics")
+			return nil, nil, err
+		}
+
+		log.Debugf("metrics collection activated: sync timeout %d",
+			o.MetricsSync)
+
+		collectors = append(collectors, mc)
+	}
+
+	switch len(collectors) {
+	case 0:
+		return nil, stop, nil
+	case 1:
+		return collectors[0], stop, nil
+	default:
+		return metrics.NewMultiCollector(collectors...), stop, nil
+	}
+}
+
+func servePrometheus(addr string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return listenAndServe(addr, "prometheus metrics", mux)
+}
+
+// listenAndServe serves the handler on the given address in background. The
+// returned function stops the server.
+func listenAndServe(
+	addr, name string,
+	handler http.Handler,
+) (func(), error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf(err, "unable to listen on %s", addr)
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: handler}
+	go func() {
+		if err := srv.Serve(lis); err != nil &&
+			err != http.ErrServerClosed {
+			log.Errorf(err, "%s server failed", name)
+		}
+	}()
+
+	log.Infof("serving %s on %s", name, lis.Addr())
+	return func() {
+		if err := srv.Close(); err != nil {
+			log.Warningf("couldn't stop %s server: %s",
+				name, err.Error())
+		}
+	}, nil
+}
+
+// poolGauges reads the gauges of a gitcollector.WorkerPool which is built
+// after the metrics collector.
+type poolGauges struct {
+	mu     sync.RWMutex
+	pool   *gitcollector.WorkerPool
+	queues []chan gitcollector.Job
+	fair   *library.FairQueue
+}
+
+func newPoolGauges(queues ...chan gitcollector.Job) *poolGauges {
+	return &poolGauges{queues: queues}
+}
+
+func (g *poolGauges) setFairQueue(q *library.FairQueue) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.fair = q
+}
+
+func (g *poolGauges) setPool(wp *gitcollector.WorkerPool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pool = wp
+}
+
+func (g *poolGauges) queued() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var n int
+	for _, q := range g.queues {
+		n += len(q)
+	}
+
+	if g.fair != nil {
+		n += g.fair.Len()
+	}
+
+	if g.pool != nil {
+		n += g.pool.Queued()
+	}
+
+	return n
+}
+
+func (g *poolGauges) active() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.pool == nil {
+		return 0
+	}
+
+	return g.pool.Active()
+}
+
+func setupMetrics(
+	uri, table string,
+	orgs []string,
+	metricSync int64,
+) (gitcollector.MetricsCollector, error) {
+	db, err := metrics.PrepareDB(uri, table, orgs)
+	if err != nil {
+		log.Errorf(err, "metrics database")
+		return nil, err
+	}
+
+	mcs := make(map[string]*metrics.Collector, len(orgs))
+	for _, org := range orgs {
+		mc := metrics.NewCollector(&metrics.CollectorOpts{
+			Log:      log.New(log.Fields{"org": org}),
+			Send:     metrics.SendToDB(db, table, org),
+			SyncTime: time.Duration(metricSync) * time.Second,
+		})
+
+		mcs[org] = mc
+	}
+
+	return metrics.NewCollectorByOrg(mcs), nil
+}
+
+// handleSignals calls stop when a SIGINT or SIGTERM is received and kill
+// when a second one is received. The returned function stops handling the
+// signals.
+func handleSignals(stop, kill func()) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		for _, fn := range []func(){stop, kill} {
+			select {
+			case sig := <-signals:
+				log.Infof("%s signal received", sig)
+				fn()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,107 @@
+package subcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/cluster"
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/src-d/gitcollector/library"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// coordinatorGrace is the time the coordinator keeps serving once all the
+// jobs were processed.
+const coordinatorGrace = 5 * time.Second
+
+// coordinate serves the discovered jobs to the remote workers on
+// CoordinatorAddr until all of them are processed. The jobs are kept in the
+// StateDir queue, or in a temporal one if it isn't set.
+func (c *DownloadCmd) coordinate(
+	start time.Time,
+	orgs, groups, excludedRepos []string,
+	ghIterOpts *discovery.GHReposIterOpts,
+	protocols map[string]discovery.Protocol,
+	filters []*discovery.RepositoryFilter,
+) error {
+	queue, err := c.openQueue("download")
+	if err != nil {
+		return err
+	}
+
+	if queue == nil {
+		dir, err := ioutil.TempDir(c.TmpPath, "gitcollector-coordinator")
+		if err != nil {
+			log.Errorf(err, "unable to create temporal directory")
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		if queue, err = library.OpenQueue(dir, nil); err != nil {
+			log.Errorf(err, "unable to open jobs queue")
+			return err
+		}
+	}
+	defer closeQueue(queue)
+
+	download := make(chan gitcollector.Job, 100)
+	queue.Consume(download, log.New(nil))
+
+	coordinator := cluster.NewCoordinator(queue, &cluster.CoordinatorOpts{
+		LeaseTimeout: c.LeaseTimeout,
+		MaxAttempts:  c.MaxAttempts,
+		Logger:       log.New(log.Fields{"server": "coordinator"}),
+	})
+	defer coordinator.Close()
+
+	stopServer, err := listenAndServe(
+		c.CoordinatorAddr, "coordinator", coordinator,
+	)
+	if err != nil {
+		return err
+	}
+	defer stopServer()
+
+	providers := c.providers(
+		orgs, groups, excludedRepos, ghIterOpts, download, protocols,
+		filters, nil,
+	)
+
+	// the first signal stops the providers and waits for the workers to
+	// process the queued jobs, the second one exits keeping them in the
+	// queue.
+	exit := make(chan struct{})
+	stopSignals := handleSignals(
+		func() {
+			log.Infof("stopping, waiting for the workers to " +
+				"process the queued jobs, send the signal " +
+				"again to exit immediately")
+
+			go stopProviders(log.New(nil), providers)
+		},
+		func() {
+			log.Warningf("exiting immediately")
+			close(exit)
+		},
+	)
+	defer stopSignals()
+
+	go runProviders(log.New(nil), providers, func() { close(download) })
+
+	select {
+	case <-coordinator.Finished():
+		// the workers asking for jobs learn there are no more before
+		// the server is stopped.
+		select {
+		case <-time.After(coordinatorGrace):
+		case <-exit:
+		}
+	case <-exit:
+	}
+
+	elapsed := time.Since(start).String()
+	log.Infof("coordination finished in %s", elapsed)
+	return nil
+}
@@ -0,0 +1,129 @@
+package subcmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/src-d/gitcollector/library"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// DeadLetterCmd is the gitcollector subcommand to inspect and re-enqueue the
+// jobs which failed permanently.
+type DeadLetterCmd struct {
+	cli.Command `name:"dead-letter" short-description:"inspect and re-enqueue the jobs which failed permanently"`
+
+	DeadLetter string `long:"dead-letter" env:"GITCOLLECTOR_DEAD_LETTER" required:"true" description:"file where the failed jobs were recorded"`
+	StateDir   string `long:"state-dir" env:"GITCOLLECTOR_STATE_DIR" description:"directory of the persisted jobs queues where the failed jobs are re-enqueued"`
+	Requeue    bool   `long:"requeue" env:"GITCOLLECTOR_REQUEUE" description:"re-enqueue the failed jobs on the --state-dir queues and clear the dead letter"`
+}
+
+// Execute runs the command.
+func (c *DeadLetterCmd) Execute(args []string) error {
+	if c.Requeue && c.StateDir == "" {
+		err := fmt.Errorf("--state-dir is required to re-enqueue jobs")
+		log.Errorf(err, "wrong options")
+		return err
+	}
+
+	dl, err := library.OpenDeadLetterStore(c.DeadLetter, nil)
+	if err != nil {
+		log.Errorf(err, "unable to open dead letter")
+		return err
+	}
+	defer closeDeadLetter(dl)
+
+	entries, err := dl.Entries()
+	if err != nil {
+		log.Errorf(err, "unable to read dead letter")
+		return err
+	}
+
+	if !c.Requeue {
+		return printDeadLetter(entries)
+	}
+
+	queues := []struct {
+		typ  library.JobType
+		name string
+	}{
+		{library.JobDownload, "download"},
+		{library.JobUpdate, "update"},
+	}
+
+	requeued := make(map[*library.DeadLetterEntry]bool, len(entries))
+	for _, queue := range queues {
+		var jobs []*library.Job
+		for _, e := range entries {
+			if e.Type == queue.typ {
+				jobs = append(jobs, e.Job())
+				requeued[e] = true
+			}
+		}
+
+		if len(jobs) == 0 {
+			continue
+		}
+
+		dir := filepath.Join(c.StateDir, queue.name)
+		if err := requeue(dir, jobs); err != nil {
+			log.Errorf(err, "unable to re-enqueue %s jobs", queue.name)
+			return err
+		}
+
+		log.Infof("%d %s jobs re-enqueued", len(jobs), queue.name)
+	}
+
+	// the jobs without a queue, e.g. the imports, are kept.
+	var kept []*library.DeadLetterEntry
+	for _, e := range entries {
+		if !requeued[e] {
+			kept = append(kept, e)
+		}
+	}
+
+	if len(kept) > 0 {
+		log.Warningf("%d jobs can't be re-enqueued, they're kept in "+
+			"the dead letter", len(kept))
+	}
+
+	return dl.Reset(kept...)
+}
+
+func requeue(dir string, jobs []*library.Job) error {
+	q, err := library.OpenQueue(dir, nil)
+	if err != nil {
+		return err
+	}
+	defer closeQueue(q)
+
+	for _, job := range jobs {
+		if err := q.Enqueue(job); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printDeadLetter(entries []*library.DeadLetterEntry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTYPE\tTARGET\tATTEMPTS\tERROR")
+	for _, e := range entries {
+		typ, target := e.Type.String(), strings.Join(e.Endpoints, ",")
+		if e.Type == library.JobUpdate {
+			target = string(e.Location)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+			e.Time.Format(time.RFC3339), typ, target, e.Attempts, e.Error,
+		)
+	}
+
+	return w.Flush()
+}
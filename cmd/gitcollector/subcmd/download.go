@@ -2,21 +2,20 @@ package subcmd
 
 import (
 	"fmt"
-	"io/ioutil"
+	"net/url"
 	"os"
-	"runtime"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/credentials"
 	"github.com/src-d/gitcollector/discovery"
 	"github.com/src-d/gitcollector/downloader"
 	"github.com/src-d/gitcollector/library"
-	"github.com/src-d/gitcollector/metrics"
 	"github.com/src-d/gitcollector/provider"
-	"github.com/src-d/go-borges/siva"
-	"gopkg.in/src-d/go-billy.v4/osfs"
+	"github.com/src-d/gitcollector/updater"
 	"gopkg.in/src-d/go-cli.v0"
 	"gopkg.in/src-d/go-log.v1"
 )
@@ -25,149 +24,278 @@ import (
 type DownloadCmd struct {
 	cli.Command `name:"download" short-description:"download repositories from a github organization"`
 
-	LibPath         string `long:"library" description:"path where download to" env:"GITCOLLECTOR_LIBRARY" required:"true"`
-	LibBucket       int    `long:"bucket" description:"library bucketization level" env:"GITCOLLECTOR_LIBRARY_BUCKET" default:"2"`
-	TmpPath         string `long:"tmp" description:"directory to place generated temporal files" default:"/tmp" env:"GITCOLLECTOR_TMP"`
-	Workers         int    `long:"workers" description:"number of workers, default to GOMAXPROCS" env:"GITCOLLECTOR_WORKERS"`
-	HalfCPU         bool   `long:"half-cpu" description:"set the number of workers to half of the set workers" env:"GITCOLLECTOR_HALF_CPU"`
-	NotAllowUpdates bool   `long:"no-updates" description:"don't allow updates on already downloaded repositories" env:"GITCOLLECTOR_NO_UPDATES"`
-	NoForks         bool   `long:"no-forks" description:"github forked repositories will not be downloaded" env:"GITCOLLECTOR_NO_FORKS"`
-	Orgs            string `long:"orgs" env:"GITHUB_ORGANIZATIONS" description:"list of github organization names separated by comma" required:"true"`
-	ExcludedRepos   string `long:"excluded-repos" env:"GITCOLLECTOR_EXCLUDED_REPOS" description:"list of repos to exclude separated by comma" required:"false"`
-	Token           string `long:"token" env:"GITHUB_TOKEN" description:"github token"`
-	MetricsDBURI    string `long:"metrics-db" env:"GITCOLLECTOR_METRICS_DB_URI" description:"uri to a database where metrics will be sent"`
-	MetricsDBTable  string `long:"metrics-db-table" env:"GITCOLLECTOR_METRICS_DB_TABLE" default:"gitcollector_metrics" description:"table name where the metrics will be added"`
-	MetricsSync     int64  `long:"metrics-sync-timeout" env:"GITCOLLECTOR_METRICS_SYNC" default:"30" description:"timeout in seconds to send metrics"`
+	CommonOpts
+
+	NotAllowUpdates bool          `long:"no-updates" description:"don't allow updates on already downloaded repositories" env:"GITCOLLECTOR_NO_UPDATES"`
+	NoForks         bool          `long:"no-forks" description:"github forked repositories will not be downloaded" env:"GITCOLLECTOR_NO_FORKS"`
+	Orgs            string        `long:"orgs" env:"GITHUB_ORGANIZATIONS" description:"list of github organization names separated by comma"`
+	Users           string        `long:"users" env:"GITHUB_USERS" description:"list of github user names separated by comma, the repositories they own are downloaded"`
+	GitHubApp       bool          `long:"github-app-installations" env:"GITHUB_APP_INSTALLATIONS" description:"download the repositories of all the installations of the --github-app-id app"`
+	Search          []string      `long:"search" env:"GITHUB_SEARCH" env-delim:";" description:"github search query of the repositories to download, it can be repeated, e.g. 'language:go stars:>500'"`
+	ExcludedRepos   string        `long:"excluded-repos" env:"GITCOLLECTOR_EXCLUDED_REPOS" description:"list of repos to exclude separated by comma" required:"false"`
+	GitLabGroups    string        `long:"gitlab-groups" env:"GITLAB_GROUPS" description:"list of gitlab groups, subgroups or users separated by comma"`
+	GitLabURL       string        `long:"gitlab-url" env:"GITLAB_URL" default:"https://gitlab.com" description:"base url of the gitlab instance"`
+	GitLabToken     string        `long:"gitlab-token" env:"GITLAB_TOKEN" description:"gitlab token"`
+	FromFile        string        `long:"from-file" env:"GITCOLLECTOR_FROM_FILE" description:"path to a file with a list of endpoints to download, one per line or as JSON lines, use - to read from stdin"`
+	FollowFile      bool          `long:"follow-file" env:"GITCOLLECTOR_FOLLOW_FILE" description:"keep reading the --from-file list waiting for new endpoints"`
+	Filters         []string      `long:"filter" env:"GITCOLLECTOR_FILTERS" env-delim:";" description:"only download the discovered github repositories matching this expression, it can be repeated, e.g. stars>=100, language=go,rust, archived=false, pushed>=30d or name!=*-deprecated"`
+	FiltersFile     string        `long:"filters-file" env:"GITCOLLECTOR_FILTERS_FILE" description:"file with a --filter expression per line"`
+	Checkpoints     string        `long:"discovery-checkpoints" env:"GITCOLLECTOR_DISCOVERY_CHECKPOINTS" description:"file to persist where the discovery of the github organizations, users and app installations resumes after a restart, default to discovery.json in the --state-dir, which is required"`
+	ResetDiscovery  bool          `long:"reset-discovery" env:"GITCOLLECTOR_RESET_DISCOVERY" description:"remove the discovery checkpoints to list again all the github repositories"`
+	Protocols       string        `long:"protocols" env:"GITCOLLECTOR_PROTOCOLS" description:"preferred protocol (https, ssh or git) for the discovered repositories of each host separated by comma, e.g. github.com=ssh,gitlab.com=https"`
+	CoordinatorAddr string        `long:"coordinator-addr" env:"GITCOLLECTOR_COORDINATOR_ADDR" description:"serve the discovered jobs to remote workers on this address instead of processing them, e.g. :9400"`
+	LeaseTimeout    time.Duration `long:"lease-timeout" env:"GITCOLLECTOR_LEASE_TIMEOUT" default:"1m" description:"time a job leased to a remote worker is kept without heartbeats before being delivered again"`
 }
 
 // Execute runs the command.
 func (c *DownloadCmd) Execute(args []string) error {
 	start := time.Now()
 
-	if c.Orgs == "" {
+	if c.Orgs == "" && c.Users == "" && len(c.Search) == 0 &&
+		!c.GitHubApp && c.GitLabGroups == "" &&
+		c.FromFile == "" && c.StateDir == "" {
 		log.Warningf("no organizations found, at least one " +
-			"organization must be provided")
+			"organization, user, search query, github app, gitlab " +
+			"group or endpoints file must be provided")
 
 		return nil
 	}
 
-	o := strings.Split(c.Orgs, ",")
-	orgs := make([]string, 0, len(o))
-	for _, org := range o {
-		orgs = append(orgs, strings.ToLower(org))
+	if c.GitHubApp && c.GitHubAppID == 0 {
+		err := fmt.Errorf("--github-app-id is required along with " +
+			"--github-app-installations")
+		log.Errorf(err, "wrong github app")
+		return err
 	}
 
+	orgs := splitLower(c.Orgs)
+	users := splitLower(c.Users)
+	groups := splitLower(c.GitLabGroups)
+
+	// the repositories of the users are handled as the ones of the
+	// organizations.
+	owners := make([]string, 0, len(orgs)+len(users))
+	owners = append(append(owners, orgs...), users...)
+
 	ers := strings.Split(c.ExcludedRepos, ",")
 	excludedRepos := make([]string, 0, len(ers))
 	for _, er := range ers {
 		excludedRepos = append(excludedRepos, er)
 	}
 
-	info, err := os.Stat(c.LibPath)
+	protocols, err := parseProtocols(c.Protocols)
 	if err != nil {
-		log.Errorf(err, "wrong path to locate the library")
+		log.Errorf(err, "wrong protocols")
 		return err
 	}
 
-	if !info.IsDir() {
-		err := fmt.Errorf("%s isn't a directory", c.LibPath)
-		log.Errorf(err, "wrong path to locate the library")
+	filters, err := c.repositoryFilters()
+	if err != nil {
+		log.Errorf(err, "wrong repository filters")
 		return err
 	}
 
-	fs := osfs.New(c.LibPath)
-
-	tmpPath, err := ioutil.TempDir(
-		c.TmpPath, "gitcollector-downloader")
+	lib, temp, cleanup, err := c.openLibrary("downloader")
 	if err != nil {
-		log.Errorf(err, "unable to create temporal directory")
 		return err
 	}
-	defer func() {
-		if err := os.RemoveAll(tmpPath); err != nil {
-			log.Warningf(
-				"couldn't remove temporal directory %s: %s",
-				tmpPath, err.Error(),
-			)
-		}
-	}()
+	defer cleanup()
 
-	log.Debugf("temporal dir: %s", tmpPath)
-	temp := osfs.New(tmpPath)
+	ghTokens, err := c.githubTokens()
+	if err != nil {
+		return err
+	}
 
-	lib, err := siva.NewLibrary("test", fs, &siva.LibraryOptions{
-		Bucket:        2,
-		Transactional: true,
-		TempFS:        temp,
-	})
+	ghIterOpts, err := c.githubIterOpts(ghTokens)
 	if err != nil {
-		log.Errorf(err, "unable to create borges siva library")
 		return err
 	}
 
-	authTokens := map[string]string{}
-	if c.Token != "" {
-		log.Debugf("acces token found")
-		for _, org := range orgs {
-			authTokens[org] = c.Token
+	if ghIterOpts.Checkpoints, err = c.checkpoints(); err != nil {
+		return err
+	}
+
+	if c.CoordinatorAddr != "" {
+		return c.coordinate(
+			start, orgs, groups, excludedRepos, ghIterOpts, protocols,
+			filters,
+		)
+	}
+
+	var tokens credentials.Rules
+	if ghTokens.Len() > 0 {
+		// the tokens are only sent to the github host, the same
+		// organizations can be found in other hosts.
+		ghHost := c.githubHost()
+		for _, org := range owners {
+			tokens = append(tokens, &credentials.Rule{
+				Host:    ghHost,
+				Org:     org,
+				TokenFn: ghTokens.Token,
+			})
+		}
+
+		if len(c.Search) > 0 {
+			// the found repositories belong to any organization.
+			tokens = append(tokens, &credentials.Rule{
+				Host:    ghHost,
+				TokenFn: ghTokens.Token,
+			})
 		}
 	}
 
-	workers := c.Workers
-	if workers == 0 {
-		workers = runtime.GOMAXPROCS(-1)
+	if c.GitLabToken != "" {
+		log.Debugf("gitlab acces token found")
+		for _, group := range groups {
+			tokens = append(tokens, &credentials.Rule{
+				Host:  urlHost(c.GitLabURL),
+				Org:   glGroupOrg(group),
+				Token: c.GitLabToken,
+			})
+		}
 	}
 
-	if c.HalfCPU && workers > 1 {
-		workers = workers / 2
+	auth, err := c.auth(tokens)
+	if err != nil {
+		return err
 	}
 
 	updateOnDownload := !c.NotAllowUpdates
 	log.Debugf("allow updates on downloads: %v", updateOnDownload)
 
+	catalog, err := c.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer closeCatalog(catalog)
+
 	download := make(chan gitcollector.Job, 100)
+	downloadFn := c.jobFn(downloader.Download, auth, catalog)
+	updateFn := c.jobFn(updater.Update, auth, catalog)
 
-	schedule := library.NewDownloadJobScheduleFn(
-		lib,
-		download,
-		downloader.Download,
-		updateOnDownload,
-		authTokens,
-		log.New(nil),
-		temp,
-	)
+	queue, err := c.openQueue("download")
+	if err != nil {
+		return err
+	}
+	defer closeQueue(queue)
 
-	var mc gitcollector.MetricsCollector
-	if c.MetricsDBURI != "" {
-		mc, err = setupMetrics(
-			c.MetricsDBURI,
-			c.MetricsDBTable,
-			orgs,
-			c.MetricsSync,
+	fair, err := c.fairQueue()
+	if err != nil {
+		return err
+	}
+	defer closeFairQueue(fair)
+
+	var schedule gitcollector.JobScheduleFn
+	switch {
+	case queue != nil:
+		schedule = library.NewQueueJobScheduleFn(
+			queue,
+			lib,
+			download, nil,
+			downloadFn, updateFn,
+			updateOnDownload,
+			nil,
+			log.New(nil),
+			temp,
+			c.retry(),
 		)
-		if err != nil {
-			log.Errorf(err, "failed to setup metrics")
-			return err
-		}
+	case fair != nil:
+		schedule = library.NewFairJobScheduleFn(
+			fair,
+			lib,
+			download, nil,
+			downloadFn, updateFn,
+			updateOnDownload,
+			nil,
+			log.New(nil),
+			temp,
+		)
+	default:
+		schedule = library.NewDownloadJobScheduleFn(
+			lib,
+			download,
+			downloadFn,
+			updateOnDownload,
+			nil,
+			log.New(nil),
+			temp,
+		)
+	}
+
+	gauges := newPoolGauges(download)
+	gauges.setFairQueue(fair)
+	mc, stopMetrics, err := c.metrics(
+		metricsOrgs(owners, groups), gauges, ghTokens,
+	)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
 
-		log.Debugf("metrics collection activated: sync timeout %d",
-			c.MetricsSync)
+	deadLetter, err := c.openDeadLetter()
+	if err != nil {
+		return err
 	}
+	defer closeDeadLetter(deadLetter)
 
 	wp := gitcollector.NewWorkerPool(
 		schedule,
 		&gitcollector.WorkerPoolOpts{
-			Metrics: mc,
+			Metrics:           mc,
+			Retry:             c.retry(),
+			DeadLetter:        poolDeadLetter(deadLetter),
+			JobTimeout:        c.JobTimeout,
+			SchedulerCapacity: c.schedulerCapacity(),
 		},
 	)
 
-	wp.SetWorkers(workers)
+	gauges.setPool(wp)
+
+	jobs := newJobQueue(download)
+	types := []library.JobType{library.JobDownload}
+	if updateOnDownload {
+		types = append(types, library.JobUpdate)
+	}
+
+	stopAdmin, err := c.serveAdmin(wp, lib, jobs, gauges.queued, types...)
+	if err != nil {
+		return err
+	}
+	defer stopAdmin()
+
+	wp.SetWorkers(c.workers())
 	log.Debugf("number of workers in the pool %d", wp.Size())
 
 	wp.Run()
 	log.Debugf("worker pool is running")
 
-	go runGHOrgProviders(log.New(nil), orgs, excludedRepos, c.Token, download, c.NoForks)
+	providers := c.providers(
+		orgs, groups, excludedRepos, ghIterOpts, download, protocols,
+		filters, mc,
+	)
+
+	// the first signal stops the providers and waits for the jobs in
+	// progress, the second one cancels them.
+	stopSignals := handleSignals(
+		func() {
+			log.Infof("stopping, waiting for the jobs in progress " +
+				"to finish, send the signal again to exit " +
+				"immediately")
+
+			go func() {
+				stopProviders(log.New(nil), providers)
+				wp.Close()
+			}()
+		},
+		func() {
+			log.Warningf("exiting immediately, the jobs in " +
+				"progress are rolled back")
+			wp.Stop()
+		},
+	)
+	defer stopSignals()
+
+	go runProviders(log.New(nil), providers, jobs.close)
 
 	wp.Wait()
 	log.Debugf("worker pool stopped successfully")
@@ -177,67 +305,332 @@ func (c *DownloadCmd) Execute(args []string) error {
 	return nil
 }
 
-func setupMetrics(
-	uri, table string,
-	orgs []string,
-	metricSync int64,
-) (gitcollector.MetricsCollector, error) {
-	db, err := metrics.PrepareDB(uri, table, orgs)
+// providers builds the providers of the repositories to download into the
+// given channel. The github repositories filtered out are registered on the
+// given gitcollector.MetricsCollector, if any.
+func (c *DownloadCmd) providers(
+	orgs, groups, excludedRepos []string,
+	ghIterOpts *discovery.GHReposIterOpts,
+	download chan gitcollector.Job,
+	protocols map[string]discovery.Protocol,
+	filters []*discovery.RepositoryFilter,
+	mc gitcollector.MetricsCollector,
+) []namedProvider {
+	ghOpts := &discovery.GitHubOpts{
+		SkipForks: c.NoForks,
+		Protocol:  protocols[c.githubHost()],
+		Filters:   filters,
+		Filtered: provider.FilteredGHRepositoriesOnMetrics(
+			mc, log.New(nil),
+		),
+	}
+
+	var providers []namedProvider
+	providers = append(providers, ghProviders(
+		orgs, "organization", provider.NewGitHubOrg,
+		excludedRepos, ghIterOpts, download, ghOpts,
+	)...)
+	providers = append(providers, ghProviders(
+		splitLower(c.Users), "github user", provider.NewGitHubUser,
+		excludedRepos, ghIterOpts, download, ghOpts,
+	)...)
+	providers = append(providers, ghProviders(
+		c.Search, "github search", provider.NewGitHubSearch,
+		excludedRepos, ghIterOpts, download, ghOpts,
+	)...)
+	if c.GitHubApp {
+		providers = append(providers, namedProvider{
+			name: "github app installations",
+			provider: provider.NewGitHubApp(
+				ghIterOpts.App, excludedRepos, ghIterOpts,
+				download, ghOpts,
+			),
+		})
+	}
+
+	providers = append(providers, glGroupProviders(
+		groups, c.GitLabURL, excludedRepos, c.GitLabToken, download,
+		c.NoForks, protocols[urlHost(c.GitLabURL)],
+	)...)
+
+	if c.FromFile != "" {
+		providers = append(providers, namedProvider{
+			name: fmt.Sprintf("%s endpoints file", c.FromFile),
+			provider: provider.NewEndpoints(
+				c.FromFile,
+				download,
+				&provider.EndpointsOpts{
+					Follow: c.FollowFile,
+					Logger: log.New(log.Fields{
+						"file": c.FromFile,
+					}),
+				},
+			),
+		})
+	}
+
+	return providers
+}
+
+// checkpoints opens the store of the discovery checkpoints at Checkpoints, or
+// in the StateDir. It returns nil if the StateDir isn't set, the checkpoints
+// can't go past the jobs which aren't persisted in its queue.
+func (c *DownloadCmd) checkpoints() (*discovery.CheckpointStore, error) {
+	if c.StateDir == "" {
+		if c.Checkpoints != "" {
+			err := fmt.Errorf("--state-dir is required along with " +
+				"--discovery-checkpoints")
+			log.Errorf(err, "wrong discovery checkpoints")
+			return nil, err
+		}
+
+		if c.ResetDiscovery {
+			log.Warningf("there are no discovery checkpoints to reset")
+		}
+
+		return nil, nil
+	}
+
+	path := c.Checkpoints
+	if path == "" {
+		path = filepath.Join(c.StateDir, "discovery.json")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Errorf(err, "unable to create discovery checkpoints directory")
+		return nil, err
+	}
+
+	if c.ResetDiscovery {
+		// the file is removed before reading it, so a corrupted one
+		// can be reset too.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Errorf(err, "unable to reset discovery checkpoints")
+			return nil, err
+		}
+
+		log.Infof("discovery checkpoints reset, all the github " +
+			"repositories will be listed again")
+	}
+
+	store, err := discovery.OpenCheckpointStore(path)
 	if err != nil {
-		log.Errorf(err, "metrics database")
+		log.Errorf(err, "unable to open discovery checkpoints")
 		return nil, err
 	}
 
-	mcs := make(map[string]*metrics.Collector, len(orgs))
+	return store, nil
+}
+
+// repositoryFilters parses the Filters expressions along with the ones in
+// the FiltersFile.
+func (c *DownloadCmd) repositoryFilters() ([]*discovery.RepositoryFilter, error) {
+	var filters []*discovery.RepositoryFilter
+	if c.FiltersFile != "" {
+		var err error
+		filters, err = discovery.ReadRepositoryFilters(c.FiltersFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, expr := range c.Filters {
+		filter, err := discovery.ParseRepositoryFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+func splitLower(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	l := strings.Split(list, ",")
+	items := make([]string, 0, len(l))
+	for _, item := range l {
+		items = append(items, strings.ToLower(item))
+	}
+
+	return items
+}
+
+// glGroupOrg returns the top-level group of a gitlab group or subgroup path,
+// which is what library.GetOrgFromEndpoint finds for its projects endpoints.
+func glGroupOrg(group string) string {
+	return strings.Split(group, "/")[0]
+}
+
+// metricsOrgs returns the organization names the metrics are grouped by.
+func metricsOrgs(orgs, groups []string) []string {
+	seen := make(map[string]struct{}, len(orgs)+len(groups))
+	var result []string
 	for _, org := range orgs {
-		mc := metrics.NewCollector(&metrics.CollectorOpts{
-			Log:      log.New(log.Fields{"org": org}),
-			Send:     metrics.SendToDB(db, table, org),
-			SyncTime: time.Duration(metricSync) * time.Second,
-		})
+		seen[org] = struct{}{}
+		result = append(result, org)
+	}
 
-		mcs[org] = mc
+	for _, group := range groups {
+		org := glGroupOrg(group)
+		if _, ok := seen[org]; ok {
+			continue
+		}
+
+		seen[org] = struct{}{}
+		result = append(result, org)
 	}
 
-	return metrics.NewCollectorByOrg(mcs), nil
+	return result
 }
 
-func runGHOrgProviders(
-	logger log.Logger,
-	orgs []string,
+// parseProtocols parses a list of host=protocol pairs separated by comma.
+func parseProtocols(list string) (map[string]discovery.Protocol, error) {
+	protocols := map[string]discovery.Protocol{}
+	for _, pair := range splitLower(list) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("wrong host protocol: %s", pair)
+		}
+
+		p, err := discovery.ParseProtocol(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		protocols[strings.TrimSpace(parts[0])] = p
+	}
+
+	return protocols, nil
+}
+
+func urlHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+type namedProvider struct {
+	name     string
+	provider gitcollector.Provider
+}
+
+// ghProviders builds a github provider with the given constructor for every
+// organization, user or search query. The kind names them in the logs.
+func ghProviders(
+	names []string,
+	kind string,
+	newGitHub func(
+		string,
+		[]string,
+		*discovery.GHReposIterOpts,
+		chan<- gitcollector.Job,
+		*discovery.GitHubOpts,
+	) *discovery.GitHub,
+	excludedRepos []string,
+	iterOpts *discovery.GHReposIterOpts,
+	download chan gitcollector.Job,
+	opts *discovery.GitHubOpts,
+) []namedProvider {
+	providers := make([]namedProvider, 0, len(names))
+	for _, name := range names {
+		ghOpts := *opts
+		providers = append(providers, namedProvider{
+			name: fmt.Sprintf("%s %s", name, kind),
+			provider: newGitHub(
+				name,
+				excludedRepos,
+				iterOpts,
+				download,
+				&ghOpts,
+			),
+		})
+	}
+
+	return providers
+}
+
+func glGroupProviders(
+	groups []string,
+	baseURL string,
 	excludedRepos []string,
 	token string,
 	download chan gitcollector.Job,
 	skipForks bool,
+	protocol discovery.Protocol,
+) []namedProvider {
+	providers := make([]namedProvider, 0, len(groups))
+	for _, group := range groups {
+		providers = append(providers, namedProvider{
+			name: fmt.Sprintf("%s gitlab group", group),
+			provider: provider.NewGitLabGroup(
+				group,
+				baseURL,
+				excludedRepos,
+				token,
+				download,
+				&discovery.GitLabOpts{
+					SkipForks: skipForks,
+					Protocol:  protocol,
+				},
+			),
+		})
+	}
+
+	return providers
+}
+
+// runProviders starts the given providers and calls done once all of them
+// stopped.
+func runProviders(
+	logger log.Logger,
+	providers []namedProvider,
+	done func(),
 ) {
 	var wg sync.WaitGroup
-	wg.Add(len(orgs))
-	for _, o := range orgs {
-		org := o
-		p := provider.NewGitHubOrg(
-			org,
-			excludedRepos,
-			token,
-			download,
-			&discovery.GitHubOpts{
-				SkipForks: skipForks,
-			},
-		)
-
+	wg.Add(len(providers))
+	for _, np := range providers {
+		np := np
 		go func() {
-			err := p.Start()
+			err := np.provider.Start()
 			if err != nil &&
-				!discovery.ErrNewRepositoriesNotFound.Is(err) {
+				!discovery.ErrNewRepositoriesNotFound.Is(err) &&
+				!provider.ErrEndpointsStopped.Is(err) &&
+				!provider.ErrUpdatesStopped.Is(err) {
 				logger.Warningf(err.Error())
 			}
 
-			logger.Debugf("%s organization provider stopped", org)
+			logger.Debugf("%s provider stopped", np.name)
 			wg.Done()
 		}()
 
-		logger.Debugf("%s organization provider started", org)
+		logger.Debugf("%s provider started", np.name)
+	}
+
+	wg.Wait()
+	done()
+}
+
+// stopProviders stops all the given providers at once.
+func stopProviders(logger log.Logger, providers []namedProvider) {
+	var wg sync.WaitGroup
+	wg.Add(len(providers))
+	for _, np := range providers {
+		np := np
+		go func() {
+			defer wg.Done()
+			if err := np.provider.Stop(); err != nil {
+				logger.Debugf("%s provider: %s", np.name, err.Error())
+			}
+		}()
 	}
 
 	wg.Wait()
-	close(download)
 }
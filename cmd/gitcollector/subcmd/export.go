@@ -0,0 +1,94 @@
+package subcmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/src-d/gitcollector/downloader"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ExportCmd is the gitcollector subcommand to export a repository stored in a
+// library.
+type ExportCmd struct {
+	cli.Command `name:"export" short-description:"export a repository of the library as a bare repository or a git bundle"`
+
+	LibPath    string `long:"library" description:"path where the library is" env:"GITCOLLECTOR_LIBRARY" required:"true"`
+	LibBucket  int    `long:"bucket" description:"library bucketization level" env:"GITCOLLECTOR_LIBRARY_BUCKET" default:"2"`
+	Repository string `long:"repository" env:"GITCOLLECTOR_REPOSITORY" required:"true" description:"ID or endpoint of the repository to export, e.g. github.com/src-d/gitcollector"`
+	Format     string `long:"format" env:"GITCOLLECTOR_EXPORT_FORMAT" choice:"bare" choice:"bundle" default:"bare" description:"export format"`
+	Output     string `long:"output" env:"GITCOLLECTOR_EXPORT_OUTPUT" required:"true" description:"directory of the bare repository or git bundle file to write, it must not exist"`
+}
+
+// Execute runs the command.
+func (c *ExportCmd) Execute(args []string) error {
+	if _, err := os.Stat(c.Output); !os.IsNotExist(err) {
+		err := fmt.Errorf("%s already exists", c.Output)
+		log.Errorf(err, "wrong output")
+		return err
+	}
+
+	id, err := library.NewRepositoryID(c.Repository)
+	if err != nil {
+		log.Errorf(err, "wrong repository %s", c.Repository)
+		return err
+	}
+
+	lib, err := siva.NewLibrary("export", osfs.New(c.LibPath), &siva.LibraryOptions{
+		Bucket:        c.LibBucket,
+		Transactional: true,
+	})
+	if err != nil {
+		log.Errorf(err, "unable to open borges siva library")
+		return err
+	}
+
+	ctx := context.Background()
+	if c.Format == "bare" {
+		err = downloader.ExportBare(ctx, lib, id, osfs.New(c.Output))
+	} else {
+		err = c.exportBundle(ctx, lib, id)
+	}
+
+	if err != nil {
+		os.RemoveAll(c.Output)
+		log.Errorf(err, "unable to export %s", id)
+		return err
+	}
+
+	log.Infof("%s exported to %s", id, c.Output)
+	return nil
+}
+
+// exportBundle writes the bundle to a temporal file renamed to the Output
+// once it's complete.
+func (c *ExportCmd) exportBundle(
+	ctx context.Context,
+	lib *siva.Library,
+	id borges.RepositoryID,
+) error {
+	f, err := ioutil.TempFile(filepath.Dir(c.Output), ".gitcollector-export")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := downloader.ExportBundle(ctx, lib, id, f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), c.Output)
+}
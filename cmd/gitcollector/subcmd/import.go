@@ -0,0 +1,161 @@
+package subcmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/downloader"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ImportCmd is the gitcollector subcommand to import local repositories and
+// bundles into a library.
+type ImportCmd struct {
+	cli.Command `name:"import" short-description:"import local bare repositories and git bundles into the library"`
+
+	CommonOpts
+
+	RepositoryID string `long:"repository-id" env:"GITCOLLECTOR_REPOSITORY_ID" description:"ID to store the repository with when a single one is imported, e.g. github.com/src-d/gitcollector"`
+	Root         string `long:"root" env:"GITCOLLECTOR_IMPORT_ROOT" description:"derive the repository IDs from the paths relative to this directory, e.g. root/github.com/src-d/gitcollector.git, instead of the origin remotes"`
+
+	Args struct {
+		Paths []string `positional-arg-name:"path" required:"1" description:"bare repositories or .bundle files to import"`
+	} `positional-args:"yes"`
+}
+
+// Execute runs the command.
+func (c *ImportCmd) Execute(args []string) error {
+	start := time.Now()
+	paths := c.Args.Paths
+	if c.RepositoryID != "" && len(paths) > 1 {
+		err := fmt.Errorf("--repository-id can't be used with several paths")
+		log.Errorf(err, "wrong options")
+		return err
+	}
+
+	lib, temp, cleanup, err := c.openLibrary("importer")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	catalog, err := c.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer closeCatalog(catalog)
+
+	deadLetter, err := c.openDeadLetter()
+	if err != nil {
+		return err
+	}
+	defer closeDeadLetter(deadLetter)
+
+	jobs := make([]*library.Job, 0, len(paths))
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			log.Errorf(err, "wrong path %s", path)
+			return err
+		}
+
+		id := borges.RepositoryID(c.RepositoryID)
+		if id == "" && c.Root != "" {
+			if id, err = repositoryIDFromPath(c.Root, path); err != nil {
+				log.Errorf(err, "wrong path %s", path)
+				return err
+			}
+		}
+
+		job := &library.Job{Type: library.JobImport, RepositoryID: id}
+		job.SetEndpoints([]string{path})
+		jobs = append(jobs, job)
+	}
+
+	imports := make(chan gitcollector.Job, len(jobs))
+	for _, job := range jobs {
+		imports <- job
+	}
+	close(imports)
+
+	failures := &importFailures{next: poolDeadLetter(deadLetter)}
+	wp := gitcollector.NewWorkerPool(
+		library.NewImportJobScheduleFn(
+			lib,
+			imports,
+			c.withLocker(
+				library.WithCatalog(downloader.Import, catalog),
+			),
+			log.New(nil),
+			temp,
+		),
+		&gitcollector.WorkerPoolOpts{
+			Retry:      c.retry(),
+			DeadLetter: failures,
+			JobTimeout: c.JobTimeout,
+		},
+	)
+
+	wp.SetWorkers(c.workers())
+	log.Debugf("number of workers in the pool %d", wp.Size())
+
+	wp.Run()
+	wp.Wait()
+
+	elapsed := time.Since(start).String()
+	if n := failures.count(); n > 0 {
+		err := fmt.Errorf("%d of %d imports failed", n, len(jobs))
+		log.Errorf(err, "import finished in %s", elapsed)
+		return err
+	}
+
+	log.Infof("import finished in %s", elapsed)
+	return nil
+}
+
+// importFailures counts the imports which failed permanently, they're also
+// recorded in the next gitcollector.DeadLetter if there's one.
+type importFailures struct {
+	n    int32
+	next gitcollector.DeadLetter
+}
+
+// Put implements the gitcollector.DeadLetter interface.
+func (f *importFailures) Put(job gitcollector.Job, attempts int, err error) {
+	atomic.AddInt32(&f.n, 1)
+	if f.next != nil {
+		f.next.Put(job, attempts, err)
+	}
+}
+
+func (f *importFailures) count() int {
+	return int(atomic.LoadInt32(&f.n))
+}
+
+// repositoryIDFromPath returns the path relative to the root directory
+// without the repository or bundle extension.
+func repositoryIDFromPath(root, path string) (borges.RepositoryID, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s isn't in %s", path, root)
+	}
+
+	rel = strings.TrimSuffix(strings.TrimSuffix(rel, ".bundle"), ".git")
+	return borges.RepositoryID(filepath.ToSlash(rel)), nil
+}
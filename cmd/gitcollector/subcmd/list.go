@@ -0,0 +1,253 @@
+package subcmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ListCmd is the gitcollector subcommand to list the contents of a library.
+type ListCmd struct {
+	cli.Command `name:"list" short-description:"list the locations and repositories stored in the library"`
+
+	LibPath   string `long:"library" description:"path where the library is" env:"GITCOLLECTOR_LIBRARY" required:"true"`
+	LibBucket int    `long:"bucket" description:"library bucketization level" env:"GITCOLLECTOR_LIBRARY_BUCKET" default:"2"`
+	Catalog   string `long:"catalog" env:"GITCOLLECTOR_CATALOG" description:"index of the library contents kept by the download and update subcommands, the siva files are read if it isn't set or doesn't exist"`
+	Rebuild   bool   `long:"rebuild-catalog" env:"GITCOLLECTOR_REBUILD_CATALOG" description:"rebuild the catalog from the siva files before listing it"`
+	Format    string `long:"format" env:"GITCOLLECTOR_LIST_FORMAT" choice:"text" choice:"json" choice:"csv" default:"text" description:"output format"`
+	Orgs      string `long:"orgs" env:"GITHUB_ORGANIZATIONS" description:"only list repositories of these organizations, separated by comma"`
+	Locations string `long:"locations" env:"GITCOLLECTOR_LOCATIONS" description:"only list these location IDs, separated by comma"`
+}
+
+// Execute runs the command.
+func (c *ListCmd) Execute(args []string) error {
+	var (
+		entries []*library.CatalogEntry
+		err     error
+	)
+
+	switch {
+	case c.Catalog != "" && c.Rebuild:
+		entries, err = c.rebuildCatalog()
+	case c.Catalog != "" && catalogExists(c.Catalog):
+		entries, err = library.ReadCatalog(c.Catalog)
+	default:
+		if c.Catalog != "" {
+			log.Warningf("catalog %s not found, reading the siva files",
+				c.Catalog)
+		}
+
+		entries, err = c.readLibrary()
+	}
+
+	if err != nil {
+		log.Errorf(err, "unable to read library contents")
+		return err
+	}
+
+	entries = filterEntries(entries, splitLower(c.Orgs), c.Locations)
+	switch c.Format {
+	case "json":
+		return printJSON(os.Stdout, entries)
+	case "csv":
+		return printCSV(os.Stdout, entries)
+	default:
+		return printText(os.Stdout, entries)
+	}
+}
+
+func catalogExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+func (c *ListCmd) library() (borges.Library, billy.Filesystem, error) {
+	fs := osfs.New(c.LibPath)
+	lib, err := siva.NewLibrary("list", fs, &siva.LibraryOptions{
+		Bucket:        c.LibBucket,
+		Transactional: true,
+	})
+
+	return lib, fs, err
+}
+
+// rebuildCatalog replaces the contents of the catalog with the entries of
+// every location of the library.
+func (c *ListCmd) rebuildCatalog() ([]*library.CatalogEntry, error) {
+	lib, fs, err := c.library()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := library.OpenCatalog(c.Catalog, fs, c.LibBucket)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCatalog(catalog)
+
+	if err := catalog.Rebuild(lib); err != nil {
+		return nil, err
+	}
+
+	return catalog.Entries(), nil
+}
+
+// readLibrary reads the entries of every location of the library.
+func (c *ListCmd) readLibrary() ([]*library.CatalogEntry, error) {
+	lib, fs, err := c.library()
+	if err != nil {
+		return nil, err
+	}
+
+	locs, err := lib.Locations()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*library.CatalogEntry
+	err = locs.ForEach(func(loc borges.Location) error {
+		e, err := library.NewCatalogEntry(loc, fs, c.LibBucket)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// filterEntries keeps the given locations and the repositories of the given
+// organizations. Locations without repositories left are discarded.
+func filterEntries(
+	entries []*library.CatalogEntry,
+	orgs []string,
+	locations string,
+) []*library.CatalogEntry {
+	locIDs := make(map[borges.LocationID]struct{})
+	for _, id := range strings.Split(locations, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			locIDs[borges.LocationID(id)] = struct{}{}
+		}
+	}
+
+	var filtered []*library.CatalogEntry
+	for _, e := range entries {
+		if _, ok := locIDs[e.Location]; len(locIDs) > 0 && !ok {
+			continue
+		}
+
+		if len(orgs) == 0 {
+			filtered = append(filtered, e)
+			continue
+		}
+
+		var repos []*library.CatalogRepository
+		for _, r := range e.Repositories {
+			if inOrgs(orgs, r) {
+				repos = append(repos, r)
+			}
+		}
+
+		if len(repos) == 0 {
+			continue
+		}
+
+		f := *e
+		f.Repositories = repos
+		filtered = append(filtered, &f)
+	}
+
+	return filtered
+}
+
+// inOrgs reports whether any endpoint of the repository, or its ID if it has
+// none, belongs to any of the given organizations, as provider.OrgsFilter
+// does for the updates.
+func inOrgs(orgs []string, r *library.CatalogRepository) bool {
+	endpoints := r.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{r.ID.String()}
+	}
+
+	for _, ep := range endpoints {
+		org := library.GetOrgFromEndpoint(ep)
+		for _, o := range orgs {
+			if o == org {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func printText(w io.Writer, entries []*library.CatalogEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOCATION\tSIZE\tREPOSITORY\tREFS\tENDPOINTS")
+	for _, e := range entries {
+		for _, r := range e.Repositories {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n",
+				e.Location, e.Size, r.ID, r.Refs,
+				strings.Join(r.Endpoints, ","),
+			)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// printJSON writes an object per location and line.
+func printJSON(w io.Writer, entries []*library.CatalogEntry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printCSV writes a record per repository.
+func printCSV(w io.Writer, entries []*library.CatalogEntry) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(
+		[]string{"location", "size", "repository", "refs", "endpoints"},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		for _, r := range e.Repositories {
+			err := cw.Write([]string{
+				string(e.Location),
+				strconv.FormatInt(e.Size, 10),
+				r.ID.String(),
+				strconv.Itoa(r.Refs),
+				strings.Join(r.Endpoints, " "),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,45 @@
+package subcmd
+
+import (
+	"net/http"
+
+	"github.com/src-d/gitcollector/server"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ServeCmd is the gitcollector subcommand to serve the repositories of a
+// library over git smart HTTP.
+type ServeCmd struct {
+	cli.Command `name:"serve" short-description:"serve the repositories of the library read-only over git smart http"`
+
+	LibPath   string `long:"library" description:"path where the library is" env:"GITCOLLECTOR_LIBRARY" required:"true"`
+	LibBucket int    `long:"bucket" description:"library bucketization level" env:"GITCOLLECTOR_LIBRARY_BUCKET" default:"2"`
+	Addr      string `long:"addr" description:"address to listen on" env:"GITCOLLECTOR_SERVE_ADDR" default:":8080"`
+}
+
+// Execute runs the command.
+func (c *ServeCmd) Execute(args []string) error {
+	lib, err := siva.NewLibrary("serve", osfs.New(c.LibPath), &siva.LibraryOptions{
+		Bucket:        c.LibBucket,
+		Transactional: true,
+	})
+	if err != nil {
+		log.Errorf(err, "unable to open borges siva library")
+		return err
+	}
+
+	handler := server.NewHTTPHandler(lib, &server.HTTPOpts{
+		Logger: log.New(log.Fields{"server": "http"}),
+	})
+
+	log.Infof("serving library %s on %s", c.LibPath, c.Addr)
+	if err := http.ListenAndServe(c.Addr, handler); err != nil {
+		log.Errorf(err, "server failed")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package subcmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/credentials"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/gitcollector/provider"
+	"github.com/src-d/gitcollector/updater"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// UpdateCmd is the gitcollector subcommand to update the repositories already
+// stored in a library.
+type UpdateCmd struct {
+	cli.Command `name:"update" short-description:"update the repositories already stored in the library"`
+	CommonOpts
+
+	Orgs            string        `long:"orgs" env:"GITHUB_ORGANIZATIONS" description:"only update locations with repositories of these organizations, separated by comma"`
+	Locations       string        `long:"locations" env:"GITCOLLECTOR_LOCATIONS" description:"only update these location IDs, separated by comma"`
+	NotUpdatedSince time.Duration `long:"not-updated-since" env:"GITCOLLECTOR_NOT_UPDATED_SINCE" description:"only update locations not modified in the given time, e.g. 72h"`
+	Interval        time.Duration `long:"interval" env:"GITCOLLECTOR_UPDATE_INTERVAL" description:"time between updates, the library is updated just once if it isn't set"`
+}
+
+// Execute runs the command.
+func (c *UpdateCmd) Execute(args []string) error {
+	start := time.Now()
+
+	orgs := splitLower(c.Orgs)
+
+	lib, _, cleanup, err := c.openLibrary("updater")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ghTokens, err := c.githubTokens()
+	if err != nil {
+		return err
+	}
+
+	var tokens credentials.Rules
+	if ghTokens.Len() > 0 {
+		tokens = append(tokens, &credentials.Rule{
+			Host:    c.githubHost(),
+			TokenFn: ghTokens.Token,
+		})
+	}
+
+	auth, err := c.auth(tokens)
+	if err != nil {
+		return err
+	}
+
+	var filters []provider.LocationFilterFn
+	if c.Locations != "" {
+		var ids []borges.LocationID
+		for _, id := range strings.Split(c.Locations, ",") {
+			ids = append(ids, borges.LocationID(id))
+		}
+
+		filters = append(filters, provider.LocationIDsFilter(ids))
+	}
+
+	if len(orgs) > 0 {
+		filters = append(filters, provider.OrgsFilter(orgs))
+	}
+
+	if c.NotUpdatedSince > 0 {
+		filters = append(filters, provider.NotUpdatedSinceFilter(
+			osfs.New(c.LibPath),
+			c.LibBucket,
+			c.NotUpdatedSince,
+		))
+	}
+
+	if c.MetricsDBURI != "" && len(orgs) == 0 {
+		log.Warningf("metrics are sent by organization, " +
+			"they won't be sent unless --orgs is provided")
+	}
+
+	catalog, err := c.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer closeCatalog(catalog)
+
+	update := make(chan gitcollector.Job, 100)
+	updateFn := c.jobFn(updater.Update, auth, catalog)
+	gauges := newPoolGauges(update)
+	mc, stopMetrics, err := c.metrics(orgs, gauges, nil)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	queue, err := c.openQueue("update")
+	if err != nil {
+		return err
+	}
+	defer closeQueue(queue)
+
+	fair, err := c.fairQueue()
+	if err != nil {
+		return err
+	}
+	defer closeFairQueue(fair)
+	gauges.setFairQueue(fair)
+
+	var schedule gitcollector.JobScheduleFn
+	switch {
+	case queue != nil:
+		schedule = library.NewQueueJobScheduleFn(
+			queue,
+			lib,
+			nil, update,
+			nil, updateFn,
+			false,
+			nil,
+			log.New(nil),
+			nil,
+			c.retry(),
+		)
+	case fair != nil:
+		schedule = library.NewFairJobScheduleFn(
+			fair,
+			lib,
+			nil, update,
+			nil, updateFn,
+			false,
+			nil,
+			log.New(nil),
+			nil,
+		)
+	default:
+		schedule = library.NewUpdateJobScheduleFn(
+			lib,
+			update,
+			updateFn,
+			nil,
+			log.New(nil),
+		)
+	}
+
+	deadLetter, err := c.openDeadLetter()
+	if err != nil {
+		return err
+	}
+	defer closeDeadLetter(deadLetter)
+
+	wp := gitcollector.NewWorkerPool(
+		schedule,
+		&gitcollector.WorkerPoolOpts{
+			Metrics:           mc,
+			Retry:             c.retry(),
+			DeadLetter:        poolDeadLetter(deadLetter),
+			JobTimeout:        c.JobTimeout,
+			SchedulerCapacity: c.schedulerCapacity(),
+		},
+	)
+
+	gauges.setPool(wp)
+
+	jobs := newJobQueue(update)
+	stopAdmin, err := c.serveAdmin(
+		wp, lib, jobs, gauges.queued, library.JobUpdate,
+	)
+	if err != nil {
+		return err
+	}
+	defer stopAdmin()
+
+	wp.SetWorkers(c.workers())
+	log.Debugf("number of workers in the pool %d", wp.Size())
+
+	wp.Run()
+	log.Debugf("worker pool is running")
+
+	p := provider.NewUpdates(lib, update, &provider.UpdatesOpts{
+		TriggerOnce:     c.Interval <= 0,
+		TriggerInterval: c.Interval,
+		Filters:         filters,
+	})
+
+	go runProviders(log.New(nil), []namedProvider{
+		{name: "library updates", provider: p},
+	}, jobs.close)
+
+	wp.Wait()
+	log.Debugf("worker pool stopped successfully")
+
+	elapsed := time.Since(start).String()
+	log.Infof("update finished in %s", elapsed)
+	return nil
+}
@@ -0,0 +1,270 @@
+package subcmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// VerifyCmd is the gitcollector subcommand to check the integrity of the
+// locations of a library.
+type VerifyCmd struct {
+	cli.Command `name:"verify" short-description:"check the integrity of the siva files of the library"`
+
+	LibPath     string        `long:"library" description:"path where the library is" env:"GITCOLLECTOR_LIBRARY" required:"true"`
+	LibBucket   int           `long:"bucket" description:"library bucketization level" env:"GITCOLLECTOR_LIBRARY_BUCKET" default:"2"`
+	Locations   string        `long:"locations" env:"GITCOLLECTOR_LOCATIONS" description:"only verify these location IDs, separated by comma"`
+	Repair      bool          `long:"repair" env:"GITCOLLECTOR_REPAIR" description:"roll back the corrupted siva files to their last valid index, or move them aside and re-enqueue their endpoints for download on the --state-dir queue"`
+	StateDir    string        `long:"state-dir" env:"GITCOLLECTOR_STATE_DIR" description:"directory of the persisted jobs queues where the endpoints of the corrupted locations are re-enqueued"`
+	Catalog     string        `long:"catalog" env:"GITCOLLECTOR_CATALOG" description:"index of the library contents used to find the endpoints of the locations which can't be read"`
+	LockTimeout time.Duration `long:"lock-timeout" env:"GITCOLLECTOR_LOCK_TIMEOUT" default:"1m" description:"maximum time to wait for the processes writing the library to repair it, 0 fails right away and a negative value waits indefinitely"`
+}
+
+// Execute runs the command.
+func (c *VerifyCmd) Execute(args []string) error {
+	if c.Repair && c.StateDir == "" {
+		err := fmt.Errorf("--state-dir is required to repair locations")
+		log.Errorf(err, "wrong options")
+		return err
+	}
+
+	ctx := context.Background()
+	if c.Repair {
+		// the locations being written could look corrupted.
+		unlock, err := c.lockLibrary(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	fs := osfs.New(c.LibPath)
+	lib, err := newVerifyLibrary(fs, c.LibBucket)
+	if err != nil {
+		log.Errorf(err, "unable to open borges siva library")
+		return err
+	}
+
+	locs, err := lib.Locations()
+	if err != nil {
+		log.Errorf(err, "unable to read locations")
+		return err
+	}
+
+	ids := make(map[borges.LocationID]struct{})
+	for _, id := range strings.Split(c.Locations, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[borges.LocationID(id)] = struct{}{}
+		}
+	}
+
+	var (
+		verified  int
+		corrupted []*library.Verification
+	)
+
+	err = locs.ForEach(func(loc borges.Location) error {
+		if _, ok := ids[loc.ID()]; len(ids) > 0 && !ok {
+			return nil
+		}
+
+		verified++
+		v := library.VerifyLocation(ctx, loc, fs, c.LibBucket)
+		if v.Corrupted() {
+			log.With(log.Fields{"location": loc.ID()}).
+				Errorf(v.Err, "corrupted location")
+			corrupted = append(corrupted, v)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Errorf(err, "unable to verify locations")
+		return err
+	}
+
+	log.Infof("%d locations verified, %d corrupted", verified, len(corrupted))
+	if len(corrupted) == 0 {
+		return nil
+	}
+
+	status := make([]string, len(corrupted))
+	for i := range status {
+		status[i] = "corrupted"
+	}
+
+	var unrepaired int
+	if c.Repair {
+		for i, v := range corrupted {
+			status[i], err = c.repair(ctx, fs, lib, v)
+			if err != nil {
+				log.With(log.Fields{"location": v.Location}).
+					Errorf(err, "unable to repair location")
+				unrepaired++
+			}
+		}
+	} else {
+		unrepaired = len(corrupted)
+	}
+
+	if err := printVerifications(corrupted, status); err != nil {
+		return err
+	}
+
+	if unrepaired > 0 {
+		return fmt.Errorf("%d corrupted locations", unrepaired)
+	}
+
+	return nil
+}
+
+// lockLibrary locks the whole library so no other process writes it while
+// its siva files are repaired.
+func (c *VerifyCmd) lockLibrary(ctx context.Context) (func(), error) {
+	locker, err := library.NewFileLocker(c.LibPath, &library.FileLockerOpts{
+		Timeout: c.LockTimeout,
+	})
+	if err != nil {
+		log.Errorf(err, "unable to create the library locks")
+		return nil, err
+	}
+
+	unlock, err := locker.LockLibrary(ctx)
+	if err != nil {
+		log.Errorf(err, "unable to lock the library to repair it")
+		return nil, err
+	}
+
+	return unlock, nil
+}
+
+// repair rolls back the siva file of a location with a corrupted index to its
+// last valid block. If it's still corrupted its siva file is moved aside and
+// its endpoints are enqueued to be downloaded again. It returns the status
+// of the location.
+func (c *VerifyCmd) repair(
+	ctx context.Context,
+	fs billy.Filesystem,
+	lib *siva.Library,
+	v *library.Verification,
+) (string, error) {
+	endpoints, err := c.endpoints(fs, lib, v.Location)
+	if err != nil {
+		return "corrupted", err
+	}
+
+	if library.ErrCorruptedIndex.Is(v.Err) {
+		size, err := library.RollbackLocation(fs, c.LibBucket, v.Location)
+		if err == nil {
+			// the library caches the state of the siva files.
+			fresh, err := newVerifyLibrary(fs, c.LibBucket)
+			if err != nil {
+				return "corrupted", err
+			}
+
+			loc, err := fresh.Location(v.Location)
+			if err != nil {
+				return "corrupted", err
+			}
+
+			rv := library.VerifyLocation(ctx, loc, fs, c.LibBucket)
+			if !rv.Corrupted() {
+				log.With(log.Fields{"location": v.Location}).
+					Infof("rolled back to %d bytes", size)
+				return "rolled back", nil
+			}
+		}
+	}
+
+	if len(endpoints) == 0 {
+		return "corrupted", fmt.Errorf("no endpoints found to download it")
+	}
+
+	path := library.LocationPath(v.Location, c.LibBucket)
+	if err := fs.Rename(path, path+".corrupted"); err != nil {
+		return "corrupted", err
+	}
+
+	jobs := make([]*library.Job, 0, len(endpoints))
+	for _, ep := range endpoints {
+		job := &library.Job{Type: library.JobDownload}
+		job.SetEndpoints([]string{ep})
+		jobs = append(jobs, job)
+	}
+
+	if err := requeue(filepath.Join(c.StateDir, "download"), jobs); err != nil {
+		return "moved aside", err
+	}
+
+	log.With(log.Fields{"location": v.Location}).
+		Infof("%d endpoints re-enqueued for download", len(jobs))
+	return "re-enqueued", nil
+}
+
+// endpoints returns the endpoints of the repositories of a location, read
+// from the location itself or from the Catalog if it can't be read.
+func (c *VerifyCmd) endpoints(
+	fs billy.Filesystem,
+	lib *siva.Library,
+	id borges.LocationID,
+) ([]string, error) {
+	var repos []*library.CatalogRepository
+	loc, err := lib.Location(id)
+	if err == nil {
+		var e *library.CatalogEntry
+		if e, err = library.NewCatalogEntry(loc, fs, c.LibBucket); err == nil {
+			repos = e.Repositories
+		}
+	}
+
+	if err != nil && c.Catalog != "" {
+		entries, err := library.ReadCatalog(c.Catalog)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.Location == id {
+				repos = e.Repositories
+			}
+		}
+	}
+
+	var endpoints []string
+	for _, r := range repos {
+		if len(r.Endpoints) > 0 {
+			endpoints = append(endpoints, r.Endpoints[0])
+		}
+	}
+
+	return endpoints, nil
+}
+
+func newVerifyLibrary(fs billy.Filesystem, bucket int) (*siva.Library, error) {
+	return siva.NewLibrary("verify", fs, &siva.LibraryOptions{
+		Bucket:        bucket,
+		Transactional: true,
+	})
+}
+
+func printVerifications(vs []*library.Verification, status []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCATION\tSTATUS\tERROR")
+	for i, v := range vs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Location, status[i], v.Err)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,142 @@
+package subcmd
+
+import (
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/cluster"
+	"github.com/src-d/gitcollector/credentials"
+	"github.com/src-d/gitcollector/downloader"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/gitcollector/updater"
+	"gopkg.in/src-d/go-cli.v0"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// WorkerCmd is the gitcollector subcommand to process the jobs served by a
+// download coordinator.
+type WorkerCmd struct {
+	cli.Command `name:"worker" short-description:"process the jobs served by a download coordinator"`
+
+	CommonOpts
+
+	Coordinator     string `long:"coordinator" env:"GITCOLLECTOR_COORDINATOR" required:"true" description:"url of the download coordinator, e.g. http://localhost:9400"`
+	Name            string `long:"name" env:"GITCOLLECTOR_WORKER_NAME" description:"name of the worker reported to the coordinator, default to the host name and the process ID"`
+	NotAllowUpdates bool   `long:"no-updates" description:"don't allow updates on already downloaded repositories" env:"GITCOLLECTOR_NO_UPDATES"`
+}
+
+// Execute runs the command.
+func (c *WorkerCmd) Execute(args []string) error {
+	start := time.Now()
+
+	lib, temp, cleanup, err := c.openLibrary("worker")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ghTokens, err := c.githubTokens()
+	if err != nil {
+		return err
+	}
+
+	var tokens credentials.Rules
+	if ghTokens.Len() > 0 {
+		tokens = append(tokens, &credentials.Rule{
+			Host:    c.githubHost(),
+			TokenFn: ghTokens.Token,
+		})
+	}
+
+	auth, err := c.auth(tokens)
+	if err != nil {
+		return err
+	}
+
+	catalog, err := c.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer closeCatalog(catalog)
+
+	client := cluster.NewClient(c.Coordinator, &cluster.ClientOpts{
+		Worker: c.Name,
+		Logger: log.New(log.Fields{"coordinator": c.Coordinator}),
+	})
+
+	// the jobs leased and not processed are delivered to other workers.
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Warningf("couldn't release jobs: %s", err.Error())
+		}
+	}()
+
+	setupJob := library.NewJobSetupFn(
+		lib,
+		c.jobFn(downloader.Download, auth, catalog),
+		c.jobFn(updater.Update, auth, catalog),
+		!c.NotAllowUpdates,
+		nil,
+		log.New(nil),
+		temp,
+	)
+
+	gauges := newPoolGauges()
+	mc, stopMetrics, err := c.metrics(nil, gauges, ghTokens)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	// the coordinator retries the failed jobs, so they're processed only
+	// once by the worker.
+	wp := gitcollector.NewWorkerPool(
+		cluster.NewJobScheduleFn(
+			client, setupJob, library.IsTransientError,
+		),
+		&gitcollector.WorkerPoolOpts{
+			Metrics:           mc,
+			JobTimeout:        c.JobTimeout,
+			SchedulerCapacity: 1,
+		},
+	)
+
+	gauges.setPool(wp)
+
+	stopAdmin, err := c.serveAdmin(wp, lib, nil, gauges.queued)
+	if err != nil {
+		return err
+	}
+	defer stopAdmin()
+
+	wp.SetWorkers(c.workers())
+	log.Debugf("number of workers in the pool %d", wp.Size())
+
+	wp.Run()
+	log.Debugf("worker pool is running")
+
+	// the first signal waits for the jobs in progress, the second one
+	// cancels them.
+	stopSignals := handleSignals(
+		func() {
+			log.Infof("stopping, waiting for the jobs in progress " +
+				"to finish, send the signal again to exit " +
+				"immediately")
+
+			go wp.Close()
+		},
+		func() {
+			log.Warningf("exiting immediately, the jobs in " +
+				"progress are rolled back")
+			wp.Stop()
+		},
+	)
+	defer stopSignals()
+
+	wp.Wait()
+	log.Debugf("worker pool stopped successfully")
+
+	elapsed := time.Since(start).String()
+	log.Infof("worker finished in %s", elapsed)
+	return nil
+}
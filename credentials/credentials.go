@@ -0,0 +1,67 @@
+package credentials
+
+import (
+	"strings"
+
+	"github.com/src-d/gitcollector/library"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// Credentials are the username and password, or token, to authenticate on
+// an endpoint.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Source retrieves the Credentials for an endpoint.
+type Source interface {
+	// Get returns the Credentials for the given endpoint or nil if the
+	// source has none.
+	Get(ep *transport.Endpoint) (*Credentials, error)
+}
+
+// DefaultUsername is the username used with the tokens without one.
+const DefaultUsername = "gitcollector"
+
+// NewAuthMethodFn builds a library.AuthMethodFn which authenticates the http
+// endpoints with the Credentials of the first source having them. It returns
+// nil for any other endpoint.
+func NewAuthMethodFn(sources ...Source) library.AuthMethodFn {
+	return func(endpoint string) (transport.AuthMethod, error) {
+		ep, err := transport.NewEndpoint(endpoint)
+		if err != nil || (ep.Protocol != "http" && ep.Protocol != "https") {
+			return nil, nil
+		}
+
+		for _, s := range sources {
+			c, err := s.Get(ep)
+			if err != nil {
+				return nil, err
+			}
+
+			if c == nil {
+				continue
+			}
+
+			username := c.Username
+			if username == "" {
+				username = DefaultUsername
+			}
+
+			return &http.BasicAuth{
+				Username: username,
+				Password: c.Password,
+			}, nil
+		}
+
+		return nil, nil
+	}
+}
+
+// Org returns the organization, or the first path element, of the endpoint.
+func Org(ep *transport.Endpoint) string {
+	path := strings.Trim(ep.Path, "/")
+	return strings.ToLower(strings.SplitN(path, "/", 2)[0])
+}
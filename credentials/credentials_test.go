@@ -0,0 +1,236 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+func TestRules(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-credentials")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "credentials.json")
+	require.NoError(ioutil.WriteFile(file, []byte(`[
+		{"host": "github.com", "org": "src-d", "token": "a"},
+		{"host": "github.com", "org": "bbl*", "username": "foo", "token": "b"},
+		{"host": "gitlab.*", "token": "c"}
+	]`), 0644))
+
+	rules, err := LoadRules(file)
+	require.NoError(err)
+
+	for ep, expected := range map[string]*Credentials{
+		"https://github.com/src-d/gitcollector":  {Password: "a"},
+		"https://github.com/bblfsh/sdk.git":      {Username: "foo", Password: "b"},
+		"https://gitlab.example.com/foo/bar.git": {Password: "c"},
+		"https://github.com/golang/go":           nil,
+	} {
+		require.Equal(expected, get(t, rules, ep), ep)
+	}
+
+	token := "d"
+	rules = Rules{{Org: "golang", TokenFn: func() string { return token }}}
+	ep := "https://github.com/golang/go"
+	require.Equal(&Credentials{Password: "d"}, get(t, rules, ep))
+	token = "e"
+	require.Equal(&Credentials{Password: "e"}, get(t, rules, ep))
+
+	require.NoError(ioutil.WriteFile(file, []byte(`{}`), 0644))
+	_, err = LoadRules(file)
+	require.True(ErrWrongRules.Is(err))
+}
+
+func TestNetrc(t *testing.T) {
+	var require = require.New(t)
+
+	n := ParseNetrc(`
+# comment
+machine github.com login foo password bar
+machine gitlab.com
+	login baz
+	password qux
+
+macdef init
+	cd /foo
+
+default login anonymous password secret
+`)
+
+	require.Equal(
+		&Credentials{Username: "foo", Password: "bar"},
+		get(t, n, "https://github.com/src-d/gitcollector"),
+	)
+	require.Equal(
+		&Credentials{Username: "baz", Password: "qux"},
+		get(t, n, "https://gitlab.com/foo/bar"),
+	)
+	require.Equal(
+		&Credentials{Username: "anonymous", Password: "secret"},
+		get(t, n, "https://example.com/foo/bar"),
+	)
+}
+
+func TestEnv(t *testing.T) {
+	var require = require.New(t)
+
+	env := map[string]string{
+		"FOO_GITHUB_COM_SRC_D": "user:a",
+		"FOO_GITHUB_COM":       "b",
+	}
+
+	e := NewEnv("FOO")
+	e.lookup = func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	require.Equal(
+		&Credentials{Username: "user", Password: "a"},
+		get(t, e, "https://github.com/src-d/gitcollector"),
+	)
+	require.Equal(
+		&Credentials{Password: "b"},
+		get(t, e, "https://github.com/bblfsh/sdk"),
+	)
+	require.Nil(get(t, e, "https://gitlab.com/src-d/gitcollector"))
+}
+
+func TestGitHubApp(t *testing.T) {
+	var require = require.New(t)
+
+	g := &GitHubApp{
+		host: "ghe.example.com",
+		token: func(_ context.Context, owner string) (string, error) {
+			if owner != "src-d" {
+				return "", discovery.ErrInstallationNotFound.New(owner)
+			}
+
+			return "a", nil
+		},
+	}
+
+	require.Equal(
+		&Credentials{Username: "x-access-token", Password: "a"},
+		get(t, g, "https://ghe.example.com/src-d/gitcollector"),
+	)
+	require.Nil(get(t, g, "https://ghe.example.com/bblfsh/sdk"))
+	require.Nil(get(t, g, "https://github.com/src-d/gitcollector"))
+}
+
+func TestHelper(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-credentials")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "helper.sh")
+	require.NoError(ioutil.WriteFile(script, []byte(`#!/bin/sh
+test "$1" = get || exit 1
+cat >> `+calls+`
+if grep -q host=github.com `+calls+`; then
+	echo username=foo
+	echo password=bar
+fi
+`), 0755))
+
+	// the helper is run once per host, even for concurrent requests.
+	h := NewHelper(script, nil)
+	var (
+		wg    sync.WaitGroup
+		repos = []string{"gitcollector", "go-borges", "go-git"}
+		creds = make([]*Credentials, len(repos))
+		errs  = make([]error, len(repos))
+	)
+
+	for i, repo := range repos {
+		ep, err := transport.NewEndpoint(
+			"https://github.com/src-d/" + repo + ".git",
+		)
+		require.NoError(err)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			creds[i], errs[i] = h.Get(ep)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range repos {
+		require.NoError(errs[i])
+		require.Equal(
+			&Credentials{Username: "foo", Password: "bar"},
+			creds[i],
+		)
+	}
+
+	input, err := ioutil.ReadFile(calls)
+	require.NoError(err)
+	require.Equal("protocol=https\nhost=github.com\n\n", string(input))
+
+	require.NoError(os.Remove(calls))
+	h = NewHelper(script, &HelperOpts{UseHTTPPath: true})
+	for i := 0; i < 2; i++ {
+		require.Equal(
+			&Credentials{Username: "foo", Password: "bar"},
+			get(t, h, "https://github.com/src-d/gitcollector.git"),
+		)
+	}
+
+	input, err = ioutil.ReadFile(calls)
+	require.NoError(err)
+	require.Equal(
+		"protocol=https\nhost=github.com\npath=src-d/gitcollector.git\n\n",
+		string(input),
+	)
+
+	h = NewHelper("!false", nil)
+	ep, err := transport.NewEndpoint("https://github.com/src-d/foo")
+	require.NoError(err)
+	_, err = h.Get(ep)
+	require.True(ErrHelper.Is(err))
+}
+
+func TestNewAuthMethodFn(t *testing.T) {
+	var require = require.New(t)
+
+	fn := NewAuthMethodFn(
+		Rules{{Org: "src-d", Token: "a"}},
+		Rules{{Username: "foo", Token: "b"}},
+	)
+
+	auth, err := fn("https://github.com/src-d/gitcollector")
+	require.NoError(err)
+	require.Equal(&http.BasicAuth{Username: DefaultUsername, Password: "a"}, auth)
+
+	auth, err = fn("https://github.com/bblfsh/sdk")
+	require.NoError(err)
+	require.Equal(&http.BasicAuth{Username: "foo", Password: "b"}, auth)
+
+	auth, err = fn("git@github.com:src-d/gitcollector.git")
+	require.NoError(err)
+	require.Nil(auth)
+}
+
+func get(t *testing.T, s Source, endpoint string) *Credentials {
+	ep, err := transport.NewEndpoint(endpoint)
+	require.NoError(t, err)
+
+	c, err := s.Get(ep)
+	require.NoError(t, err)
+	return c
+}
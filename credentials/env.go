@@ -0,0 +1,71 @@
+package credentials
+
+import (
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// EnvPrefix is the default prefix of the environment variables read by an
+// Env source.
+const EnvPrefix = "GITCOLLECTOR_CREDENTIALS"
+
+// Env is a Source reading the credentials from environment variables named
+// after the host and organization of the endpoints. For an endpoint such as
+// https://github.com/src-d/gitcollector the variables
+// <PREFIX>_GITHUB_COM_SRC_D and <PREFIX>_GITHUB_COM are looked up in that
+// order. Their values are a token or a username and a token separated by a
+// colon.
+type Env struct {
+	prefix string
+	lookup func(string) (string, bool)
+}
+
+var _ Source = (*Env)(nil)
+
+// NewEnv builds a new Env source with the given prefix, EnvPrefix by
+// default.
+func NewEnv(prefix string) *Env {
+	if prefix == "" {
+		prefix = EnvPrefix
+	}
+
+	return &Env{prefix: prefix, lookup: os.LookupEnv}
+}
+
+// Get implements the Source interface.
+func (e *Env) Get(ep *transport.Endpoint) (*Credentials, error) {
+	host := envName(ep.Host)
+	for _, name := range []string{
+		e.prefix + "_" + host + "_" + envName(Org(ep)),
+		e.prefix + "_" + host,
+	} {
+		value, ok := e.lookup(name)
+		if !ok || value == "" {
+			continue
+		}
+
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) == 1 {
+			return &Credentials{Password: value}, nil
+		}
+
+		return &Credentials{Username: parts[0], Password: parts[1]}, nil
+	}
+
+	return nil, nil
+}
+
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
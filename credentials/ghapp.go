@@ -0,0 +1,49 @@
+package credentials
+
+import (
+	"context"
+	"strings"
+
+	"github.com/src-d/gitcollector/discovery"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// GitHubApp is a Source returning the access token of the installation of a
+// github app in the organization of the endpoints of its host.
+type GitHubApp struct {
+	host  string
+	token func(ctx context.Context, owner string) (string, error)
+}
+
+var _ Source = (*GitHubApp)(nil)
+
+// NewGitHubApp builds a new GitHubApp source for the endpoints of the given
+// host, github.com by default.
+func NewGitHubApp(host string, app *discovery.GHApp) *GitHubApp {
+	if host == "" {
+		host = discovery.DefaultGHHost
+	}
+
+	return &GitHubApp{host: strings.ToLower(host), token: app.OwnerToken}
+}
+
+// Get implements the Source interface.
+func (g *GitHubApp) Get(ep *transport.Endpoint) (*Credentials, error) {
+	if strings.ToLower(ep.Host) != g.host {
+		return nil, nil
+	}
+
+	token, err := g.token(context.Background(), Org(ep))
+	if err != nil {
+		if discovery.ErrInstallationNotFound.Is(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &Credentials{
+		Username: discovery.AppTokenUsername,
+		Password: token,
+	}, nil
+}
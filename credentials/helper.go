@@ -0,0 +1,175 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// ErrHelper is returned when a credential helper fails.
+var ErrHelper = errors.NewKind("credential helper %s failed")
+
+const helperTimeout = 30 * time.Second
+
+// HelperOpts represents configuration options for a Helper.
+type HelperOpts struct {
+	// UseHTTPPath sends the path of the repositories to the helper, as the
+	// git credential.useHttpPath option, so their credentials are
+	// requested apart. By default they're requested once per host.
+	UseHTTPPath bool
+}
+
+// Helper is a Source which retrieves the credentials from an external
+// command following the git credential helper protocol. The command is
+// given as in the git credential.helper option: a helper name run as
+// git credential-<name>, an absolute path, or a shell snippet starting with
+// an exclamation mark. The credentials are requested once per host, or per
+// repository with UseHTTPPath.
+type Helper struct {
+	command string
+	opts    *HelperOpts
+	mu      sync.Mutex
+	cache   map[string]*helperCall
+}
+
+// helperCall is a request to the helper, its result is kept unless it fails.
+type helperCall struct {
+	done chan struct{}
+	c    *Credentials
+	err  error
+}
+
+var _ Source = (*Helper)(nil)
+
+// NewHelper builds a new Helper running the given command.
+func NewHelper(command string, opts *HelperOpts) *Helper {
+	if opts == nil {
+		opts = &HelperOpts{}
+	}
+
+	return &Helper{
+		command: command,
+		opts:    opts,
+		cache:   map[string]*helperCall{},
+	}
+}
+
+// Get implements the Source interface. The helper is run once for
+// concurrent requests of the same credentials.
+func (h *Helper) Get(ep *transport.Endpoint) (*Credentials, error) {
+	var path string
+	if h.opts.UseHTTPPath {
+		path = strings.TrimPrefix(ep.Path, "/")
+	}
+
+	key := ep.Protocol + "://" + ep.User + "@" + hostPort(ep) + "/" + path
+
+	h.mu.Lock()
+	call, ok := h.cache[key]
+	if !ok {
+		call = &helperCall{done: make(chan struct{})}
+		h.cache[key] = call
+	}
+	h.mu.Unlock()
+
+	if ok {
+		<-call.done
+		return call.c, call.err
+	}
+
+	call.c, call.err = h.run(ep, path)
+	if call.err != nil {
+		// the next requests run the helper again.
+		h.mu.Lock()
+		delete(h.cache, key)
+		h.mu.Unlock()
+	}
+
+	close(call.done)
+	return call.c, call.err
+}
+
+func (h *Helper) run(ep *transport.Endpoint, path string) (*Credentials, error) {
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=%s\n", ep.Protocol)
+	fmt.Fprintf(&input, "host=%s\n", hostPort(ep))
+	if path != "" {
+		fmt.Fprintf(&input, "path=%s\n", path)
+	}
+
+	if ep.User != "" {
+		fmt.Fprintf(&input, "username=%s\n", ep.User)
+	}
+	input.WriteString("\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+
+	cmd := h.cmd(ctx)
+	cmd.Stdin = &input
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, ErrHelper.Wrap(err, h.command)
+	}
+
+	return parseHelperOutput(out), nil
+}
+
+func (h *Helper) cmd(ctx context.Context) *exec.Cmd {
+	switch {
+	case strings.HasPrefix(h.command, "!"):
+		return exec.CommandContext(
+			ctx, "sh", "-c", h.command[1:]+" get",
+		)
+	case filepath.IsAbs(h.command):
+		return exec.CommandContext(ctx, "sh", "-c", h.command+" get")
+	default:
+		return exec.CommandContext(
+			ctx, "sh", "-c", "git credential-"+h.command+" get",
+		)
+	}
+}
+
+func hostPort(ep *transport.Endpoint) string {
+	if ep.Port == 0 {
+		return ep.Host
+	}
+
+	return fmt.Sprintf("%s:%d", ep.Host, ep.Port)
+}
+
+func parseHelperOutput(out []byte) *Credentials {
+	var (
+		c       Credentials
+		scanner = bufio.NewScanner(bytes.NewReader(out))
+	)
+
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "username":
+			c.Username = parts[1]
+		case "password":
+			c.Password = parts[1]
+		}
+	}
+
+	if c.Password == "" {
+		return nil
+	}
+
+	return &c
+}
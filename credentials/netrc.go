@@ -0,0 +1,114 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// Netrc is a Source with the credentials of a netrc file.
+type Netrc struct {
+	machines map[string]*Credentials
+	fallback *Credentials
+}
+
+var _ Source = (*Netrc)(nil)
+
+// DefaultNetrcPath returns the path of the netrc file of the user, given by
+// the NETRC environment variable or ~/.netrc.
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc parses the netrc file in the given path.
+func LoadNetrc(path string) (*Netrc, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseNetrc(string(data)), nil
+}
+
+// ParseNetrc parses the content of a netrc file. Macro definitions are
+// skipped.
+func ParseNetrc(content string) *Netrc {
+	var (
+		n       = &Netrc{machines: map[string]*Credentials{}}
+		current *Credentials
+		lines   = strings.Split(content, "\n")
+	)
+
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "#") {
+			continue
+		}
+
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			next := func() string {
+				if j+1 >= len(fields) {
+					return ""
+				}
+
+				j++
+				return fields[j]
+			}
+
+			switch fields[j] {
+			case "machine":
+				current = &Credentials{}
+				n.machines[strings.ToLower(next())] = current
+			case "default":
+				current = &Credentials{}
+				n.fallback = current
+			case "login":
+				if current != nil {
+					current.Username = next()
+				}
+			case "password":
+				if current != nil {
+					current.Password = next()
+				}
+			case "account":
+				next()
+			case "macdef":
+				// a macro lasts until an empty line.
+				for i+1 < len(lines) &&
+					strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+
+				j = len(fields)
+			}
+		}
+	}
+
+	return n
+}
+
+// Get implements the Source interface.
+func (n *Netrc) Get(ep *transport.Endpoint) (*Credentials, error) {
+	c, ok := n.machines[strings.ToLower(ep.Host)]
+	if !ok {
+		c = n.fallback
+	}
+
+	if c == nil || c.Password == "" {
+		return nil, nil
+	}
+
+	return c, nil
+}
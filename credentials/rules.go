@@ -0,0 +1,102 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// ErrWrongRules is returned when a credentials file can't be parsed.
+var ErrWrongRules = errors.NewKind("wrong credentials file %s")
+
+// Rule maps the endpoints matching the Host and Org glob patterns to a
+// token. Empty patterns match any host or organization.
+type Rule struct {
+	Host     string `json:"host"`
+	Org      string `json:"org"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	// TokenFn returns the token when it changes over time, e.g. the
+	// token with most remaining quota of a discovery.TokenPool. It
+	// takes precedence over Token.
+	TokenFn func() string `json:"-"`
+}
+
+func (r *Rule) token() string {
+	if r.TokenFn != nil {
+		return r.TokenFn()
+	}
+
+	return r.Token
+}
+
+func (r *Rule) match(ep *transport.Endpoint) bool {
+	return matchGlob(r.Host, strings.ToLower(ep.Host)) &&
+		matchGlob(r.Org, Org(ep))
+}
+
+func matchGlob(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	ok, err := path.Match(strings.ToLower(pattern), value)
+	return err == nil && ok
+}
+
+// Rules is a Source which returns the token of the first Rule matching an
+// endpoint.
+type Rules []*Rule
+
+var _ Source = Rules(nil)
+
+// LoadRules reads the Rules from a JSON file with a list of rules such as:
+//
+//	[
+//		{"host": "github.com", "org": "src-d", "token": "..."},
+//		{"host": "gitlab.*", "username": "oauth2", "token": "..."}
+//	]
+func LoadRules(file string) (Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules Rules
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, ErrWrongRules.Wrap(err, file)
+	}
+
+	for _, r := range rules {
+		for _, p := range []string{r.Host, r.Org} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, ErrWrongRules.Wrap(err, file)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// Get implements the Source interface.
+func (r Rules) Get(ep *transport.Endpoint) (*Credentials, error) {
+	for _, rule := range r {
+		if !rule.match(ep) {
+			continue
+		}
+
+		if token := rule.token(); token != "" {
+			return &Credentials{
+				Username: rule.Username,
+				Password: token,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,197 @@
+package discovery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/google/go-github/v28/github"
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+// ErrCheckpointsCorrupted is returned when the file of a CheckpointStore
+// can't be read.
+var ErrCheckpointsCorrupted = errors.NewKind("discovery checkpoints %s corrupted")
+
+// GHCheckpoint is the point where a GHRepositoriesIter listing the
+// repositories of an account resumes the discovery.
+type GHCheckpoint struct {
+	// Page is the next page to request.
+	Page int `json:"page"`
+	// Offset is the number of repositories of the page already found.
+	Offset int `json:"offset"`
+	// LastRepoID is the ID of the last repository found in the page, it's
+	// used to find the offset again if the page changed meanwhile.
+	LastRepoID int64 `json:"last_repo_id,omitempty"`
+	// ETag is the one of the last response of the page, it's sent to
+	// request the page only if it changed.
+	ETag string `json:"etag,omitempty"`
+}
+
+// CheckpointStore persists the GHCheckpoints of the iterators in a JSON file,
+// so the discovery resumes after a restart instead of listing again all the
+// repositories. A checkpoint is only persisted once all the repositories
+// found before it are acknowledged, so the ones found but not yet recorded
+// elsewhere are found again after a restart.
+type CheckpointStore struct {
+	path        string
+	mu          sync.Mutex
+	checkpoints map[string]*GHCheckpoint
+	pending     map[string][]*pendingCheckpoint
+}
+
+// pendingCheckpoint is either a repository waiting to be acknowledged or a
+// checkpoint waiting for the repositories before it.
+type pendingCheckpoint struct {
+	repo *github.Repository
+	done bool
+	cp   *GHCheckpoint
+}
+
+// OpenCheckpointStore opens the CheckpointStore at the given path, it's
+// created on the first checkpoint if it doesn't exist.
+func OpenCheckpointStore(path string) (*CheckpointStore, error) {
+	s := &CheckpointStore{
+		path:        path,
+		checkpoints: make(map[string]*GHCheckpoint),
+		pending:     make(map[string][]*pendingCheckpoint),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.checkpoints); err != nil {
+		return nil, ErrCheckpointsCorrupted.Wrap(err, path)
+	}
+
+	return s, nil
+}
+
+// Get returns the checkpoint of the given iterator, or nil if it has none.
+func (s *CheckpointStore) Get(key string) *GHCheckpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp, ok := s.checkpoints[key]
+	if !ok {
+		return nil
+	}
+
+	c := *cp
+	return &c
+}
+
+// Set persists the checkpoint of the given iterator.
+func (s *CheckpointStore) Set(key string, cp *GHCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *cp
+	s.checkpoints[key] = &c
+	return s.write()
+}
+
+// found registers a repository found by the given iterator, its next
+// checkpoints wait for it to be acknowledged.
+func (s *CheckpointStore) found(key string, repo *github.Repository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[key] = append(s.pending[key], &pendingCheckpoint{repo: repo})
+}
+
+// checkpoint persists the checkpoint of the given iterator once the
+// repositories it found before are acknowledged.
+func (s *CheckpointStore) checkpoint(key string, cp *GHCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *cp
+	s.pending[key] = append(s.pending[key], &pendingCheckpoint{cp: &c})
+	return s.flush(key)
+}
+
+// Acknowledge notifies the given repository was handled, e.g. recorded as a
+// job in a persistent queue or filtered out. The checkpoints waiting for it
+// are persisted.
+func (s *CheckpointStore) Acknowledge(repo *github.Repository) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, pending := range s.pending {
+		for _, p := range pending {
+			if p.repo == repo && !p.done {
+				p.done = true
+				return s.flush(key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flush persists the last checkpoint of the given iterator which isn't
+// waiting for any repository.
+func (s *CheckpointStore) flush(key string) error {
+	var (
+		pending = s.pending[key]
+		changed bool
+	)
+
+	for len(pending) > 0 && (pending[0].cp != nil || pending[0].done) {
+		if pending[0].cp != nil {
+			s.checkpoints[key] = pending[0].cp
+			changed = true
+		}
+
+		pending = pending[1:]
+	}
+
+	if len(pending) == 0 {
+		delete(s.pending, key)
+	} else {
+		s.pending[key] = pending
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return s.write()
+}
+
+// Reset removes all the checkpoints, so the discovery starts over.
+func (s *CheckpointStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints = make(map[string]*GHCheckpoint)
+	s.pending = make(map[string][]*pendingCheckpoint)
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// write replaces the file with the current checkpoints.
+func (s *CheckpointStore) write() error {
+	data, err := json.MarshalIndent(s.checkpoints, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
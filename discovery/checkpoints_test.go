@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-checkpoints")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoints.json")
+	s, err := OpenCheckpointStore(path)
+	require.NoError(err)
+	require.Nil(s.Get("foo"))
+
+	cp := &GHCheckpoint{Page: 3, Offset: 1, LastRepoID: 42, ETag: `"a"`}
+	require.NoError(s.Set("foo", cp))
+	cp.Page = 4
+	require.Equal(3, s.Get("foo").Page)
+
+	s, err = OpenCheckpointStore(path)
+	require.NoError(err)
+	require.Equal(
+		&GHCheckpoint{Page: 3, Offset: 1, LastRepoID: 42, ETag: `"a"`},
+		s.Get("foo"),
+	)
+
+	// the checkpoints are persisted once all the repositories found
+	// before them are acknowledged, in any order.
+	r1, r2, r3 := &github.Repository{}, &github.Repository{}, &github.Repository{}
+	s.found("bar", r1)
+	s.found("bar", r2)
+	require.NoError(s.checkpoint("bar", &GHCheckpoint{Page: 1}))
+	s.found("bar", r3)
+	require.NoError(s.checkpoint("bar", &GHCheckpoint{Page: 2}))
+	require.NoError(s.checkpoint("bar", &GHCheckpoint{Page: 3}))
+	require.Nil(s.Get("bar"))
+
+	require.NoError(s.Acknowledge(&github.Repository{}))
+	require.NoError(s.Acknowledge(r2))
+	require.Nil(s.Get("bar"))
+	require.NoError(s.Acknowledge(r3))
+	require.Nil(s.Get("bar"))
+	require.NoError(s.Acknowledge(r1))
+	require.Equal(3, s.Get("bar").Page)
+	require.NoError(s.checkpoint("bar", &GHCheckpoint{Page: 4}))
+	require.Equal(4, s.Get("bar").Page)
+
+	require.NoError(s.Reset())
+	require.Nil(s.Get("foo"))
+	_, err = os.Stat(path)
+	require.True(os.IsNotExist(err))
+
+	require.NoError(ioutil.WriteFile(path, []byte("foo"), 0644))
+	_, err = OpenCheckpointStore(path)
+	require.True(ErrCheckpointsCorrupted.Is(err))
+}
+
+func TestGHOrgReposIterCheckpoints(t *testing.T) {
+	var require = require.New(t)
+
+	var (
+		mu       sync.Mutex
+		repos    []*github.Repository
+		requests int
+	)
+
+	addRepo := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		id := len(repos)
+		repos = append(repos, &github.Repository{
+			ID:   github.Int64(int64(id)),
+			Name: github.String(fmt.Sprintf("repo%d", id)),
+		})
+	}
+
+	for i := 0; i < 5; i++ {
+		addRepo()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests++
+
+			etag := fmt.Sprintf(`"%d"`, len(repos))
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("ETag", etag)
+			listHandler(t, w, r, repos,
+				func(page []*github.Repository) interface{} {
+					return page
+				},
+			)
+		},
+	))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gitcollector-checkpoints")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoints.json")
+	store, err := OpenCheckpointStore(path)
+	require.NoError(err)
+
+	next := func(ack bool) []string {
+		iter := NewGHOrgReposIter("foo", nil, &GHReposIterOpts{
+			ResultsPerPage: 2,
+			Checkpoints:    store,
+		})
+		iter.client.BaseURL = newTestGHClient(t, server).BaseURL
+
+		var names []string
+		for {
+			repo, _, err := iter.Next(context.Background())
+			if err != nil {
+				require.True(ErrNewRepositoriesNotFound.Is(err))
+				return names
+			}
+
+			names = append(names, repo.GetName())
+			if ack {
+				require.NoError(store.Acknowledge(repo))
+			}
+		}
+	}
+
+	// the checkpoint doesn't go past the repositories not acknowledged.
+	require.Len(next(false), 5)
+	require.Nil(store.Get("github.com/orgs/foo"))
+
+	store, err = OpenCheckpointStore(path)
+	require.NoError(err)
+
+	require.Equal([]string{
+		"repo0", "repo1", "repo2", "repo3", "repo4",
+	}, next(true))
+	require.Equal(
+		&GHCheckpoint{Page: 3, Offset: 1, LastRepoID: 4, ETag: `"5"`},
+		store.Get("github.com/orgs/foo"),
+	)
+
+	// a restart resumes from the last page, which didn't change.
+	requests = 0
+	require.Empty(next(true))
+	require.Equal(1, requests)
+
+	addRepo()
+	addRepo()
+	require.Equal([]string{"repo5", "repo6"}, next(true))
+	require.Equal(
+		&GHCheckpoint{Page: 4, Offset: 1, LastRepoID: 6, ETag: `"7"`},
+		store.Get("github.com/orgs/foo"),
+	)
+
+	require.NoError(store.Reset())
+	require.Len(next(true), 7)
+}
@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+var (
+	// ErrWrongGHURL is returned when the url of a github enterprise server
+	// can't be parsed.
+	ErrWrongGHURL = errors.NewKind("wrong github url %q")
+
+	// ErrWrongCABundle is returned when a CA bundle has no PEM
+	// certificates.
+	ErrWrongCABundle = errors.NewKind("no certificates found in %s")
+)
+
+const (
+	// DefaultGHHost is the git host of the repositories found in github.
+	DefaultGHHost = "github.com"
+
+	ghAPIHost          = "api.github.com"
+	ghEnterpriseAPI    = "api/v3/"
+	ghEnterpriseUpload = "api/uploads/"
+)
+
+// ParseGHEnterpriseURLs parses the API and upload urls of a github enterprise
+// server. A url without path, such as https://github.example.com, gets the
+// default path of the API, /api/v3/, or the uploads, /api/uploads/. If the
+// upload url is empty the default one of the API host is used.
+func ParseGHEnterpriseURLs(api, upload string) (*url.URL, *url.URL, error) {
+	apiURL, err := parseGHURL(api, ghEnterpriseAPI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if upload == "" {
+		upload = (&url.URL{Scheme: apiURL.Scheme, Host: apiURL.Host}).String()
+	}
+
+	uploadURL, err := parseGHURL(upload, ghEnterpriseUpload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return apiURL, uploadURL, nil
+}
+
+func parseGHURL(raw, defPath string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, ErrWrongGHURL.Wrap(err, raw)
+	}
+
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, ErrWrongGHURL.New(raw)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/" + defPath
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u, nil
+}
+
+// GHGitHost returns the host of the repositories found through the given
+// github API url, the github.com one if it's nil.
+func GHGitHost(api *url.URL) string {
+	if api == nil || api.Hostname() == "" ||
+		strings.EqualFold(api.Hostname(), ghAPIHost) {
+		return DefaultGHHost
+	}
+
+	return strings.ToLower(api.Hostname())
+}
+
+// NewCATransport builds an http.Transport trusting the certificates of the
+// given PEM bundle along with the ones of the system, e.g. to reach a github
+// enterprise server with a self-signed certificate.
+func NewCATransport(bundle string) (*http.Transport, error) {
+	pem, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrWrongCABundle.New(bundle)
+	}
+
+	// the same settings of the http.DefaultTransport.
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{RootCAs: pool},
+	}, nil
+}
@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGHEnterpriseURLs(t *testing.T) {
+	var require = require.New(t)
+
+	api, upload, err := ParseGHEnterpriseURLs("https://ghe.example.com", "")
+	require.NoError(err)
+	require.Equal("https://ghe.example.com/api/v3/", api.String())
+	require.Equal("https://ghe.example.com/api/uploads/", upload.String())
+	require.Equal("ghe.example.com", GHGitHost(api))
+
+	api, upload, err = ParseGHEnterpriseURLs(
+		"http://ghe.example.com:8080/github/api",
+		"http://uploads.example.com/",
+	)
+	require.NoError(err)
+	require.Equal("http://ghe.example.com:8080/github/api/", api.String())
+	require.Equal("http://uploads.example.com/api/uploads/", upload.String())
+	require.Equal("ghe.example.com", GHGitHost(api))
+
+	for _, raw := range []string{"ghe.example.com", "ftp://ghe", ":foo"} {
+		_, _, err = ParseGHEnterpriseURLs(raw, "")
+		require.True(ErrWrongGHURL.Is(err), raw)
+	}
+
+	api, err = url.Parse("https://api.github.com/")
+	require.NoError(err)
+	require.Equal(DefaultGHHost, GHGitHost(api))
+	require.Equal(DefaultGHHost, GHGitHost(nil))
+}
+
+func TestGHEnterprise(t *testing.T) {
+	var require = require.New(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			require.Equal("/api/v3/orgs/foo/repos", r.URL.Path)
+			listHandler(t, w, r, []*github.Repository{{
+				Name:     github.String("bar"),
+				CloneURL: github.String("https://ghe/foo/bar.git"),
+			}}, func(page []*github.Repository) interface{} {
+				return page
+			})
+		},
+	))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gitcollector-ca")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	require.NoError(ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0644))
+
+	api, upload, err := ParseGHEnterpriseURLs(server.URL, "")
+	require.NoError(err)
+
+	// the self-signed certificate isn't trusted by default.
+	iter := NewGHOrgReposIter("foo", nil, &GHReposIterOpts{
+		BaseURL:   api,
+		UploadURL: upload,
+	})
+	_, _, err = iter.Next(context.Background())
+	require.Error(err)
+	require.False(ErrNewRepositoriesNotFound.Is(err))
+
+	transport, err := NewCATransport(bundle)
+	require.NoError(err)
+
+	iter = NewGHOrgReposIter("foo", nil, &GHReposIterOpts{
+		BaseURL:   api,
+		UploadURL: upload,
+		Transport: transport,
+	})
+	repo, _, err := iter.Next(context.Background())
+	require.NoError(err)
+	require.Equal("bar", repo.GetName())
+
+	require.NoError(ioutil.WriteFile(bundle, []byte("foo"), 0644))
+	_, err = NewCATransport(bundle)
+	require.True(ErrWrongCABundle.Is(err))
+}
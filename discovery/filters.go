@@ -0,0 +1,338 @@
+package discovery
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+// ErrWrongFilter is returned when a repository filter expression can't be
+// parsed.
+var ErrWrongFilter = errors.NewKind("wrong repository filter %q: %s")
+
+// RepositoryFilter decides whether a discovered repository is advertised.
+type RepositoryFilter struct {
+	// Name describes the filter, it's the reason reported for the
+	// repositories filtered out.
+	Name string
+	// Accept reports whether the given repository is advertised.
+	Accept func(*github.Repository) bool
+}
+
+// FilteredGHRepositoryFn is used by a GitHub to notify that a discovered
+// repository was filtered out, along with the reason.
+type FilteredGHRepositoryFn func(repo *github.Repository, reason string)
+
+// forksFilter is used by a GitHub to skip the forked repositories.
+var forksFilter = &RepositoryFilter{
+	Name: "fork",
+	Accept: func(r *github.Repository) bool {
+		return !r.GetFork()
+	},
+}
+
+// filterOps are the operators of the filter expressions, the longer first.
+var filterOps = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+// ParseRepositoryFilter parses a filter expression with the form
+// <field><operator><value>, e.g. stars>=100. The fields are:
+//   - stars, size (in KB): compared with =, !=, <, <=, > or >=.
+//   - language, license, topic: = or != to a list of values separated by
+//     comma, compared case insensitively. The licenses are SPDX IDs.
+//   - archived, disabled: = or != to true or false.
+//   - pushed: compared with <, <=, > or >= to a date, such as 2019-06-01
+//     or 2019-06-01T10:00:00Z, or to a duration ago, such as 30d or 12h.
+//   - name: = or != to a list of glob patterns separated by comma, or ~
+//     or !~ to a regular expression. The name or the full name of the
+//     repository, owner/name, must match.
+func ParseRepositoryFilter(expr string) (*RepositoryFilter, error) {
+	expr = strings.TrimSpace(expr)
+	i := strings.IndexAny(expr, "!=~<>")
+	if i <= 0 {
+		return nil, ErrWrongFilter.New(expr, "operator not found")
+	}
+
+	field := strings.ToLower(strings.TrimSpace(expr[:i]))
+	var op string
+	for _, o := range filterOps {
+		if strings.HasPrefix(expr[i:], o) {
+			op = o
+			break
+		}
+	}
+
+	if op == "" {
+		return nil, ErrWrongFilter.New(expr, "operator not found")
+	}
+
+	value := strings.TrimSpace(expr[i+len(op):])
+	if value == "" {
+		return nil, ErrWrongFilter.New(expr, "value not found")
+	}
+
+	var (
+		accept func(*github.Repository) bool
+		err    error
+	)
+
+	switch field {
+	case "stars":
+		accept, err = intFilter(op, value,
+			(*github.Repository).GetStargazersCount)
+	case "size":
+		accept, err = intFilter(op, value, (*github.Repository).GetSize)
+	case "language":
+		accept, err = listFilter(op, value, repoLanguage)
+	case "license":
+		accept, err = listFilter(op, value, repoLicense)
+	case "topic":
+		accept, err = listFilter(op, value, repoTopics)
+	case "archived":
+		accept, err = boolFilter(op, value, (*github.Repository).GetArchived)
+	case "disabled":
+		accept, err = boolFilter(op, value, (*github.Repository).GetDisabled)
+	case "pushed":
+		accept, err = timeFilter(op, value, repoPushedAt)
+	case "name":
+		accept, err = nameFilter(op, value)
+	default:
+		return nil, ErrWrongFilter.New(expr, "unknown field "+field)
+	}
+
+	if err != nil {
+		return nil, ErrWrongFilter.New(expr, err.Error())
+	}
+
+	return &RepositoryFilter{Name: expr, Accept: accept}, nil
+}
+
+// ReadRepositoryFilters reads the filter expressions in the given file, one
+// per line. The empty lines and the ones starting with # are ignored.
+func ReadRepositoryFilters(path string) ([]*RepositoryFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var filters []*RepositoryFilter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		filter, err := ParseRepositoryFilter(line)
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, scanner.Err()
+}
+
+func repoLanguage(r *github.Repository) []string {
+	return []string{r.GetLanguage()}
+}
+
+func repoLicense(r *github.Repository) []string {
+	return []string{r.GetLicense().GetSPDXID()}
+}
+
+func repoTopics(r *github.Repository) []string {
+	return r.Topics
+}
+
+func repoPushedAt(r *github.Repository) time.Time {
+	return r.GetPushedAt().Time
+}
+
+type unsupportedOpError string
+
+func (e unsupportedOpError) Error() string {
+	return "operator " + string(e) + " not supported"
+}
+
+func intFilter(
+	op, value string,
+	get func(*github.Repository) int,
+) (func(*github.Repository) bool, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp, err := compareFn(op)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r *github.Repository) bool {
+		return cmp(int64(get(r)), int64(n))
+	}, nil
+}
+
+func timeFilter(
+	op, value string,
+	get func(*github.Repository) time.Time,
+) (func(*github.Repository) bool, error) {
+	if op == "=" || op == "!=" {
+		return nil, unsupportedOpError(op)
+	}
+
+	cmp, err := compareFn(op)
+	if err != nil {
+		return nil, err
+	}
+
+	bound, err := parseTimeBound(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r *github.Repository) bool {
+		return cmp(get(r).Unix(), bound().Unix())
+	}, nil
+}
+
+// parseTimeBound parses a date or a duration ago, which is computed every
+// time because the discovery can run for long.
+func parseTimeBound(value string) (func() time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return func() time.Time { return t }, nil
+		}
+	}
+
+	var (
+		d   time.Duration
+		err error
+	)
+
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func() time.Time { return time.Now().Add(-d) }, nil
+}
+
+func compareFn(op string) (func(a, b int64) bool, error) {
+	switch op {
+	case "=":
+		return func(a, b int64) bool { return a == b }, nil
+	case "!=":
+		return func(a, b int64) bool { return a != b }, nil
+	case "<":
+		return func(a, b int64) bool { return a < b }, nil
+	case "<=":
+		return func(a, b int64) bool { return a <= b }, nil
+	case ">":
+		return func(a, b int64) bool { return a > b }, nil
+	case ">=":
+		return func(a, b int64) bool { return a >= b }, nil
+	default:
+		return nil, unsupportedOpError(op)
+	}
+}
+
+func boolFilter(
+	op, value string,
+	get func(*github.Repository) bool,
+) (func(*github.Repository) bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "=":
+		return func(r *github.Repository) bool { return get(r) == b }, nil
+	case "!=":
+		return func(r *github.Repository) bool { return get(r) != b }, nil
+	default:
+		return nil, unsupportedOpError(op)
+	}
+}
+
+func listFilter(
+	op, value string,
+	get func(*github.Repository) []string,
+) (func(*github.Repository) bool, error) {
+	if op != "=" && op != "!=" {
+		return nil, unsupportedOpError(op)
+	}
+
+	set := make(map[string]struct{})
+	for _, v := range strings.Split(value, ",") {
+		set[strings.ToLower(strings.TrimSpace(v))] = struct{}{}
+	}
+
+	return func(r *github.Repository) bool {
+		var found bool
+		for _, v := range get(r) {
+			if _, ok := set[strings.ToLower(v)]; ok {
+				found = true
+				break
+			}
+		}
+
+		return found == (op == "=")
+	}, nil
+}
+
+func nameFilter(op, value string) (func(*github.Repository) bool, error) {
+	var match func(string) bool
+	switch op {
+	case "=", "!=":
+		patterns := strings.Split(value, ",")
+		for i, p := range patterns {
+			patterns[i] = strings.TrimSpace(p)
+			if _, err := path.Match(patterns[i], ""); err != nil {
+				return nil, err
+			}
+		}
+
+		match = func(name string) bool {
+			for _, p := range patterns {
+				if ok, _ := path.Match(p, name); ok {
+					return true
+				}
+			}
+
+			return false
+		}
+	case "~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+
+		match = re.MatchString
+	default:
+		return nil, unsupportedOpError(op)
+	}
+
+	positive := op == "=" || op == "~"
+	return func(r *github.Repository) bool {
+		found := match(r.GetName()) || match(r.GetFullName())
+		return found == positive
+	}, nil
+}
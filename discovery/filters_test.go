@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepositoryFilter(t *testing.T) {
+	var require = require.New(t)
+
+	repo := &github.Repository{
+		Name:            github.String("go-borges"),
+		FullName:        github.String("src-d/go-borges"),
+		StargazersCount: github.Int(150),
+		Size:            github.Int(2048),
+		Language:        github.String("Go"),
+		Archived:        github.Bool(false),
+		License:         &github.License{SPDXID: github.String("Apache-2.0")},
+		Topics:          []string{"git", "siva"},
+		PushedAt: &github.Timestamp{
+			Time: time.Now().Add(-48 * time.Hour),
+		},
+	}
+
+	cases := map[string]bool{
+		"stars>=100":              true,
+		"stars < 100":             false,
+		"size<=1024":              false,
+		"language=go,rust":        true,
+		"language!=go":            false,
+		"license=mit,apache-2.0":  true,
+		"topic=siva":              true,
+		"topic!=git":              false,
+		"archived=false":          true,
+		"disabled=true":           false,
+		"pushed>=7d":              true,
+		"pushed>=24h":             false,
+		"pushed>2019-01-01":       true,
+		"name=go-*":               true,
+		"name=src-d/*":            true,
+		"name!=*-deprecated,foo*": true,
+		"name~^go-":               true,
+		"name!~borges$":           false,
+	}
+
+	for expr, expected := range cases {
+		f, err := ParseRepositoryFilter(expr)
+		require.NoError(err, expr)
+		require.Equal(expected, f.Accept(repo), expr)
+	}
+
+	for _, expr := range []string{
+		"stars",
+		"stars>=many",
+		"language>go",
+		"pushed=2019-01-01",
+		"name~(",
+		"forks>1",
+		"archived=",
+	} {
+		_, err := ParseRepositoryFilter(expr)
+		require.True(ErrWrongFilter.Is(err), expr)
+	}
+}
+
+func TestReadRepositoryFilters(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-filters")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "filters")
+	require.NoError(ioutil.WriteFile(path, []byte(
+		"# popular go repositories\nstars>=100\n\nlanguage=go\n",
+	), 0644))
+
+	filters, err := ReadRepositoryFilters(path)
+	require.NoError(err)
+	require.Len(filters, 2)
+	require.Equal("stars>=100", filters[0].Name)
+	require.Equal("language=go", filters[1].Name)
+}
+
+type sliceReposIter []*github.Repository
+
+func (it *sliceReposIter) Next(
+	context.Context,
+) (*github.Repository, time.Duration, error) {
+	if len(*it) == 0 {
+		return nil, 0, ErrNewRepositoriesNotFound.New()
+	}
+
+	var next *github.Repository
+	next, *it = (*it)[0], (*it)[1:]
+	return next, 0, nil
+}
+
+func TestGitHubFilters(t *testing.T) {
+	var require = require.New(t)
+
+	iter := &sliceReposIter{
+		{Name: github.String("foo"), StargazersCount: github.Int(10)},
+		{Name: github.String("bar"), StargazersCount: github.Int(1)},
+		{Name: github.String("baz"), Fork: github.Bool(true)},
+		{Name: github.String("qux"), StargazersCount: github.Int(20)},
+	}
+
+	stars, err := ParseRepositoryFilter("stars>=10")
+	require.NoError(err)
+
+	var advertised []string
+	filtered := map[string]string{}
+	gh := NewGitHub(
+		func(_ context.Context, repos []*github.Repository) error {
+			for _, r := range repos {
+				advertised = append(advertised, r.GetName())
+			}
+
+			return nil
+		},
+		iter,
+		&GitHubOpts{
+			SkipForks: true,
+			Filters:   []*RepositoryFilter{stars},
+			Filtered: func(r *github.Repository, reason string) {
+				filtered[r.GetName()] = reason
+			},
+		},
+	)
+
+	require.True(ErrDiscoveryStopped.Is(gh.Start()))
+	require.Equal([]string{"foo", "qux"}, advertised)
+	require.Equal(map[string]string{
+		"bar": "stars>=10",
+		"baz": "fork",
+	}, filtered)
+}
@@ -0,0 +1,471 @@
+package discovery
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+var (
+	// ErrWrongAppKey is returned when the private key of a github app
+	// can't be parsed.
+	ErrWrongAppKey = errors.NewKind("wrong github app private key: %s")
+
+	// ErrInstallationNotFound is returned when a github app isn't
+	// installed in an account.
+	ErrInstallationNotFound = errors.NewKind(
+		"github app not installed in %q")
+)
+
+const (
+	// AppTokenUsername is the username to authenticate the git endpoints
+	// with an installation token.
+	AppTokenUsername = "x-access-token"
+
+	// appJWTExpiration is below the 10 minutes allowed by github, the JWT
+	// is issued a minute in the past to allow some clock drift.
+	appJWTExpiration = 9 * time.Minute
+	appTokenRefresh  = 5 * time.Minute
+	// appListInterval is the minimum time between the requests listing
+	// the installations to find an unknown account.
+	appListInterval = time.Minute
+)
+
+// GHAppOpts represents configuration options for a GHApp.
+type GHAppOpts struct {
+	HTTPTimeout time.Duration
+	// Refresh is the time before its expiration an installation token is
+	// renewed, 5 minutes by default.
+	Refresh time.Duration
+	// BaseURL is the API url of a github enterprise server,
+	// api.github.com by default.
+	BaseURL *url.URL
+	// Transport is used to send the requests, http.DefaultTransport by
+	// default.
+	Transport http.RoundTripper
+}
+
+// GHApp authenticates as a github app. The app itself is authenticated with
+// a JWT signed with its private key, and the requests on behalf of the
+// accounts where it's installed with the access tokens of the installations,
+// which are renewed before they expire.
+type GHApp struct {
+	id      int64
+	key     *rsa.PrivateKey
+	client  *github.Client
+	opts    *GHAppOpts
+	refresh time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	owners   map[string]int64
+	listedAt time.Time
+	tokens   map[int64]*appToken
+}
+
+type appToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewGHApp builds a new GHApp with the given ID and PEM private key.
+func NewGHApp(id int64, key []byte, opts *GHAppOpts) (*GHApp, error) {
+	if opts == nil {
+		opts = &GHAppOpts{}
+	}
+
+	pk, err := parseAppKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	to := opts.HTTPTimeout
+	if to <= 0 {
+		to = httpTimeout
+	}
+
+	refresh := opts.Refresh
+	if refresh <= 0 {
+		refresh = appTokenRefresh
+	}
+
+	a := &GHApp{
+		id:      id,
+		key:     pk,
+		opts:    opts,
+		refresh: refresh,
+		now:     time.Now,
+		owners:  make(map[string]int64),
+		tokens:  make(map[int64]*appToken),
+	}
+
+	base := opts.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	a.client = github.NewClient(&http.Client{
+		Transport: &appTransport{base: base, auth: a.jwtAuth},
+		Timeout:   to,
+	})
+
+	if opts.BaseURL != nil {
+		a.client.BaseURL = opts.BaseURL
+	}
+
+	return a, nil
+}
+
+func parseAppKey(key []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, ErrWrongAppKey.New("no PEM data found")
+	}
+
+	if pk, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return pk, nil
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrWrongAppKey.New(err.Error())
+	}
+
+	pk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrWrongAppKey.New("not an RSA key")
+	}
+
+	return pk, nil
+}
+
+// JWT returns a new JSON Web Token authenticating the app.
+func (a *GHApp) JWT() (string, error) {
+	now := a.now()
+	header := `{"alg":"RS256","typ":"JWT"}`
+	claims, err := json.Marshal(struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+		Issuer    int64 `json:"iss"`
+	}{
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(appJWTExpiration).Unix(),
+		Issuer:    a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(header)) + "." +
+		enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+func (a *GHApp) jwtAuth(context.Context) (string, error) {
+	jwt, err := a.JWT()
+	if err != nil {
+		return "", err
+	}
+
+	return "Bearer " + jwt, nil
+}
+
+// Installations lists the installations of the app.
+func (a *GHApp) Installations(
+	ctx context.Context,
+) ([]*github.Installation, error) {
+	var (
+		result []*github.Installation
+		opts   = &github.ListOptions{PerPage: resultsPerPage}
+	)
+
+	for {
+		insts, res, err := a.client.Apps.ListInstallations(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, insts...)
+		if res.NextPage == 0 {
+			break
+		}
+
+		opts.Page = res.NextPage
+	}
+
+	owners := make(map[string]int64, len(result))
+	for _, inst := range result {
+		login := strings.ToLower(inst.GetAccount().GetLogin())
+		owners[login] = inst.GetID()
+	}
+
+	a.mu.Lock()
+	a.owners = owners
+	a.listedAt = a.now()
+	a.mu.Unlock()
+
+	return result, nil
+}
+
+// Token returns the access token of the given installation, a new one is
+// requested when the last one is about to expire.
+func (a *GHApp) Token(ctx context.Context, installation int64) (string, error) {
+	a.mu.Lock()
+	t, ok := a.tokens[installation]
+	a.mu.Unlock()
+
+	if ok && a.now().Add(a.refresh).Before(t.expires) {
+		return t.token, nil
+	}
+
+	it, _, err := a.client.Apps.CreateInstallationToken(
+		ctx, installation, nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	t = &appToken{token: it.GetToken(), expires: it.GetExpiresAt()}
+	a.mu.Lock()
+	a.tokens[installation] = t
+	a.mu.Unlock()
+
+	return t.token, nil
+}
+
+// OwnerToken returns the access token of the installation in the given
+// account, or of any installation if it's empty. If the app isn't installed
+// in the account the returned error is an ErrInstallationNotFound.
+func (a *GHApp) OwnerToken(ctx context.Context, owner string) (string, error) {
+	id, err := a.installation(ctx, strings.ToLower(owner))
+	if err != nil {
+		return "", err
+	}
+
+	return a.Token(ctx, id)
+}
+
+func (a *GHApp) installation(ctx context.Context, owner string) (int64, error) {
+	a.mu.Lock()
+	id, ok := a.lookup(owner)
+	listed := !a.listedAt.IsZero() &&
+		a.now().Sub(a.listedAt) < appListInterval
+	a.mu.Unlock()
+
+	if ok {
+		return id, nil
+	}
+
+	if !listed {
+		// the app could have been installed since the last time.
+		if _, err := a.Installations(ctx); err != nil {
+			return 0, err
+		}
+
+		a.mu.Lock()
+		id, ok = a.lookup(owner)
+		a.mu.Unlock()
+		if ok {
+			return id, nil
+		}
+	}
+
+	return 0, ErrInstallationNotFound.New(owner)
+}
+
+// lookup finds the installation in the given account, or the first one if
+// it's empty.
+func (a *GHApp) lookup(owner string) (int64, bool) {
+	if owner != "" {
+		id, ok := a.owners[owner]
+		return id, ok
+	}
+
+	ids := make([]int64, 0, len(a.owners))
+	for _, id := range a.owners {
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return 0, false
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids[0], true
+}
+
+// Transport returns an http.RoundTripper authenticating the requests with
+// the access token of the installation in the given account, or of any
+// installation if it's empty.
+func (a *GHApp) Transport(owner string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &appTransport{
+		base: base,
+		auth: func(ctx context.Context) (string, error) {
+			token, err := a.OwnerToken(ctx, owner)
+			if err != nil {
+				return "", err
+			}
+
+			return "token " + token, nil
+		},
+	}
+}
+
+type appTransport struct {
+	base http.RoundTripper
+	auth func(context.Context) (string, error)
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auth, err := t.auth(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	r := cloneRequest(req)
+	r.Header.Set("Authorization", auth)
+	return t.base.RoundTrip(r)
+}
+
+// GHAppReposIter is a GHRepositoriesIter of the repositories of all the
+// installations of a github app. Once all of them are found, the
+// installations are listed again to find the new ones.
+type GHAppReposIter struct {
+	app           *GHApp
+	excludedRepos []string
+	opts          *GHReposIterOpts
+	iters         map[int64]*ghListReposIter
+	pending       []int64
+	listed        bool
+	waitNewRepos  time.Duration
+}
+
+var _ GHRepositoriesIter = (*GHAppReposIter)(nil)
+
+// NewGHAppReposIter builds a new GHAppReposIter. The requests for every
+// installation are authenticated with its access token.
+func NewGHAppReposIter(
+	app *GHApp,
+	excludedRepos []string,
+	opts *GHReposIterOpts,
+) *GHAppReposIter {
+	if opts == nil {
+		opts = &GHReposIterOpts{}
+	}
+
+	iterOpts := *opts
+	iterOpts.App = app
+
+	wnr := opts.TimeNewRepos
+	if wnr <= 0 {
+		wnr = waitNewRepos
+	}
+
+	return &GHAppReposIter{
+		app:           app,
+		excludedRepos: excludedRepos,
+		opts:          &iterOpts,
+		iters:         make(map[int64]*ghListReposIter),
+		waitNewRepos:  wnr,
+	}
+}
+
+// Next implements the GHRepositoriesIter interface.
+func (p *GHAppReposIter) Next(
+	ctx context.Context,
+) (*github.Repository, time.Duration, error) {
+	if !p.listed {
+		if err := p.listInstallations(ctx); err != nil {
+			return nil, -1, err
+		}
+	}
+
+	for len(p.pending) > 0 {
+		repo, retry, err := p.iters[p.pending[0]].Next(ctx)
+		if err == nil {
+			return repo, 0, nil
+		}
+
+		if !ErrNewRepositoriesNotFound.Is(err) {
+			return nil, retry, err
+		}
+
+		p.pending = p.pending[1:]
+	}
+
+	p.listed = false
+	return nil, p.waitNewRepos, ErrNewRepositoriesNotFound.New()
+}
+
+func (p *GHAppReposIter) listInstallations(ctx context.Context) error {
+	insts, err := p.app.Installations(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the iterators of the known installations go on from their last
+	// page, so only their new repositories are found.
+	iters := make(map[int64]*ghListReposIter, len(insts))
+	p.pending = make([]int64, 0, len(insts))
+	for _, inst := range insts {
+		id := inst.GetID()
+		iter, ok := p.iters[id]
+		if !ok {
+			it := newGHListReposIter(
+				listInstallationRepos,
+				inst.GetAccount().GetLogin(),
+				checkpointKey(
+					p.opts, "installations",
+					strconv.FormatInt(id, 10),
+				),
+				p.excludedRepos,
+				p.opts,
+			)
+			iter = &it
+		}
+
+		iters[id] = iter
+		p.pending = append(p.pending, id)
+	}
+
+	p.iters = iters
+	p.listed = true
+	return nil
+}
+
+func listInstallationRepos(
+	ctx context.Context,
+	client *github.Client,
+	opts github.ListOptions,
+) ([]*github.Repository, *github.Response, error) {
+	return client.Apps.ListRepos(ctx, &opts)
+}
@@ -0,0 +1,240 @@
+package discovery
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGHApp serves the github api endpoints used by a GHApp, it's installed
+// in the accounts foo and bar, with the ids 1 and 2.
+type fakeGHApp struct {
+	t      *testing.T
+	key    *rsa.PublicKey
+	mu     sync.Mutex
+	issued map[int64]int
+	repos  map[string][]*github.Repository
+}
+
+func (f *fakeGHApp) verifyJWT(auth string) {
+	require := require.New(f.t)
+
+	require.True(strings.HasPrefix(auth, "Bearer "), auth)
+	parts := strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")
+	require.Len(parts, 3)
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(err)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(rsa.VerifyPKCS1v15(f.key, crypto.SHA256, hash[:], sig))
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(err)
+	var claims struct {
+		Iss int64 `json:"iss"`
+		Exp int64 `json:"exp"`
+	}
+	require.NoError(json.Unmarshal(data, &claims))
+	require.Equal(int64(42), claims.Iss)
+	require.True(claims.Exp > time.Now().Unix())
+}
+
+func (f *fakeGHApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	switch {
+	case r.URL.Path == "/app/installations":
+		f.verifyJWT(auth)
+		require.NoError(f.t, json.NewEncoder(w).Encode([]*github.Installation{
+			{ID: github.Int64(1), Account: &github.User{
+				Login: github.String("foo"),
+			}},
+			{ID: github.Int64(2), Account: &github.User{
+				Login: github.String("Bar"),
+			}},
+		}))
+	case strings.HasPrefix(r.URL.Path, "/app/installations/"):
+		f.verifyJWT(auth)
+		require.Equal(f.t, http.MethodPost, r.Method)
+
+		var id int64
+		_, err := fmt.Sscanf(r.URL.Path,
+			"/app/installations/%d/access_tokens", &id)
+		require.NoError(f.t, err)
+
+		f.mu.Lock()
+		f.issued[id]++
+		n := f.issued[id]
+		f.mu.Unlock()
+
+		expires := time.Now().Add(time.Hour).Truncate(time.Second)
+		w.WriteHeader(http.StatusCreated)
+		require.NoError(f.t, json.NewEncoder(w).Encode(
+			&github.InstallationToken{
+				Token:     github.String(fmt.Sprintf("tok-%d-%d", id, n)),
+				ExpiresAt: &expires,
+			},
+		))
+	case r.URL.Path == "/installation/repositories":
+		owner := f.owner(auth)
+		listHandler(f.t, w, r, f.repos[owner],
+			func(page []*github.Repository) interface{} {
+				return map[string]interface{}{
+					"total_count":  len(f.repos[owner]),
+					"repositories": page,
+				}
+			},
+		)
+	case r.URL.Path == "/orgs/foo/repos":
+		require.Equal(f.t, "foo", f.owner(auth))
+		listHandler(f.t, w, r, f.repos["foo"],
+			func(page []*github.Repository) interface{} {
+				return page
+			},
+		)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// owner returns the account of the installation token in the given header.
+func (f *fakeGHApp) owner(auth string) string {
+	switch {
+	case strings.HasPrefix(auth, "token tok-1-"):
+		return "foo"
+	case strings.HasPrefix(auth, "token tok-2-"):
+		return "bar"
+	default:
+		require.Fail(f.t, "wrong installation token", auth)
+		return ""
+	}
+}
+
+func newTestGHApp(t *testing.T) (*GHApp, *fakeGHApp, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	fake := &fakeGHApp{
+		t:      t,
+		key:    &key.PublicKey,
+		issued: make(map[int64]int),
+		repos:  make(map[string][]*github.Repository),
+	}
+
+	for _, owner := range []string{"foo", "bar"} {
+		for i := 0; i < 3; i++ {
+			fake.repos[owner] = append(fake.repos[owner],
+				&github.Repository{
+					Name: github.String(
+						fmt.Sprintf("%s-repo%d", owner, i),
+					),
+				},
+			)
+		}
+	}
+
+	server := httptest.NewServer(fake)
+	base, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	pemKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	app, err := NewGHApp(42, pemKey, &GHAppOpts{BaseURL: base})
+	require.NoError(t, err)
+
+	return app, fake, server.Close
+}
+
+func TestGHApp(t *testing.T) {
+	var require = require.New(t)
+
+	app, fake, close := newTestGHApp(t)
+	defer close()
+
+	ctx := context.Background()
+	token, err := app.OwnerToken(ctx, "FOO")
+	require.NoError(err)
+	require.Equal("tok-1-1", token)
+
+	token, err = app.OwnerToken(ctx, "bar")
+	require.NoError(err)
+	require.Equal("tok-2-1", token)
+
+	token, err = app.OwnerToken(ctx, "")
+	require.NoError(err)
+	require.Equal("tok-1-1", token)
+
+	_, err = app.OwnerToken(ctx, "baz")
+	require.True(ErrInstallationNotFound.Is(err))
+
+	// the tokens are renewed before they expire.
+	app.now = func() time.Time { return time.Now().Add(56 * time.Minute) }
+	token, err = app.Token(ctx, 1)
+	require.NoError(err)
+	require.Equal("tok-1-2", token)
+	app.now = time.Now
+	token, err = app.Token(ctx, 1)
+	require.NoError(err)
+	require.Equal("tok-1-2", token)
+	require.Equal(map[int64]int{1: 2, 2: 1}, fake.issued)
+
+	_, err = NewGHApp(42, []byte("foo"), nil)
+	require.True(ErrWrongAppKey.Is(err))
+}
+
+func TestGHAppReposIter(t *testing.T) {
+	var require = require.New(t)
+
+	app, _, close := newTestGHApp(t)
+	defer close()
+
+	iter := NewGHAppReposIter(app, []string{"bar-repo1"}, &GHReposIterOpts{
+		ResultsPerPage: 2,
+		BaseURL:        app.opts.BaseURL,
+	})
+
+	var names []string
+	for {
+		repo, _, err := iter.Next(context.Background())
+		if err != nil {
+			require.True(ErrNewRepositoriesNotFound.Is(err))
+			break
+		}
+
+		names = append(names, repo.GetName())
+	}
+
+	require.Equal([]string{
+		"foo-repo0", "foo-repo1", "foo-repo2",
+		"bar-repo0", "bar-repo2",
+	}, names)
+
+	// the requests of the other iterators are authenticated as the
+	// installation in the listed account.
+	org := NewGHOrgReposIter("foo", nil, &GHReposIterOpts{
+		BaseURL: app.opts.BaseURL,
+		App:     app,
+	})
+	repo, _, err := org.Next(context.Background())
+	require.NoError(err)
+	require.Equal("foo-repo0", repo.GetName())
+}
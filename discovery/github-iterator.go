@@ -3,10 +3,11 @@ package discovery
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v28/github"
-	"golang.org/x/oauth2"
 )
 
 // GHRepositoriesIter represents an iterator of *github.Repositories
@@ -20,6 +21,25 @@ type GHReposIterOpts struct {
 	ResultsPerPage int
 	TimeNewRepos   time.Duration
 	AuthToken      string
+	// Tokens authenticates the requests with the token with most
+	// remaining quota, it takes precedence over AuthToken.
+	Tokens *TokenPool
+	// BaseURL is the API url of a github enterprise server, e.g.
+	// https://github.example.com/api/v3/, api.github.com by default.
+	BaseURL *url.URL
+	// UploadURL is the upload url of a github enterprise server, the
+	// github.com one by default.
+	UploadURL *url.URL
+	// Transport is used to send the requests, http.DefaultTransport by
+	// default, e.g. one trusting the CA of a github enterprise server.
+	Transport http.RoundTripper
+	// App authenticates the requests as the installation of a github app
+	// in the listed account, it takes precedence over Tokens.
+	App *GHApp
+	// Checkpoints persists the point where the iterators listing the
+	// repositories of an account resume the discovery. It only advances
+	// past the repositories acknowledged on it.
+	Checkpoints *CheckpointStore
 }
 
 const (
@@ -30,19 +50,100 @@ const (
 
 // GHOrgReposIter is a GHRepositoriesIter by organization name.
 type GHOrgReposIter struct {
-	org           string
+	ghListReposIter
+}
+
+var _ GHRepositoriesIter = (*GHOrgReposIter)(nil)
+
+// NewGHOrgReposIter builds a new GHOrgReposIter.
+func NewGHOrgReposIter(org string, excludedRepos []string, opts *GHReposIterOpts) *GHOrgReposIter {
+	return &GHOrgReposIter{newGHListReposIter(
+		func(
+			ctx context.Context,
+			client *github.Client,
+			lopts github.ListOptions,
+		) ([]*github.Repository, *github.Response, error) {
+			return client.Repositories.ListByOrg(ctx, org,
+				&github.RepositoryListByOrgOptions{
+					ListOptions: lopts,
+				},
+			)
+		},
+		org,
+		checkpointKey(opts, "orgs", org),
+		excludedRepos,
+		opts,
+	)}
+}
+
+// GHUserReposIter is a GHRepositoriesIter by user name. It only iterates the
+// repositories owned by the user.
+type GHUserReposIter struct {
+	ghListReposIter
+}
+
+var _ GHRepositoriesIter = (*GHUserReposIter)(nil)
+
+// NewGHUserReposIter builds a new GHUserReposIter.
+func NewGHUserReposIter(
+	user string,
+	excludedRepos []string,
+	opts *GHReposIterOpts,
+) *GHUserReposIter {
+	return &GHUserReposIter{newGHListReposIter(
+		func(
+			ctx context.Context,
+			client *github.Client,
+			lopts github.ListOptions,
+		) ([]*github.Repository, *github.Response, error) {
+			return client.Repositories.List(ctx, user,
+				&github.RepositoryListOptions{
+					Type:        "owner",
+					ListOptions: lopts,
+				},
+			)
+		},
+		user,
+		checkpointKey(opts, "users", user),
+		excludedRepos,
+		opts,
+	)}
+}
+
+// listReposFn requests a page of a list of repositories.
+type listReposFn func(
+	context.Context,
+	*github.Client,
+	github.ListOptions,
+) ([]*github.Repository, *github.Response, error)
+
+// ghListReposIter iterates a paginated list of repositories. Once the last
+// page is reached, it's requested again to find the new repositories. Its
+// checkpoint is persisted with the given key, if any.
+type ghListReposIter struct {
+	list          listReposFn
 	excludedRepos map[string]struct{}
 	client        *github.Client
 	repos         []*github.Repository
 	checkpoint    int
-	opts          *github.RepositoryListByOrgOptions
+	lastID        int64
+	etag          string
+	opts          github.ListOptions
 	waitNewRepos  time.Duration
+	key           string
+	store         *CheckpointStore
+	saved         GHCheckpoint
 }
 
-var _ GHRepositoriesIter = (*GHOrgReposIter)(nil)
-
-// NewGHOrgReposIter builds a new GHOrgReposIter.
-func NewGHOrgReposIter(org string, excludedRepos []string, opts *GHReposIterOpts) *GHOrgReposIter {
+// newGHListReposIter builds a new ghListReposIter of the repositories owned
+// by the given account, if any. It resumes from the checkpoint stored with
+// the given key.
+func newGHListReposIter(
+	list listReposFn,
+	owner, key string,
+	excludedRepos []string,
+	opts *GHReposIterOpts,
+) ghListReposIter {
 	if opts == nil {
 		opts = &GHReposIterOpts{}
 	}
@@ -62,69 +163,135 @@ func NewGHOrgReposIter(org string, excludedRepos []string, opts *GHReposIterOpts
 		wnr = waitNewRepos
 	}
 
-	excludedReposSet := make(map[string]struct{})
-	for _, excludedRepo := range excludedRepos {
-		excludedReposSet[excludedRepo] = struct{}{}
+	tokens := opts.Tokens
+	if tokens == nil {
+		tokens = NewTokenPool(opts.AuthToken)
 	}
 
-	return &GHOrgReposIter{
-		org:           org,
-		excludedRepos: excludedReposSet,
-		client:        newGithubClient(opts.AuthToken, to),
-		opts: &github.RepositoryListByOrgOptions{
-			ListOptions: github.ListOptions{PerPage: rpp},
-		},
-		waitNewRepos: wnr,
+	iter := ghListReposIter{
+		list:          list,
+		excludedRepos: excludedReposSet(excludedRepos),
+		client:        newGithubClient(owner, tokens, to, opts),
+		opts:          github.ListOptions{PerPage: rpp},
+		waitNewRepos:  wnr,
+		key:           key,
+		store:         opts.Checkpoints,
+	}
+
+	if iter.store != nil && key != "" {
+		if cp := iter.store.Get(key); cp != nil {
+			iter.opts.Page = cp.Page
+			iter.checkpoint = cp.Offset
+			iter.lastID = cp.LastRepoID
+			iter.etag = cp.ETag
+			iter.saved = *cp
+		}
 	}
+
+	return iter
 }
 
-func newGithubClient(token string, timeout time.Duration) *github.Client {
-	var client *http.Client
-	if token == "" {
-		client = &http.Client{}
-	} else {
-		client = oauth2.NewClient(
-			context.Background(),
-			oauth2.StaticTokenSource(
-				&oauth2.Token{AccessToken: token},
-			),
-		)
+// checkpointKey returns the key of the checkpoint of the iterator listing
+// the repositories of the given kind of account, e.g. orgs, of the github
+// server given in the options.
+func checkpointKey(opts *GHReposIterOpts, kind, name string) string {
+	host := DefaultGHHost
+	if opts != nil && opts.BaseURL != nil {
+		host = strings.ToLower(opts.BaseURL.Host)
+	}
+
+	return host + "/" + kind + "/" + strings.ToLower(name)
+}
+
+func excludedReposSet(excludedRepos []string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, excludedRepo := range excludedRepos {
+		set[excludedRepo] = struct{}{}
 	}
 
-	client.Timeout = timeout
-	return github.NewClient(client)
+	return set
+}
+
+// newGithubClient builds a github.Client for the requests on the
+// repositories of the given account, if any.
+func newGithubClient(
+	owner string,
+	tokens *TokenPool,
+	timeout time.Duration,
+	opts *GHReposIterOpts,
+) *github.Client {
+	transport := tokens.Transport(opts.Transport)
+	if opts.App != nil {
+		transport = opts.App.Transport(owner, opts.Transport)
+	}
+
+	client := github.NewClient(&http.Client{
+		Transport: &etagTransport{base: transport},
+		Timeout:   timeout,
+	})
+
+	if opts.BaseURL != nil {
+		client.BaseURL = opts.BaseURL
+	}
+
+	if opts.UploadURL != nil {
+		client.UploadURL = opts.UploadURL
+	}
+
+	return client
 }
 
 // Next implements the GHRepositoriesIter interface.
-func (p *GHOrgReposIter) Next(
+func (p *ghListReposIter) Next(
 	ctx context.Context,
 ) (*github.Repository, time.Duration, error) {
-	for {
-		if len(p.repos) == 0 {
-			retry, err := p.requestRepos(ctx)
-			if err != nil && len(p.repos) == 0 {
-				return nil, retry, err
-			}
+	for len(p.repos) == 0 {
+		retry, err := p.requestRepos(ctx)
+		if len(p.repos) > 0 {
+			break
+		}
+
+		// no new repositories were found, but the checkpoint moves
+		// on to the last page requested.
+		if err := p.saveCheckpoint(); err != nil {
+			return nil, -1, err
+		}
+
+		if err != nil {
+			return nil, retry, err
 		}
+	}
+
+	var next *github.Repository
+	next, p.repos = p.repos[0], p.repos[1:]
+	if p.store != nil && p.key != "" {
+		p.store.found(p.key, next)
+	}
 
-		var next *github.Repository
-		next, p.repos = p.repos[0], p.repos[1:]
-		if _, ok := p.excludedRepos[next.GetName()]; !ok {
-			return next, 0, nil
+	if len(p.repos) == 0 {
+		// all the repositories found so far were handed out.
+		if err := p.saveCheckpoint(); err != nil {
+			return nil, -1, err
 		}
 	}
+
+	return next, 0, nil
 }
 
-func (p *GHOrgReposIter) requestRepos(
+func (p *ghListReposIter) requestRepos(
 	ctx context.Context,
 ) (time.Duration, error) {
-	repos, res, err := p.client.Repositories.ListByOrg(
-		ctx,
-		p.org,
-		p.opts,
-	)
+	if p.etag != "" {
+		ctx = context.WithValue(ctx, etagKey{}, p.etag)
+	}
 
+	repos, res, err := p.list(ctx, p.client, p.opts)
 	if err != nil {
+		if isNotModified(err) {
+			// the last page didn't change since the last request.
+			return p.waitNewRepos, ErrNewRepositoriesNotFound.New()
+		}
+
 		if _, ok := err.(*github.RateLimitError); !ok {
 			return -1, err
 		}
@@ -132,36 +299,129 @@ func (p *GHOrgReposIter) requestRepos(
 		return timeToRetry(res), ErrRateLimitExceeded.Wrap(err)
 	}
 
-	bufRepos := repos
-	if p.checkpoint > 0 {
-		i := p.checkpoint
-		if len(repos) < p.checkpoint {
-			// return err?
-			i = 0
+	var bufRepos []*github.Repository
+	for _, r := range repos[p.offset(repos):] {
+		if _, ok := p.excludedRepos[r.GetName()]; !ok {
+			bufRepos = append(bufRepos, r)
 		}
-
-		bufRepos = repos[i:]
 	}
 
+	var etag string
 	if len(repos) < p.opts.PerPage {
 		p.checkpoint = len(repos)
+		if len(repos) > 0 {
+			p.lastID = repos[len(repos)-1].GetID()
+		}
+
+		etag = res.Header.Get("ETag")
 	}
 
 	err = nil
 	if res.NextPage == 0 {
 		if len(repos) == p.opts.PerPage {
+			// the page 0 is the first one.
+			if p.opts.Page == 0 {
+				p.opts.Page = 1
+			}
+
 			p.opts.Page++
+			p.checkpoint, p.lastID, etag = 0, 0, ""
 		}
 
 		err = ErrNewRepositoriesNotFound.New()
 	} else {
 		p.opts.Page = res.NextPage
+		p.checkpoint, p.lastID, etag = 0, 0, ""
 	}
 
+	p.etag = etag
 	p.repos = bufRepos
 	return p.waitNewRepos, err
 }
 
+// offset returns the number of repositories of the given page already found.
+// If the page changed since, e.g. because of a removed repository, the last
+// one found is looked up in the page.
+func (p *ghListReposIter) offset(repos []*github.Repository) int {
+	if p.checkpoint == 0 {
+		return 0
+	}
+
+	if p.lastID == 0 {
+		if len(repos) < p.checkpoint {
+			return 0
+		}
+
+		return p.checkpoint
+	}
+
+	if p.checkpoint <= len(repos) &&
+		repos[p.checkpoint-1].GetID() == p.lastID {
+		return p.checkpoint
+	}
+
+	for i, r := range repos {
+		if r.GetID() == p.lastID {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// saveCheckpoint persists the checkpoint of the iterator if it changed, once
+// the repositories handed out are acknowledged.
+func (p *ghListReposIter) saveCheckpoint() error {
+	if p.store == nil || p.key == "" {
+		return nil
+	}
+
+	cp := GHCheckpoint{
+		Page:       p.opts.Page,
+		Offset:     p.checkpoint,
+		LastRepoID: p.lastID,
+		ETag:       p.etag,
+	}
+
+	if cp == p.saved {
+		return nil
+	}
+
+	if err := p.store.checkpoint(p.key, &cp); err != nil {
+		return err
+	}
+
+	p.saved = cp
+	return nil
+}
+
+type etagKey struct{}
+
+// etagTransport sends the requests with the ETag in their context, if any,
+// in the If-None-Match header, so github answers 304 Not Modified if the
+// requested page didn't change.
+type etagTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	etag, _ := req.Context().Value(etagKey{}).(string)
+	if etag == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	r := cloneRequest(req)
+	r.Header.Set("If-None-Match", etag)
+	return t.base.RoundTrip(r)
+}
+
+func isNotModified(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	return ok && e.Response != nil &&
+		e.Response.StatusCode == http.StatusNotModified
+}
+
 func timeToRetry(res *github.Response) time.Duration {
 	now := time.Now().UTC().Unix()
 	resetTime := res.Rate.Reset.UTC().Unix()
@@ -0,0 +1,159 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+)
+
+const (
+	// searchLimit is the maximum number of results github returns for a
+	// search query.
+	searchLimit = 1000
+	timeFormat  = "2006-01-02T15:04:05Z"
+)
+
+// searchSince is the creation date of the first github repositories.
+var searchSince = time.Date(2007, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+// GHSearchReposIter is a GHRepositoriesIter by a github search query, e.g.
+// "language:go stars:>500". As github doesn't return more than 1000 results
+// for a query, it's sliced by creation date until every slice has less
+// results than that, unless the query already filters by creation date. Once
+// all the results are found, the repositories created since are searched.
+type GHSearchReposIter struct {
+	query         string
+	slice         bool
+	excludedRepos map[string]struct{}
+	client        *github.Client
+	repos         []*github.Repository
+	windows       []*searchWindow
+	until         time.Time
+	perPage       int
+	waitNewRepos  time.Duration
+}
+
+// searchWindow is a range of creation dates of the searched repositories.
+type searchWindow struct {
+	from, to time.Time
+	page     int
+}
+
+var _ GHRepositoriesIter = (*GHSearchReposIter)(nil)
+
+// NewGHSearchReposIter builds a new GHSearchReposIter.
+func NewGHSearchReposIter(
+	query string,
+	excludedRepos []string,
+	opts *GHReposIterOpts,
+) *GHSearchReposIter {
+	// the search options are the same as the list ones.
+	lister := newGHListReposIter(nil, "", "", excludedRepos, opts)
+	until := time.Now().UTC().Truncate(time.Second)
+	return &GHSearchReposIter{
+		query:         query,
+		slice:         !strings.Contains(query, "created:"),
+		excludedRepos: lister.excludedRepos,
+		client:        lister.client,
+		windows:       []*searchWindow{{from: searchSince, to: until}},
+		until:         until,
+		perPage:       lister.opts.PerPage,
+		waitNewRepos:  lister.waitNewRepos,
+	}
+}
+
+// Next implements the GHRepositoriesIter interface.
+func (p *GHSearchReposIter) Next(
+	ctx context.Context,
+) (*github.Repository, time.Duration, error) {
+	for {
+		if len(p.repos) == 0 {
+			retry, err := p.requestRepos(ctx)
+			if err != nil {
+				return nil, retry, err
+			}
+
+			continue
+		}
+
+		var next *github.Repository
+		next, p.repos = p.repos[0], p.repos[1:]
+		if _, ok := p.excludedRepos[next.GetName()]; !ok {
+			return next, 0, nil
+		}
+	}
+}
+
+func (p *GHSearchReposIter) requestRepos(
+	ctx context.Context,
+) (time.Duration, error) {
+	if len(p.windows) == 0 {
+		// the next searches look for the repositories created since.
+		now := time.Now().UTC().Truncate(time.Second)
+		p.windows = []*searchWindow{{
+			from: p.until.Add(time.Second),
+			to:   now,
+		}}
+		p.until = now
+
+		return p.waitNewRepos, ErrNewRepositoriesNotFound.New()
+	}
+
+	w := p.windows[len(p.windows)-1]
+	result, res, err := p.client.Search.Repositories(
+		ctx,
+		p.windowQuery(w),
+		&github.SearchOptions{
+			ListOptions: github.ListOptions{
+				PerPage: p.perPage,
+				Page:    w.page,
+			},
+		},
+	)
+	if err != nil {
+		if _, ok := err.(*github.RateLimitError); !ok {
+			return -1, err
+		}
+
+		return timeToRetry(res), ErrRateLimitExceeded.Wrap(err)
+	}
+
+	if p.slice && w.page == 0 && result.GetTotal() > searchLimit &&
+		w.to.Sub(w.from) > time.Second {
+		// the oldest half is searched first.
+		mid := w.from.Add(w.to.Sub(w.from) / 2).Truncate(time.Second)
+		p.windows[len(p.windows)-1] = &searchWindow{
+			from: mid.Add(time.Second),
+			to:   w.to,
+		}
+		p.windows = append(p.windows, &searchWindow{from: w.from, to: mid})
+		return 0, nil
+	}
+
+	// the results beyond the limit can't be requested.
+	lastPage := (searchLimit + p.perPage - 1) / p.perPage
+	if res.NextPage == 0 || res.NextPage > lastPage {
+		p.windows = p.windows[:len(p.windows)-1]
+	} else {
+		w.page = res.NextPage
+	}
+
+	p.repos = make([]*github.Repository, 0, len(result.Repositories))
+	for i := range result.Repositories {
+		p.repos = append(p.repos, &result.Repositories[i])
+	}
+
+	return 0, nil
+}
+
+func (p *GHSearchReposIter) windowQuery(w *searchWindow) string {
+	if !p.slice {
+		return p.query
+	}
+
+	return fmt.Sprintf("%s created:%s..%s", p.query,
+		w.from.Format(timeFormat), w.to.Format(timeFormat))
+}
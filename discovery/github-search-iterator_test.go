@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+// listHandler serves the given repositories paginated as the github api.
+func listHandler(
+	t *testing.T,
+	w http.ResponseWriter,
+	r *http.Request,
+	repos []*github.Repository,
+	wrap func([]*github.Repository) interface{},
+) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	start := (page - 1) * perPage
+	if start > len(repos) {
+		start = len(repos)
+	}
+
+	end := start + perPage
+	if end >= len(repos) {
+		end = len(repos)
+	} else {
+		next := *r.URL
+		q := next.Query()
+		q.Set("page", strconv.Itoa(page+1))
+		next.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	}
+
+	require.NoError(t, json.NewEncoder(w).Encode(wrap(repos[start:end])))
+}
+
+func newTestGHClient(t *testing.T, server *httptest.Server) *github.Client {
+	client := github.NewClient(nil)
+	u, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = u
+	return client
+}
+
+func TestGHSearchReposIter(t *testing.T) {
+	var require = require.New(t)
+
+	const total = 2500
+	start := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+	repos := make([]*github.Repository, 0, total)
+	for i := 0; i < total; i++ {
+		created := start.Add(time.Duration(i) * time.Hour)
+		repos = append(repos, &github.Repository{
+			Name:      github.String(fmt.Sprintf("repo%d", i)),
+			CreatedAt: &github.Timestamp{Time: created},
+		})
+	}
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			require.Equal("/search/repositories", r.URL.Path)
+
+			q := strings.Fields(r.URL.Query().Get("q"))
+			require.Equal("language:go", q[0])
+			require.Len(q, 2)
+
+			window := strings.Split(strings.TrimPrefix(q[1], "created:"), "..")
+			from, err := time.Parse(time.RFC3339, window[0])
+			require.NoError(err)
+			to, err := time.Parse(time.RFC3339, window[1])
+			require.NoError(err)
+
+			var found []*github.Repository
+			for _, r := range repos {
+				c := r.GetCreatedAt().Time
+				if !c.Before(from) && !c.After(to) {
+					found = append(found, r)
+				}
+			}
+
+			results := found
+			if len(results) > searchLimit {
+				results = results[:searchLimit]
+			}
+
+			listHandler(t, w, r, results,
+				func(page []*github.Repository) interface{} {
+					items := make([]github.Repository, 0, len(page))
+					for _, r := range page {
+						items = append(items, *r)
+					}
+
+					return &github.RepositoriesSearchResult{
+						Total:        github.Int(len(found)),
+						Repositories: items,
+					}
+				},
+			)
+		},
+	))
+	defer server.Close()
+
+	iter := NewGHSearchReposIter("language:go", []string{"repo7"}, nil)
+	iter.client = newTestGHClient(t, server)
+
+	seen := make(map[string]int)
+	for {
+		repo, _, err := iter.Next(context.Background())
+		if err != nil {
+			require.True(ErrNewRepositoriesNotFound.Is(err))
+			break
+		}
+
+		seen[repo.GetName()]++
+	}
+
+	require.Len(seen, total-1)
+	for name, n := range seen {
+		require.Equal(1, n, name)
+	}
+
+	_, ok := seen["repo7"]
+	require.False(ok)
+
+	// the next search looks for the new repositories.
+	requests = 0
+	_, _, err := iter.Next(context.Background())
+	require.True(ErrNewRepositoriesNotFound.Is(err))
+	require.Equal(1, requests)
+}
+
+func TestGHUserReposIter(t *testing.T) {
+	var require = require.New(t)
+
+	repos := make([]*github.Repository, 0, 5)
+	for i := 0; i < 5; i++ {
+		repos = append(repos, &github.Repository{
+			Name: github.String(fmt.Sprintf("repo%d", i)),
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			require.Equal("/users/foo/repos", r.URL.Path)
+			require.Equal("owner", r.URL.Query().Get("type"))
+			listHandler(t, w, r, repos,
+				func(page []*github.Repository) interface{} {
+					return page
+				},
+			)
+		},
+	))
+	defer server.Close()
+
+	iter := NewGHUserReposIter("foo", nil, &GHReposIterOpts{
+		ResultsPerPage: 2,
+	})
+	iter.client = newTestGHClient(t, server)
+
+	var names []string
+	for {
+		repo, _, err := iter.Next(context.Background())
+		if err != nil {
+			require.True(ErrNewRepositoriesNotFound.Is(err))
+			break
+		}
+
+		names = append(names, repo.GetName())
+	}
+
+	require.Equal([]string{
+		"repo0", "repo1", "repo2", "repo3", "repo4",
+	}, names)
+}
@@ -46,6 +46,14 @@ type GitHubOpts struct {
 	StopTimeout      time.Duration
 	MaxJobBuffer     int
 	BatchSize        int
+	// Protocol is the preferred protocol for the endpoints of the
+	// repositories, https by default.
+	Protocol Protocol
+	// Filters must accept a repository to be advertised.
+	Filters []*RepositoryFilter
+	// Filtered is notified of the repositories filtered out by SkipForks
+	// or by any of the Filters.
+	Filtered FilteredGHRepositoryFn
 }
 
 // GitHub will retrieve the information for all the repositories for the
@@ -92,6 +100,10 @@ func NewGitHub(
 		opts.AdvertiseTimeout = to
 	}
 
+	if opts.Filtered == nil {
+		opts.Filtered = func(*github.Repository, string) {}
+	}
+
 	if advertiseRepos == nil {
 		advertiseRepos = func(
 			context.Context,
@@ -185,7 +197,8 @@ func (p *GitHub) discoverRepositories(ctx context.Context) error {
 			return nil
 		}
 
-		if p.opts.SkipForks && repo.GetFork() {
+		if reason, ok := p.accept(repo); !ok {
+			p.opts.Filtered(repo, reason)
 			return nil
 		}
 
@@ -212,6 +225,23 @@ func (p *GitHub) discoverRepositories(ctx context.Context) error {
 	return nil
 }
 
+// accept reports whether the repository passes the filters, otherwise it
+// returns the name of the filter rejecting it.
+func (p *GitHub) accept(repo *github.Repository) (string, bool) {
+	filters := p.opts.Filters
+	if p.opts.SkipForks {
+		filters = append([]*RepositoryFilter{forksFilter}, filters...)
+	}
+
+	for _, f := range filters {
+		if !f.Accept(repo) {
+			return f.Name, false
+		}
+	}
+
+	return "", true
+}
+
 func (p *GitHub) sendBatch(ctx context.Context) error {
 	if err := p.advertiseRepos(ctx, p.batch); err != nil {
 		return err
@@ -223,26 +253,7 @@ func (p *GitHub) sendBatch(ctx context.Context) error {
 
 // GetGHEndpoint gets the enpoint for a github repository.
 func GetGHEndpoint(r *github.Repository) (string, error) {
-	var endpoint string
-	getURLs := []func() string{
-		r.GetHTMLURL,
-		r.GetGitURL,
-		r.GetSSHURL,
-	}
-
-	for _, getURL := range getURLs {
-		ep := getURL()
-		if ep != "" {
-			endpoint = ep
-			break
-		}
-	}
-
-	if endpoint == "" {
-		return "", ErrEndpointsNotFound.New(r.GetFullName())
-	}
-
-	return endpoint, nil
+	return GetGHEndpointByProtocol(r, ProtocolHTTPS)
 }
 
 // Stop stops the GitHub.
@@ -0,0 +1,332 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+var (
+	// ErrGitLabResponse is returned when the GitLab API answers with an
+	// unexpected status code.
+	ErrGitLabResponse = errors.NewKind("gitlab api responded %d: %s")
+
+	// ErrNamespaceNotFound is returned when a GitLab namespace is neither a
+	// group nor a user.
+	ErrNamespaceNotFound = errors.NewKind("gitlab namespace %s not found")
+)
+
+// GLProject represents a GitLab project as returned by the v4 REST API.
+type GLProject struct {
+	ID                int           `json:"id"`
+	Name              string        `json:"name"`
+	PathWithNamespace string        `json:"path_with_namespace"`
+	WebURL            string        `json:"web_url"`
+	HTTPURLToRepo     string        `json:"http_url_to_repo"`
+	SSHURLToRepo      string        `json:"ssh_url_to_repo"`
+	Archived          bool          `json:"archived"`
+	ForkedFromProject *GLForkParent `json:"forked_from_project,omitempty"`
+}
+
+// GLForkParent is the project a GLProject was forked from.
+type GLForkParent struct {
+	ID int `json:"id"`
+}
+
+// GetFork returns whether the project is a fork of another project.
+func (p *GLProject) GetFork() bool {
+	return p != nil && p.ForkedFromProject != nil
+}
+
+// GLProjectsIter represents an iterator of *GLProject.
+type GLProjectsIter interface {
+	Next(context.Context) (*GLProject, time.Duration, error)
+}
+
+// GLProjectsIterOpts represents configuration options for a
+// GLNamespaceProjectsIter.
+type GLProjectsIterOpts struct {
+	BaseURL        string
+	HTTPTimeout    time.Duration
+	ResultsPerPage int
+	TimeNewRepos   time.Duration
+	AuthToken      string
+}
+
+const (
+	gitlabURL = "https://gitlab.com"
+	gitlabAPI = "/api/v4"
+
+	glRateLimitHeader     = "RateLimit-Limit"
+	glRateRemainingHeader = "RateLimit-Remaining"
+	glRateResetHeader     = "RateLimit-Reset"
+	glNextPageHeader      = "X-Next-Page"
+	glTokenHeader         = "PRIVATE-TOKEN"
+)
+
+type glNamespaceKind uint8
+
+const (
+	glUnknownNamespace glNamespaceKind = iota
+	glGroupNamespace
+	glUserNamespace
+)
+
+// GLNamespaceProjectsIter is a GLProjectsIter by GitLab namespace. The
+// namespace can be a group, a subgroup (using its full path) or a user.
+// Projects in subgroups are also listed for groups.
+type GLNamespaceProjectsIter struct {
+	namespace     string
+	kind          glNamespaceKind
+	baseURL       string
+	token         string
+	excludedRepos map[string]struct{}
+	client        *http.Client
+	projects      []*GLProject
+	page          int
+	perPage       int
+	checkpoint    int
+	waitNewRepos  time.Duration
+}
+
+var _ GLProjectsIter = (*GLNamespaceProjectsIter)(nil)
+
+// NewGLNamespaceProjectsIter builds a new GLNamespaceProjectsIter.
+func NewGLNamespaceProjectsIter(
+	namespace string,
+	excludedRepos []string,
+	opts *GLProjectsIterOpts,
+) *GLNamespaceProjectsIter {
+	if opts == nil {
+		opts = &GLProjectsIterOpts{}
+	}
+
+	base := strings.TrimSuffix(opts.BaseURL, "/")
+	if base == "" {
+		base = gitlabURL
+	}
+
+	to := opts.HTTPTimeout
+	if to <= 0 {
+		to = httpTimeout
+	}
+
+	rpp := opts.ResultsPerPage
+	if rpp <= 0 || rpp > 100 {
+		rpp = resultsPerPage
+	}
+
+	wnr := opts.TimeNewRepos
+	if wnr <= 0 {
+		wnr = waitNewRepos
+	}
+
+	excludedReposSet := make(map[string]struct{})
+	for _, excludedRepo := range excludedRepos {
+		excludedReposSet[excludedRepo] = struct{}{}
+	}
+
+	return &GLNamespaceProjectsIter{
+		namespace:     namespace,
+		baseURL:       base,
+		token:         opts.AuthToken,
+		excludedRepos: excludedReposSet,
+		client:        &http.Client{Timeout: to},
+		page:          1,
+		perPage:       rpp,
+		waitNewRepos:  wnr,
+	}
+}
+
+// Next implements the GLProjectsIter interface.
+func (p *GLNamespaceProjectsIter) Next(
+	ctx context.Context,
+) (*GLProject, time.Duration, error) {
+	for {
+		if len(p.projects) == 0 {
+			retry, err := p.requestProjects(ctx)
+			if err != nil && len(p.projects) == 0 {
+				return nil, retry, err
+			}
+
+			if len(p.projects) == 0 {
+				// an empty page which isn't the last one, the
+				// next one is requested.
+				continue
+			}
+		}
+
+		var next *GLProject
+		next, p.projects = p.projects[0], p.projects[1:]
+		if _, ok := p.excludedRepos[next.Name]; !ok {
+			return next, 0, nil
+		}
+	}
+}
+
+func (p *GLNamespaceProjectsIter) requestProjects(
+	ctx context.Context,
+) (time.Duration, error) {
+	if p.kind == glUnknownNamespace {
+		p.kind = glGroupNamespace
+	}
+
+	projects, res, err := p.listProjects(ctx)
+	if err != nil {
+		if ErrNamespaceNotFound.Is(err) && p.kind == glGroupNamespace {
+			// it isn't a group, try with a user namespace
+			p.kind = glUserNamespace
+			projects, res, err = p.listProjects(ctx)
+		}
+
+		if err != nil {
+			if ErrNamespaceNotFound.Is(err) {
+				p.kind = glUnknownNamespace
+			}
+
+			if ErrRateLimitExceeded.Is(err) {
+				return glTimeToRetry(res), err
+			}
+
+			return -1, err
+		}
+	}
+
+	bufProjects := projects
+	if p.checkpoint > 0 {
+		i := p.checkpoint
+		if len(projects) < p.checkpoint {
+			i = 0
+		}
+
+		bufProjects = projects[i:]
+	}
+
+	next := res.Header.Get(glNextPageHeader)
+	if next == "" {
+		// last page reached, remember where it ended to only
+		// return the new projects on the next request.
+		if len(projects) == p.perPage {
+			p.page++
+			p.checkpoint = 0
+		} else {
+			p.checkpoint = len(projects)
+		}
+
+		err = ErrNewRepositoriesNotFound.New()
+	} else {
+		page, perr := strconv.Atoi(next)
+		if perr != nil {
+			return -1, perr
+		}
+
+		p.page = page
+		p.checkpoint = 0
+	}
+
+	p.projects = bufProjects
+	return p.waitNewRepos, err
+}
+
+func (p *GLNamespaceProjectsIter) listProjects(
+	ctx context.Context,
+) ([]*GLProject, *http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, p.projectsURL(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req = req.WithContext(ctx)
+	if p.token != "" {
+		req.Header.Set(glTokenHeader, p.token)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, res, ErrNamespaceNotFound.New(p.namespace)
+	case http.StatusTooManyRequests:
+		return nil, res, ErrRateLimitExceeded.New()
+	default:
+		return nil, res, ErrGitLabResponse.New(
+			res.StatusCode,
+			http.StatusText(res.StatusCode),
+		)
+	}
+
+	var projects []*GLProject
+	if err := json.NewDecoder(res.Body).Decode(&projects); err != nil {
+		return nil, res, err
+	}
+
+	return projects, res, nil
+}
+
+func (p *GLNamespaceProjectsIter) projectsURL() string {
+	kind := "groups"
+	if p.kind == glUserNamespace {
+		kind = "users"
+	}
+
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(p.page))
+	query.Set("per_page", strconv.Itoa(p.perPage))
+	query.Set("order_by", "id")
+	query.Set("sort", "asc")
+	if p.kind == glGroupNamespace {
+		query.Set("include_subgroups", "true")
+	}
+
+	return fmt.Sprintf(
+		"%s%s/%s/%s/projects?%s",
+		p.baseURL,
+		gitlabAPI,
+		kind,
+		url.PathEscape(p.namespace),
+		query.Encode(),
+	)
+}
+
+// glTimeToRetry is the GitLab counterpart of timeToRetry. It computes the
+// time to wait before the next request using the RateLimit-* headers sent by
+// GitLab.
+func glTimeToRetry(res *http.Response) time.Duration {
+	if res == nil {
+		return -1
+	}
+
+	if after, err := strconv.Atoi(
+		res.Header.Get("Retry-After"),
+	); err == nil && after > 0 {
+		return time.Duration(after) * time.Second
+	}
+
+	limit, _ := strconv.Atoi(res.Header.Get(glRateLimitHeader))
+	remaining, _ := strconv.Atoi(res.Header.Get(glRateRemainingHeader))
+	reset, _ := strconv.ParseInt(res.Header.Get(glRateResetHeader), 10, 64)
+
+	now := time.Now().UTC().Unix()
+	timeToReset := time.Duration(reset-now) * time.Second
+	if timeToReset < 0 || timeToReset > 1*time.Minute {
+		// GitLab rate limits are applied per minute, so if the reset
+		// time is out of that window the clock is probably wrong and
+		// we assume we are at the beginning of the window.
+		timeToReset = 1 * time.Minute
+		remaining = limit
+	}
+
+	return timeToReset / time.Duration(remaining+1)
+}
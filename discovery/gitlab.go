@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"context"
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// AdvertiseGLProjectsFn is used by a GitLab to notify that a new project has
+// been discovered.
+type AdvertiseGLProjectsFn func(context.Context, []*GLProject) error
+
+// GitLabOpts represents configuration options for a GitLab discovery.
+type GitLabOpts struct {
+	AdvertiseTimeout time.Duration
+	SkipForks        bool
+	WaitNewRepos     bool
+	WaitOnRateLimit  bool
+	StopTimeout      time.Duration
+	BatchSize        int
+	// Protocol is the preferred protocol for the endpoints of the
+	// projects, https by default.
+	Protocol Protocol
+}
+
+// GitLab will retrieve the information for all the projects for the given
+// GLProjectsIter.
+type GitLab struct {
+	advertiseProjects AdvertiseGLProjectsFn
+	iter              GLProjectsIter
+	batch             []*GLProject
+	cancel            chan struct{}
+	backoff           *backoff.Backoff
+	opts              *GitLabOpts
+}
+
+// NewGitLab builds a new GitLab.
+func NewGitLab(
+	advertiseProjects AdvertiseGLProjectsFn,
+	iter GLProjectsIter,
+	opts *GitLabOpts,
+) *GitLab {
+	if opts == nil {
+		opts = &GitLabOpts{}
+	}
+
+	if opts.StopTimeout <= 0 {
+		opts.StopTimeout = stopTimeout
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = batchSize
+	}
+
+	if opts.AdvertiseTimeout <= 0 {
+		to := time.Duration(5*opts.BatchSize) * time.Second
+		opts.AdvertiseTimeout = to
+	}
+
+	if advertiseProjects == nil {
+		advertiseProjects = func(context.Context, []*GLProject) error {
+			return nil
+		}
+	}
+
+	return &GitLab{
+		advertiseProjects: advertiseProjects,
+		iter:              iter,
+		batch:             make([]*GLProject, 0, opts.BatchSize),
+		cancel:            make(chan struct{}),
+		backoff:           newBackoff(),
+		opts:              opts,
+	}
+}
+
+// Start starts the GitLab.
+func (p *GitLab) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		var err error
+		done := make(chan struct{})
+		go func() {
+			err = p.discoverProjects(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			if err != nil {
+				if ErrDiscoveryStopped.Is(err) && len(p.batch) > 0 {
+					if serr := p.sendBatch(ctx); serr != nil {
+						return serr
+					}
+				}
+
+				return err
+			}
+		case <-p.cancel:
+			return ErrDiscoveryStopped.New()
+		}
+	}
+}
+
+func (p *GitLab) discoverProjects(ctx context.Context) error {
+	project, retry, err := p.iter.Next(ctx)
+	if err != nil {
+		if ErrNewRepositoriesNotFound.Is(err) && !p.opts.WaitNewRepos {
+			return ErrDiscoveryStopped.Wrap(err)
+		}
+
+		if ErrRateLimitExceeded.Is(err) && !p.opts.WaitOnRateLimit {
+			return ErrDiscoveryStopped.Wrap(err)
+		}
+
+		if retry <= 0 {
+			return err
+		}
+
+		time.Sleep(retry)
+		return nil
+	}
+
+	if p.opts.SkipForks && project.GetFork() {
+		return nil
+	}
+
+	p.batch = append(p.batch, project)
+	if len(p.batch) < p.opts.BatchSize {
+		return nil
+	}
+
+	ctxto, cancel := context.WithTimeout(ctx, p.opts.AdvertiseTimeout)
+	defer cancel()
+
+	if err := p.sendBatch(ctxto); err != nil {
+		if !ErrAdvertiseTimeout.Is(err) {
+			return err
+		}
+
+		time.Sleep(p.backoff.Duration())
+	} else {
+		p.backoff.Reset()
+	}
+
+	return nil
+}
+
+func (p *GitLab) sendBatch(ctx context.Context) error {
+	if err := p.advertiseProjects(ctx, p.batch); err != nil {
+		return err
+	}
+
+	p.batch = make([]*GLProject, 0, p.opts.BatchSize)
+	return nil
+}
+
+// GetGLEndpoint gets the endpoint for a gitlab project.
+func GetGLEndpoint(p *GLProject) (string, error) {
+	return GetGLEndpointByProtocol(p, ProtocolHTTPS)
+}
+
+// Stop stops the GitLab.
+func (p *GitLab) Stop() error {
+	select {
+	case p.cancel <- struct{}{}:
+		return nil
+	case <-time.After(p.opts.StopTimeout):
+		return ErrDiscoveryStop.New()
+	}
+}
@@ -0,0 +1,276 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitLab(t *testing.T) {
+	var req = require.New(t)
+
+	api := newGitLabAPI()
+	api.addProjects("groups", "src-d", 7, 2)
+	api.addProjects("groups", "src-d/sub", 3, 0)
+	api.addProjects("users", "alice", 4, 0)
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	for _, tst := range []struct {
+		namespace string
+		excluded  []string
+		skipForks bool
+		expected  int
+	}{
+		{"src-d", nil, false, 10},
+		{"src-d", []string{"project-0"}, false, 8},
+		{"src-d", nil, true, 8},
+		{"src-d/sub", nil, false, 3},
+		{"alice", nil, false, 4},
+	} {
+		var got []*GLProject
+		advertise := func(_ context.Context, ps []*GLProject) error {
+			got = append(got, ps...)
+			return nil
+		}
+
+		discovery := NewGitLab(
+			advertise,
+			NewGLNamespaceProjectsIter(
+				tst.namespace,
+				tst.excluded,
+				&GLProjectsIterOpts{
+					BaseURL:        server.URL,
+					ResultsPerPage: 2,
+					AuthToken:      "secret",
+				},
+			),
+			&GitLabOpts{SkipForks: tst.skipForks},
+		)
+
+		err := discovery.Start()
+		req.True(ErrDiscoveryStopped.Is(err), err.Error())
+		req.Len(got, tst.expected, tst.namespace)
+
+		for _, p := range got {
+			ep, err := GetGLEndpoint(p)
+			req.NoError(err)
+			req.True(strings.Contains(ep, tst.namespace))
+			for _, e := range tst.excluded {
+				req.NotEqual(e, p.Name)
+			}
+		}
+	}
+}
+
+func TestGitLabNewProjects(t *testing.T) {
+	var req = require.New(t)
+
+	api := newGitLabAPI()
+	api.addProjects("groups", "src-d", 3, 0)
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	iter := NewGLNamespaceProjectsIter("src-d", nil, &GLProjectsIterOpts{
+		BaseURL:        server.URL,
+		ResultsPerPage: 2,
+	})
+
+	var names []string
+	for {
+		p, _, err := iter.Next(context.Background())
+		if err != nil {
+			req.True(ErrNewRepositoriesNotFound.Is(err), err.Error())
+			break
+		}
+
+		names = append(names, p.Name)
+	}
+
+	req.Len(names, 3)
+
+	api.addProjects("groups", "src-d", 2, 0)
+	for {
+		p, _, err := iter.Next(context.Background())
+		if err != nil {
+			req.True(ErrNewRepositoriesNotFound.Is(err), err.Error())
+			break
+		}
+
+		names = append(names, p.Name)
+	}
+
+	req.Equal([]string{
+		"project-0", "project-1", "project-2", "project-3", "project-4",
+	}, names)
+}
+
+func TestGitLabErrors(t *testing.T) {
+	var req = require.New(t)
+
+	api := newGitLabAPI()
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	iter := NewGLNamespaceProjectsIter("unknown", nil, &GLProjectsIterOpts{
+		BaseURL: server.URL,
+	})
+
+	_, _, err := iter.Next(context.Background())
+	req.True(ErrNamespaceNotFound.Is(err))
+
+	api.addProjects("groups", "src-d", 3, 0)
+	api.rateLimited = true
+	iter = NewGLNamespaceProjectsIter("src-d", nil, &GLProjectsIterOpts{
+		BaseURL: server.URL,
+	})
+
+	_, retry, err := iter.Next(context.Background())
+	req.True(ErrRateLimitExceeded.Is(err))
+	req.True(retry > 0 && retry <= 2*time.Second, retry.String())
+
+	discovery := NewGitLab(nil, iter, nil)
+	err = discovery.Start()
+	req.True(ErrDiscoveryStopped.Is(err))
+}
+
+func TestGitLabEmptyPage(t *testing.T) {
+	var req = require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set(glNextPageHeader, "2")
+				w.Write([]byte("[]"))
+				return
+			}
+
+			w.Write([]byte(`[{"id": 1, "name": "foo"}]`))
+		},
+	))
+	defer server.Close()
+
+	iter := NewGLNamespaceProjectsIter("src-d", nil, &GLProjectsIterOpts{
+		BaseURL: server.URL,
+	})
+
+	// the empty page doesn't stop the discovery of the next ones.
+	project, _, err := iter.Next(context.Background())
+	req.NoError(err)
+	req.Equal("foo", project.Name)
+
+	_, _, err = iter.Next(context.Background())
+	req.True(ErrNewRepositoriesNotFound.Is(err))
+}
+
+type gitLabAPI struct {
+	mu          sync.Mutex
+	projects    map[string][]*GLProject
+	nextID      int
+	rateLimited bool
+}
+
+func newGitLabAPI() *gitLabAPI {
+	return &gitLabAPI{projects: map[string][]*GLProject{}}
+}
+
+func (a *gitLabAPI) addProjects(kind, namespace string, n, forks int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := kind + "/" + namespace
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("project-%d", len(a.projects[key]))
+		path := namespace + "/" + name
+		p := &GLProject{
+			ID:                a.nextID,
+			Name:              name,
+			PathWithNamespace: path,
+			WebURL:            "https://gitlab.test/" + path,
+			HTTPURLToRepo:     "https://gitlab.test/" + path + ".git",
+			SSHURLToRepo:      "git@gitlab.test:" + path + ".git",
+		}
+
+		if i < forks {
+			p.ForkedFromProject = &GLForkParent{ID: 1000 + i}
+		}
+
+		a.nextID++
+		a.projects[key] = append(a.projects[key], p)
+	}
+}
+
+func (a *gitLabAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rateLimited {
+		reset := time.Now().Add(time.Second).Unix()
+		w.Header().Set(glRateLimitHeader, "600")
+		w.Header().Set(glRateRemainingHeader, "0")
+		w.Header().Set(glRateResetHeader, strconv.FormatInt(reset, 10))
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), gitlabAPI+"/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[2] != "projects" {
+		http.NotFound(w, r)
+		return
+	}
+
+	namespace, err := url.PathUnescape(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prefix := parts[0] + "/" + namespace
+	var projects []*GLProject
+	for key, ps := range a.projects {
+		if key == prefix || (parts[0] == "groups" &&
+			r.URL.Query().Get("include_subgroups") == "true" &&
+			strings.HasPrefix(key, prefix+"/")) {
+			projects = append(projects, ps...)
+		}
+	}
+
+	if len(projects) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].ID < projects[j].ID
+	})
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	start, end := (page-1)*perPage, page*perPage
+	if start > len(projects) {
+		start = len(projects)
+	}
+
+	if end >= len(projects) {
+		end = len(projects)
+	} else {
+		w.Header().Set(glNextPageHeader, strconv.Itoa(page+1))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects[start:end])
+}
@@ -0,0 +1,83 @@
+package discovery
+
+import (
+	"strings"
+
+	"github.com/google/go-github/v28/github"
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+// ErrUnknownProtocol is returned when a protocol isn't supported.
+var ErrUnknownProtocol = errors.NewKind("unknown protocol %s")
+
+// Protocol is the preferred protocol for the endpoints of the discovered
+// repositories.
+type Protocol string
+
+const (
+	// ProtocolHTTPS prefers https endpoints.
+	ProtocolHTTPS Protocol = "https"
+	// ProtocolSSH prefers ssh endpoints.
+	ProtocolSSH Protocol = "ssh"
+	// ProtocolGit prefers git protocol endpoints.
+	ProtocolGit Protocol = "git"
+)
+
+// ParseProtocol returns the Protocol with the given name.
+func ParseProtocol(name string) (Protocol, error) {
+	switch p := Protocol(strings.ToLower(name)); p {
+	case ProtocolHTTPS, ProtocolSSH, ProtocolGit:
+		return p, nil
+	case "":
+		return ProtocolHTTPS, nil
+	default:
+		return "", ErrUnknownProtocol.New(name)
+	}
+}
+
+// GetGHEndpointByProtocol gets the endpoint for a github repository
+// preferring the given protocol. Any other endpoint is returned if the
+// repository has no endpoint for that protocol.
+func GetGHEndpointByProtocol(
+	r *github.Repository,
+	protocol Protocol,
+) (string, error) {
+	var getURLs []func() string
+	switch protocol {
+	case ProtocolSSH:
+		getURLs = []func() string{r.GetSSHURL, r.GetHTMLURL, r.GetGitURL}
+	case ProtocolGit:
+		getURLs = []func() string{r.GetGitURL, r.GetHTMLURL, r.GetSSHURL}
+	default:
+		getURLs = []func() string{r.GetHTMLURL, r.GetGitURL, r.GetSSHURL}
+	}
+
+	for _, getURL := range getURLs {
+		if ep := getURL(); ep != "" {
+			return ep, nil
+		}
+	}
+
+	return "", ErrEndpointsNotFound.New(r.GetFullName())
+}
+
+// GetGLEndpointByProtocol gets the endpoint for a gitlab project preferring
+// the given protocol. Any other endpoint is returned if the project has no
+// endpoint for that protocol, gitlab doesn't serve the git protocol.
+func GetGLEndpointByProtocol(
+	p *GLProject,
+	protocol Protocol,
+) (string, error) {
+	eps := []string{p.HTTPURLToRepo, p.WebURL, p.SSHURLToRepo}
+	if protocol == ProtocolSSH {
+		eps = []string{p.SSHURLToRepo, p.HTTPURLToRepo, p.WebURL}
+	}
+
+	for _, ep := range eps {
+		if ep != "" {
+			return ep, nil
+		}
+	}
+
+	return "", ErrEndpointsNotFound.New(p.PathWithNamespace)
+}
@@ -0,0 +1,53 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointsByProtocol(t *testing.T) {
+	var require = require.New(t)
+
+	repo := &github.Repository{
+		HTMLURL: github.String("https://github.com/src-d/foo"),
+		GitURL:  github.String("git://github.com/src-d/foo.git"),
+		SSHURL:  github.String("git@github.com:src-d/foo.git"),
+	}
+
+	project := &GLProject{
+		HTTPURLToRepo: "https://gitlab.com/src-d/foo.git",
+		SSHURLToRepo:  "git@gitlab.com:src-d/foo.git",
+	}
+
+	for _, tst := range []struct {
+		protocol string
+		github   string
+		gitlab   string
+	}{
+		{"", *repo.HTMLURL, project.HTTPURLToRepo},
+		{"https", *repo.HTMLURL, project.HTTPURLToRepo},
+		{"SSH", *repo.SSHURL, project.SSHURLToRepo},
+		{"git", *repo.GitURL, project.HTTPURLToRepo},
+	} {
+		p, err := ParseProtocol(tst.protocol)
+		require.NoError(err)
+
+		ep, err := GetGHEndpointByProtocol(repo, p)
+		require.NoError(err)
+		require.Equal(tst.github, ep, tst.protocol)
+
+		ep, err = GetGLEndpointByProtocol(project, p)
+		require.NoError(err)
+		require.Equal(tst.gitlab, ep, tst.protocol)
+	}
+
+	_, err := ParseProtocol("ftp")
+	require.True(ErrUnknownProtocol.Is(err))
+
+	repo.SSHURL = nil
+	ep, err := GetGHEndpointByProtocol(repo, ProtocolSSH)
+	require.NoError(err)
+	require.Equal(*repo.HTMLURL, ep)
+}
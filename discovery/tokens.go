@@ -0,0 +1,388 @@
+package discovery
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+)
+
+const (
+	headerRateLimit     = "X-RateLimit-Limit"
+	headerRateRemaining = "X-RateLimit-Remaining"
+	headerRateReset     = "X-RateLimit-Reset"
+	headerRateResource  = "X-RateLimit-Resource"
+
+	// coreResource and searchResource are the rate limit resources of
+	// the requests, the search API has its own quota.
+	coreResource   = "core"
+	searchResource = "search"
+
+	// defaultRateLimit is the number of requests per hour GitHub allows
+	// to an authenticated user, it's assumed for the tokens not used yet.
+	defaultRateLimit = 5000
+	// defaultSearchRateLimit is the number of search requests per minute
+	// GitHub allows to an authenticated user.
+	defaultSearchRateLimit = 30
+)
+
+// TokenQuota is the rate limit quota of a token of a TokenPool.
+type TokenQuota struct {
+	// Token identifies the token without revealing it.
+	Token     string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+type poolToken struct {
+	token string
+	id    string
+	// rates are the known quotas of the token by resource.
+	rates map[string]github.Rate
+}
+
+// remaining returns the requests of the given resource left for the token
+// at the given time.
+func (t *poolToken) remaining(resource string, now time.Time) int {
+	rate, ok := t.rates[resource]
+	if !ok {
+		if resource == searchResource {
+			return defaultSearchRateLimit
+		}
+
+		return defaultRateLimit
+	}
+
+	if now.After(rate.Reset.Time) {
+		return rate.Limit
+	}
+
+	return rate.Remaining
+}
+
+// TokenPool is a set of GitHub tokens. Every request is authenticated with
+// the token with most remaining quota, which is tracked from the X-RateLimit
+// headers of the responses. The quota of the search API is tracked apart
+// from the core one.
+type TokenPool struct {
+	mu     sync.Mutex
+	tokens []*poolToken
+	now    func() time.Time
+}
+
+// NewTokenPool builds a new TokenPool with the given tokens. Empty and
+// duplicated tokens are ignored, the requests of a pool without tokens are
+// sent unauthenticated.
+func NewTokenPool(tokens ...string) *TokenPool {
+	p := &TokenPool{now: time.Now}
+	seen := make(map[string]struct{}, len(tokens))
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if _, ok := seen[token]; ok || token == "" {
+			continue
+		}
+
+		seen[token] = struct{}{}
+		p.tokens = append(p.tokens, &poolToken{
+			token: token,
+			id:    tokenID(len(p.tokens), token),
+			rates: make(map[string]github.Rate),
+		})
+	}
+
+	return p
+}
+
+// tokenID identifies a token by its position and its last characters.
+func tokenID(i int, token string) string {
+	const visible = 4
+	if len(token) > visible {
+		token = token[len(token)-visible:]
+	}
+
+	return strconv.Itoa(i) + "-" + token
+}
+
+// LoadTokens reads a file with a token per line. Empty lines and lines
+// starting with # are skipped.
+func LoadTokens(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		tokens  []string
+		scanner = bufio.NewScanner(f)
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		tokens = append(tokens, line)
+	}
+
+	return tokens, scanner.Err()
+}
+
+// Len returns the number of tokens in the pool.
+func (p *TokenPool) Len() int {
+	return len(p.tokens)
+}
+
+// Token returns the token with most remaining core quota or an empty string
+// if the pool has no tokens.
+func (p *TokenPool) Token() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.best(coreResource, nil)
+	if t == nil {
+		return ""
+	}
+
+	return t.token
+}
+
+func (p *TokenPool) best(
+	resource string,
+	skip map[*poolToken]struct{},
+) *poolToken {
+	var (
+		best      *poolToken
+		remaining int
+		now       = p.now()
+	)
+
+	for _, t := range p.tokens {
+		if _, ok := skip[t]; ok {
+			continue
+		}
+
+		r := t.remaining(resource, now)
+		if best == nil || r > remaining {
+			best, remaining = t, r
+		}
+	}
+
+	return best
+}
+
+// Update records the quota of a token from the X-RateLimit headers of a
+// response. The quota is recorded for the resource in the
+// X-RateLimit-Resource header, or for the core one if it's missing.
+func (p *TokenPool) Update(token string, header http.Header) {
+	p.update(token, headerResource(header, coreResource), header)
+}
+
+func (p *TokenPool) update(token, resource string, header http.Header) {
+	rate, ok := parseRate(header)
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range p.tokens {
+		if t.token == token {
+			t.rates[resource] = rate
+			return
+		}
+	}
+}
+
+// Quotas returns the known core quota of every token in the pool.
+func (p *TokenPool) Quotas() []TokenQuota {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	quotas := make([]TokenQuota, 0, len(p.tokens))
+	for _, t := range p.tokens {
+		q := TokenQuota{
+			Token:     t.id,
+			Limit:     defaultRateLimit,
+			Remaining: t.remaining(coreResource, now),
+		}
+
+		if rate, ok := t.rates[coreResource]; ok {
+			q.Limit = rate.Limit
+			q.Reset = rate.Reset.Time
+		}
+
+		quotas = append(quotas, q)
+	}
+
+	return quotas
+}
+
+// Transport returns an http.RoundTripper authenticating the requests with
+// the tokens of the pool. A request rejected because of the rate limit is
+// sent again with the next token with remaining quota. The X-RateLimit
+// headers of the successful responses are replaced by the quota of the best
+// token of the pool for the same resource, so the rate limit seen by a
+// github.Client is the one of the whole pool.
+func (p *TokenPool) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &poolTransport{pool: p, base: base}
+}
+
+type poolTransport struct {
+	pool *TokenPool
+	base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		tried    = make(map[*poolToken]struct{}, t.pool.Len())
+		resource = requestResource(req)
+	)
+
+	for {
+		t.pool.mu.Lock()
+		token := t.pool.best(resource, tried)
+		t.pool.mu.Unlock()
+
+		r := req
+		if token != nil {
+			tried[token] = struct{}{}
+			r = cloneRequest(req)
+			r.Header.Set("Authorization", "token "+token.token)
+		}
+
+		res, err := t.base.RoundTrip(r)
+		if err != nil || token == nil {
+			return res, err
+		}
+
+		t.pool.update(
+			token.token,
+			headerResource(res.Header, resource),
+			res.Header,
+		)
+
+		if !isRateLimited(res) {
+			t.pool.rewriteRate(resource, res.Header)
+			return res, nil
+		}
+
+		if len(tried) == t.pool.Len() || !rewindable(req) {
+			return res, nil
+		}
+
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
+func (p *TokenPool) rewriteRate(resource string, header http.Header) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.best(resource, nil)
+	if t == nil {
+		return
+	}
+
+	rate, ok := t.rates[resource]
+	if !ok {
+		// a github.Client doesn't limit the requests without a
+		// known rate.
+		header.Del(headerRateLimit)
+		header.Del(headerRateRemaining)
+		header.Del(headerRateReset)
+		return
+	}
+
+	header.Set(headerRateLimit, strconv.Itoa(rate.Limit))
+	header.Set(headerRateRemaining,
+		strconv.Itoa(t.remaining(resource, p.now())))
+	header.Set(headerRateReset, strconv.FormatInt(rate.Reset.Unix(), 10))
+}
+
+// requestResource returns the rate limit resource of a request to the api of
+// github.com or of a github enterprise server.
+func requestResource(req *http.Request) string {
+	if strings.HasPrefix(req.URL.Path, "/search/") ||
+		strings.Contains(req.URL.Path, "/api/v3/search/") {
+		return searchResource
+	}
+
+	return coreResource
+}
+
+// headerResource returns the rate limit resource of a response, or the given
+// one if it's unknown.
+func headerResource(header http.Header, resource string) string {
+	if r := header.Get(headerRateResource); r != "" {
+		return r
+	}
+
+	return resource
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	r := req.WithContext(req.Context())
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			r.Body = body
+		}
+	}
+
+	return r
+}
+
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func isRateLimited(res *http.Response) bool {
+	return (res.StatusCode == http.StatusForbidden ||
+		res.StatusCode == http.StatusTooManyRequests) &&
+		res.Header.Get(headerRateRemaining) == "0"
+}
+
+func parseRate(header http.Header) (github.Rate, bool) {
+	var rate github.Rate
+	limit, err := strconv.Atoi(header.Get(headerRateLimit))
+	if err != nil {
+		return rate, false
+	}
+
+	remaining, err := strconv.Atoi(header.Get(headerRateRemaining))
+	if err != nil {
+		return rate, false
+	}
+
+	reset, err := strconv.ParseInt(header.Get(headerRateReset), 10, 64)
+	if err != nil {
+		return rate, false
+	}
+
+	rate.Limit = limit
+	rate.Remaining = remaining
+	rate.Reset = github.Timestamp{Time: time.Unix(reset, 0)}
+	return rate, true
+}
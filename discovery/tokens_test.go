@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenPool(t *testing.T) {
+	var require = require.New(t)
+
+	reset := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	var (
+		mu        sync.Mutex
+		remaining = map[string]int{"token a": 1, "token b": 3}
+		requests  = map[string]int{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			token := r.Header.Get("Authorization")
+			requests[token]++
+
+			h := w.Header()
+			h.Set(headerRateLimit, "5000")
+			h.Set(headerRateReset, strconv.FormatInt(reset.Unix(), 10))
+			if remaining[token] == 0 {
+				h.Set(headerRateRemaining, "0")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"message": "API rate limit exceeded for user"}`)
+				return
+			}
+
+			remaining[token]--
+			h.Set(headerRateRemaining, strconv.Itoa(remaining[token]))
+			fmt.Fprint(w, `[{"name": "foo"}]`)
+		},
+	))
+	defer srv.Close()
+
+	pool := NewTokenPool("a", "", "b", "a")
+	require.Equal(2, pool.Len())
+
+	client := newGithubClient("", pool, time.Minute, &GHReposIterOpts{})
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	list := func() error {
+		_, _, err := client.Repositories.ListByOrg(
+			context.Background(), "src-d", nil,
+		)
+		return err
+	}
+
+	// the tokens without a known quota are used first.
+	require.NoError(list())
+	require.NoError(list())
+	require.Equal(map[string]int{"token a": 1, "token b": 1}, requests)
+	require.Equal("b", pool.Token())
+
+	// a token exhausted before its known quota is rotated within the
+	// same request.
+	stale := http.Header{}
+	stale.Set(headerRateLimit, "5000")
+	stale.Set(headerRateRemaining, "10")
+	stale.Set(headerRateReset, strconv.FormatInt(reset.Unix(), 10))
+	pool.Update("a", stale)
+	require.NoError(list())
+	require.Equal(map[string]int{"token a": 2, "token b": 2}, requests)
+
+	// once every token is exhausted the client is rate limited.
+	require.NoError(list())
+	err := list()
+	_, ok := err.(*github.RateLimitError)
+	require.True(ok, err)
+	require.Equal(map[string]int{"token a": 2, "token b": 3}, requests)
+
+	require.Equal([]TokenQuota{
+		{Token: "0-a", Limit: 5000, Remaining: 0, Reset: reset},
+		{Token: "1-b", Limit: 5000, Remaining: 0, Reset: reset},
+	}, pool.Quotas())
+
+	// the quota of every token is restored after the reset time.
+	pool.now = func() time.Time { return reset.Add(time.Second) }
+	for _, q := range pool.Quotas() {
+		require.Equal(5000, q.Remaining)
+	}
+}
+
+func TestTokenPoolSearch(t *testing.T) {
+	var require = require.New(t)
+
+	reset := time.Unix(time.Now().Add(time.Minute).Unix(), 0)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set(headerRateReset, strconv.FormatInt(reset.Unix(), 10))
+			if r.URL.Path == "/search/repositories" {
+				h.Set(headerRateLimit, "30")
+				h.Set(headerRateRemaining, "29")
+				h.Set(headerRateResource, searchResource)
+				fmt.Fprint(w, `{"total_count": 0, "items": []}`)
+				return
+			}
+
+			h.Set(headerRateLimit, "5000")
+			h.Set(headerRateRemaining, "4999")
+			fmt.Fprint(w, `[]`)
+		},
+	))
+	defer srv.Close()
+
+	pool := NewTokenPool("a")
+	client := newGithubClient("", pool, time.Minute, &GHReposIterOpts{})
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	ctx := context.Background()
+	_, res, err := client.Search.Repositories(ctx, "foo", nil)
+	require.NoError(err)
+	require.Equal(30, res.Rate.Limit)
+	require.Equal(29, res.Rate.Remaining)
+
+	// the search quota isn't taken as the core one.
+	require.Equal([]TokenQuota{
+		{Token: "0-a", Limit: 5000, Remaining: 5000},
+	}, pool.Quotas())
+
+	_, res, err = client.Repositories.ListByOrg(ctx, "foo", nil)
+	require.NoError(err)
+	require.Equal(5000, res.Rate.Limit)
+	require.Equal(4999, res.Rate.Remaining)
+	require.Equal([]TokenQuota{
+		{Token: "0-a", Limit: 5000, Remaining: 4999, Reset: reset},
+	}, pool.Quotas())
+}
+
+func TestLoadTokens(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-tokens")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "tokens")
+	require.NoError(ioutil.WriteFile(
+		file, []byte("# comment\nfoo\n\n  bar  \n"), 0644,
+	))
+
+	tokens, err := LoadTokens(file)
+	require.NoError(err)
+	require.Equal([]string{"foo", "bar"}, tokens)
+
+	_, err = LoadTokens(filepath.Join(dir, "missing"))
+	require.Error(err)
+}
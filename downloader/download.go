@@ -14,6 +14,8 @@ import (
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/util"
 	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-log.v1"
 )
 
@@ -29,6 +31,13 @@ var (
 // Download is a library.JobFn function to download a git repository and store
 // it in a borges.Library.
 func Download(ctx context.Context, job *library.Job) error {
+	if job.Type == library.JobUpdate && job.AllowUpdate &&
+		job.LocationID != "" {
+		// the job was turned into an update of an already downloaded
+		// repository and it's being retried.
+		return updater.Update(ctx, job)
+	}
+
 	logger := job.Logger.New(log.Fields{"job": "download", "id": job.ID})
 	if job.Type != library.JobDownload ||
 		len(job.Endpoints()) == 0 ||
@@ -75,19 +84,28 @@ func Download(ctx context.Context, job *library.Job) error {
 
 	logger.Infof("started")
 	start := time.Now()
-	if err := downloadRepository(
+	locID, err = downloadRepository(
 		ctx,
 		logger,
 		lib,
 		job.TempFS,
 		repoID,
 		endpoint,
-		job.AuthToken,
-	); err != nil {
+		job.AuthMethod,
+		job.LockLocation,
+		job.ObservePhase,
+		job.RunPhase,
+		job.RunCommit,
+	)
+	if err != nil {
 		logger.Errorf(err, "failed")
 		return err
 	}
 
+	if loc, err := lib.Location(locID); err == nil {
+		job.UpdateCatalog(loc)
+	}
+
 	elapsed := time.Since(start).String()
 	logger.With(log.Fields{"elapsed": elapsed}).Infof("finished")
 	return nil
@@ -126,74 +144,131 @@ func downloadRepository(
 	tmp billy.Filesystem,
 	id borges.RepositoryID,
 	endpoint string,
-	authToken library.AuthTokenFn,
-) error {
+	authMethod library.AuthMethodFn,
+	lock library.LockFn,
+	observe func(library.Phase, time.Duration),
+	run library.PhaseFn,
+	commit library.CommitFn,
+) (borges.LocationID, error) {
 	clonePath := filepath.Join(
 		cloneRootPath,
 		fmt.Sprintf("%s_%d", id, time.Now().UnixNano()),
 	)
 
-	token := authToken(endpoint)
-
-	start := time.Now()
-	repo, err := CloneRepository(
-		ctx, tmp, clonePath, endpoint, id.String(), token,
-	)
-
+	auth, err := authMethod(endpoint)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	elapsed := time.Since(start).String()
-	logger.With(log.Fields{"elapsed": elapsed}).Debugf("cloned")
-
+	// the clone is removed even if any phase is cancelled or times out.
 	defer func() {
 		if err := util.RemoveAll(tmp, clonePath); err != nil {
 			logger.Warningf("couldn't remove %s", clonePath)
 		}
 	}()
 
-	start = time.Now()
-	root, err := RootCommit(repo, id.String())
+	start := time.Now()
+	var repo *git.Repository
+	err = run(ctx, library.PhaseClone, func(ctx context.Context) error {
+		var err error
+		repo, err = CloneRepository(
+			ctx, tmp, clonePath, endpoint, id.String(), auth,
+		)
+
+		return err
+	})
 	if err != nil {
+		return "", err
+	}
+
+	observe(library.PhaseClone, time.Since(start))
+	elapsed := time.Since(start).String()
+	logger.With(log.Fields{"elapsed": elapsed}).Debugf("cloned")
+
+	start = time.Now()
+	var root *object.Commit
+	err = run(ctx, library.PhaseRootCommit, func(ctx context.Context) error {
+		var err error
+		root, err = RootCommit(ctx, repo, id.String())
 		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
+	observe(library.PhaseRootCommit, time.Since(start))
 	elapsed = time.Since(start).String()
 	logger.With(log.Fields{
 		"elapsed": elapsed,
 		"root":    root.Hash.String(),
 	}).Debugf("root commit found")
 
-	start = time.Now()
 	locID := borges.LocationID(root.Hash.String())
-	r, err := PrepareRepository(
-		ctx, lib, locID, id, endpoint, tmp, clonePath,
-	)
-
+	unlock, err := lock(ctx, locID)
 	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	locked := time.Now()
+
+	start = time.Now()
+	var r borges.Repository
+	err = run(ctx, library.PhasePrepare, func(ctx context.Context) error {
+		var err error
+		r, err = PrepareRepository(
+			ctx, lib, locID, id, endpoint, tmp, clonePath,
+		)
+
 		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
+	// the transaction is rolled back if any phase fails, before the
+	// location is unlocked.
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+
+		if err := r.Close(); err != nil {
+			logger.Warningf("couldn't roll back location %s", locID)
+		}
+	}()
+
+	observe(library.PhasePrepare, time.Since(start))
 	elapsed = time.Since(start).String()
 	logger.With(log.Fields{
 		"elapsed": elapsed,
 	}).Debugf("rooted repository ready")
 
 	start = time.Now()
-	if err := FetchChanges(ctx, r, id.String(), token); err != nil {
-		return err
+	err = run(ctx, library.PhaseFetch, func(ctx context.Context) error {
+		return FetchChanges(ctx, r, id.String(), auth)
+	})
+	if err != nil {
+		return "", err
 	}
 
+	observe(library.PhaseFetch, time.Since(start))
 	elapsed = time.Since(start).String()
 	logger.With(log.Fields{"elapsed": elapsed}).Debugf("fetched")
 
+	// the commit isn't interrupted, so it can't be left writing the siva
+	// file once the location is unlocked.
 	start = time.Now()
-	if err := r.Commit(); err != nil {
-		return err
+	err = commit(ctx, locked, func() error {
+		committed = true
+		return r.Commit()
+	})
+	if err != nil {
+		return "", err
 	}
 
+	observe(library.PhaseCommit, time.Since(start))
 	elapsed = time.Since(start).String()
 	logger.With(log.Fields{"elapsed": elapsed}).Debugf("commited")
-	return nil
+	return locID, nil
 }
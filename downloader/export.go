@@ -0,0 +1,245 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// ErrEmptyRepository is returned when a repository to export has no
+// references.
+var ErrEmptyRepository = errors.NewKind("repository %s has no references")
+
+const (
+	bundleSignature = "# v2 git bundle\n"
+	packWindow      = 10
+)
+
+// ExportBare writes the repository with the given ID stored in the library as
+// a bare repository in the given billy.Filesystem. The references fetched from
+// the repository endpoint keep their original names and only the objects
+// reachable from them are written.
+func ExportBare(
+	ctx context.Context,
+	lib borges.Library,
+	id borges.RepositoryID,
+	fs billy.Filesystem,
+) error {
+	return export(ctx, lib, id, func(
+		src storer.EncodedObjectStorer,
+		refs []*plumbing.Reference,
+		head *plumbing.Reference,
+		objects []plumbing.Hash,
+	) error {
+		sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+		if _, err := git.Init(sto, nil); err != nil {
+			return err
+		}
+
+		w, err := sto.PackfileWriter()
+		if err != nil {
+			return err
+		}
+
+		_, err = packfile.NewEncoder(w, src, false).
+			Encode(objects, packWindow)
+		if cErr := w.Close(); err == nil {
+			err = cErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range refs {
+			if err := sto.SetReference(ref); err != nil {
+				return err
+			}
+		}
+
+		if head == nil {
+			return nil
+		}
+
+		return sto.SetReference(bareHead(refs, head))
+	})
+}
+
+// ExportBundle writes the repository with the given ID stored in the library
+// as a git bundle. The references fetched from the repository endpoint keep
+// their original names and only the objects reachable from them are
+// written.
+func ExportBundle(
+	ctx context.Context,
+	lib borges.Library,
+	id borges.RepositoryID,
+	w io.Writer,
+) error {
+	return export(ctx, lib, id, func(
+		src storer.EncodedObjectStorer,
+		refs []*plumbing.Reference,
+		head *plumbing.Reference,
+		objects []plumbing.Hash,
+	) error {
+		if _, err := io.WriteString(w, bundleSignature); err != nil {
+			return err
+		}
+
+		if head != nil {
+			refs = append([]*plumbing.Reference{head}, refs...)
+		}
+
+		for _, ref := range refs {
+			_, err := fmt.Fprintf(w, "%s %s\n", ref.Hash(), ref.Name())
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+
+		_, err := packfile.NewEncoder(w, src, false).
+			Encode(objects, packWindow)
+		return err
+	})
+}
+
+type exportFn func(
+	src storer.EncodedObjectStorer,
+	refs []*plumbing.Reference,
+	head *plumbing.Reference,
+	objects []plumbing.Hash,
+) error
+
+// export finds the location of the repository with the given ID and calls fn
+// with its references, its HEAD if any, and the objects reachable from them.
+func export(
+	ctx context.Context,
+	lib borges.Library,
+	id borges.RepositoryID,
+	fn exportFn,
+) error {
+	ok, _, locID, err := lib.Has(id)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return borges.ErrRepositoryNotExists.New(id)
+	}
+
+	loc, err := lib.Location(locID)
+	if err != nil {
+		return err
+	}
+
+	repo, err := loc.Get(id, borges.ReadOnlyMode)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	sto := siva.NewRootedStorage(repo.R().Storer, id.String())
+	refs, head, err := exportRefs(sto)
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		return ErrEmptyRepository.New(id)
+	}
+
+	tips := make([]plumbing.Hash, 0, len(refs))
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	objects, err := revlist.Objects(sto, tips, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fn(sto, refs, head, objects)
+}
+
+// exportRefs returns the references of the repository sorted by name, and
+// its HEAD if it was fetched.
+func exportRefs(
+	sto storer.ReferenceStorer,
+) ([]*plumbing.Reference, *plumbing.Reference, error) {
+	iter, err := sto.IterReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && !ref.Hash().IsZero() {
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name() < refs[j].Name()
+	})
+
+	head, err := sto.Reference(plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound ||
+		(err == nil && head.Hash().IsZero()) {
+		return refs, nil, nil
+	}
+
+	return refs, head, err
+}
+
+// bareHead returns the HEAD of a bare repository. The fetched HEAD is just a
+// hash, it points to master or the first branch at the same commit.
+func bareHead(
+	refs []*plumbing.Reference,
+	head *plumbing.Reference,
+) *plumbing.Reference {
+	var branch *plumbing.Reference
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() || ref.Hash() != head.Hash() {
+			continue
+		}
+
+		if branch == nil || ref.Name() == plumbing.Master {
+			branch = ref
+		}
+	}
+
+	if branch == nil {
+		return head
+	}
+
+	return plumbing.NewSymbolicReference(plumbing.HEAD, branch.Name())
+}
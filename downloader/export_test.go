@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitcollector/downloader/testhelper"
+	"github.com/src-d/gitcollector/library"
+
+	"github.com/src-d/go-borges"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestExport(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+
+	var require = require.New(t)
+
+	h, close, err := testhelper.NewHelper()
+	require.NoError(err)
+	defer close()
+
+	git := func(args ...string) string {
+		args = append([]string{
+			"-c", "user.name=foo", "-c", "user.email=foo@bar",
+			"-c", "init.defaultBranch=master",
+		}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	// the fork shares the location with the repository.
+	root := filepath.Join(h.Dir, "remote")
+	src := filepath.Join(root, "org", "repo.git")
+	fork := filepath.Join(root, "other", "repo.git")
+	git("init", src)
+	git("-C", src, "commit", "--allow-empty", "-m", "first")
+	git("-C", src, "tag", "-a", "v1", "-m", "v1")
+	git("-C", src, "checkout", "-b", "dev")
+	git("-C", src, "commit", "--allow-empty", "-m", "second")
+	git("-C", src, "checkout", "master")
+	git("clone", "--bare", src, fork)
+	forkCommit := git("-C", fork, "commit-tree", "-m", "fork", "-p",
+		"master", "master^{tree}")
+	git("-C", fork, "update-ref", "refs/heads/fork", forkCommit)
+
+	hostKey, _ := newTestKey(t, "")
+	clientKey, clientKeyFile := newTestKey(t, h.Dir)
+	server, err := testhelper.NewSSHServer(root, hostKey, clientKey.PublicKey())
+	require.NoError(err)
+	defer server.Close()
+
+	var locs []borges.LocationID
+	for _, path := range []string{"org/repo.git", "other/repo.git"} {
+		job := &library.Job{
+			Lib:       h.Lib,
+			Type:      library.JobDownload,
+			TempFS:    h.TempFS,
+			AuthToken: func(string) string { return "" },
+			Auth: library.NewSSHAuthMethodFn(&library.SSHAuthOpts{
+				KeyFile:               clientKeyFile,
+				InsecureIgnoreHostKey: true,
+			}),
+			Logger: log.New(nil),
+		}
+		endpoint := fmt.Sprintf("ssh://git@%s/%s", server.Addr, path)
+		job.SetEndpoints([]string{endpoint})
+		require.NoError(Download(context.Background(), job))
+
+		id, err := library.NewRepositoryID(endpoint)
+		require.NoError(err)
+		_, _, loc, err := h.Lib.Has(id)
+		require.NoError(err)
+		locs = append(locs, loc)
+	}
+	require.Equal(locs[0], locs[1])
+
+	id, err := library.NewRepositoryID(
+		fmt.Sprintf("ssh://git@%s/org/repo.git", server.Addr),
+	)
+	require.NoError(err)
+
+	ctx := context.Background()
+	bare := filepath.Join(h.Dir, "export.git")
+	require.NoError(ExportBare(ctx, h.Lib, id, osfs.New(bare)))
+	git("-C", bare, "fsck", "--strict")
+	require.Equal("refs/heads/master", git("-C", bare, "symbolic-ref", "HEAD"))
+	require.Equal(
+		git("-C", src, "for-each-ref"),
+		git("-C", bare, "for-each-ref"),
+	)
+
+	var buf bytes.Buffer
+	require.NoError(ExportBundle(ctx, h.Lib, id, &buf))
+	bundle := filepath.Join(h.Dir, "export.bundle")
+	require.NoError(ioutil.WriteFile(bundle, buf.Bytes(), 0644))
+	git("bundle", "verify", bundle)
+
+	clone := filepath.Join(h.Dir, "clone")
+	git("clone", bundle, clone)
+	require.Equal(git("-C", src, "rev-parse", "dev"),
+		git("-C", clone, "rev-parse", "origin/dev"))
+	require.Equal("v1", git("-C", clone, "tag"))
+
+	// the objects of the fork aren't exported.
+	out, err := exec.Command(
+		"git", "-C", clone, "cat-file", "-e", forkCommit,
+	).CombinedOutput()
+	require.Error(err, string(out))
+
+	err = ExportBundle(ctx, h.Lib, "github.com/foo/bar", &buf)
+	require.True(borges.ErrRepositoryNotExists.Is(err))
+}
@@ -17,7 +17,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 )
 
@@ -39,7 +39,8 @@ const (
 func CloneRepository(
 	ctx context.Context,
 	fs billy.Filesystem,
-	path, endpoint, id, token string,
+	path, endpoint, id string,
+	auth transport.AuthMethod,
 ) (*git.Repository, error) {
 	repoFS, err := fs.Chroot(path)
 	if err != nil {
@@ -65,13 +66,7 @@ func CloneRepository(
 		},
 		Force: true,
 		Tags:  git.NoTags,
-	}
-
-	if token != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: "gitcollector",
-			Password: token,
-		}
+		Auth:  auth,
 	}
 
 	if err = remote.FetchContext(ctx, opts); err != nil {
@@ -115,8 +110,9 @@ func createRemote(r *git.Repository, id, endpoint string) (*git.Remote, error) {
 
 // RootCommit traverse the commit history for the given remote following the
 // first parent of each commit. The root commit found (commit with no parents)
-// is returned.
+// is returned. The traversal stops once the given context is done.
 func RootCommit(
+	ctx context.Context,
 	repo *git.Repository,
 	remote string,
 ) (*object.Commit, error) {
@@ -127,6 +123,10 @@ func RootCommit(
 
 	current := start
 	for len(current.ParentHashes) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		current, err = current.Parent(0)
 		if err != nil {
 			return nil, err
@@ -171,7 +171,8 @@ func resolveCommit(
 // PrepareRepository returns a borges.Repository ready to fetch changes.
 // It creates a rooted repository copying the cloned repository in tmp to
 // the siva file the library uses at the location with the given location ID,
-// creating this location if not exists.
+// creating this location if not exists. The location must be locked while
+// it's written if other processes write the library, see library.FileLocker.
 func PrepareRepository(
 	ctx context.Context,
 	lib *siva.Library,
@@ -226,17 +227,11 @@ func FetchChanges(
 	ctx context.Context,
 	r borges.Repository,
 	remote string,
-	token string,
+	auth transport.AuthMethod,
 ) error {
 	opts := &git.FetchOptions{
 		RemoteName: remote,
-	}
-
-	if token != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: "gitcollector",
-			Password: token,
-		}
+		Auth:       auth,
 	}
 
 	if err := r.R().FetchContext(
@@ -266,6 +261,12 @@ func createRootedRepo(
 
 	err = recursiveCopy(ctx, "/", repo.FS(), clonedPath, clonedFS)
 	if err != nil {
+		// the transaction is rolled back, otherwise the location would
+		// remain locked.
+		if cErr := repo.Close(); cErr != nil {
+			err = fmt.Errorf("%s: %s", err.Error(), cErr.Error())
+		}
+
 		repo = nil
 	}
 
@@ -0,0 +1,452 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitcollector/library"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+var (
+	// ErrNotImportJob is returned when a not import job is found.
+	ErrNotImportJob = errors.NewKind("not import job")
+
+	// ErrNoRepositoryID is returned when the ID of a repository to import
+	// isn't given and it can't be derived from the repository.
+	ErrNoRepositoryID = errors.NewKind(
+		"no repository ID given for %s and it has no origin remote")
+
+	// ErrWrongBundle is returned when a git bundle can't be imported.
+	ErrWrongBundle = errors.NewKind("wrong git bundle %s: %s")
+)
+
+const (
+	bundleExtension = ".bundle"
+	originRemote    = "origin"
+)
+
+// Import is a library.JobFn function to import a local bare repository or git
+// bundle into a borges.Library without fetching it from the network. Its
+// endpoint is the path to the repository or bundle and it's stored with the
+// RepositoryID of the job. If it isn't set it's derived from the origin
+// remote of the repository.
+func Import(ctx context.Context, job *library.Job) error {
+	logger := job.Logger.New(log.Fields{"job": "import", "id": job.ID})
+	if job.Type != library.JobImport ||
+		len(job.Endpoints()) != 1 ||
+		job.Lib == nil ||
+		job.TempFS == nil {
+		err := ErrNotImportJob.New()
+		logger.Errorf(err, "wrong job")
+		return err
+	}
+
+	lib, ok := (job.Lib).(*siva.Library)
+	if !ok {
+		err := library.ErrNotSivaLibrary.New()
+		logger.Errorf(err, "wrong library")
+		return err
+	}
+
+	source := job.Endpoints()[0]
+	logger = logger.New(log.Fields{"source": source})
+
+	logger.Infof("started")
+	start := time.Now()
+	locID, err := importRepository(ctx, logger, lib, job, source)
+	if err != nil {
+		logger.Errorf(err, "failed")
+		return err
+	}
+
+	if loc, err := lib.Location(locID); err == nil {
+		job.UpdateCatalog(loc)
+	}
+
+	elapsed := time.Since(start).String()
+	logger.With(log.Fields{"elapsed": elapsed}).Infof("finished")
+	return nil
+}
+
+func importRepository(
+	ctx context.Context,
+	logger log.Logger,
+	lib *siva.Library,
+	job *library.Job,
+	source string,
+) (borges.LocationID, error) {
+	tmp := job.TempFS
+	clonePath := filepath.Join(
+		cloneRootPath,
+		fmt.Sprintf("import_%d", time.Now().UnixNano()),
+	)
+
+	repoFS, err := tmp.Chroot(clonePath)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err := util.RemoveAll(tmp, clonePath); err != nil {
+			logger.Warningf("couldn't remove %s", clonePath)
+		}
+	}()
+
+	sto := filesystem.NewStorage(repoFS, cache.NewObjectLRUDefault())
+	repo, err := git.Init(sto, nil)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	var (
+		refs     []*plumbing.Reference
+		endpoint string
+	)
+
+	if strings.HasSuffix(source, bundleExtension) {
+		refs, err = readBundle(ctx, sto, source)
+	} else {
+		refs, endpoint, err = readBareRepository(ctx, sto, source)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	id := job.RepositoryID
+	if id == "" {
+		if endpoint == "" {
+			return "", ErrNoRepositoryID.New(source)
+		}
+
+		if id, err = library.NewRepositoryID(endpoint); err != nil {
+			return "", err
+		}
+	}
+
+	if endpoint == "" {
+		endpoint = "https://" + id.String()
+	}
+
+	logger = logger.New(log.Fields{"repository": id, "url": endpoint})
+	ok, _, _, err := lib.Has(id)
+	if err != nil {
+		return "", err
+	}
+
+	if ok {
+		return "", ErrRepoAlreadyExists.New(id)
+	}
+
+	if err := setRemoteRefs(sto, id, refs); err != nil {
+		return "", err
+	}
+
+	job.ObservePhase(library.PhaseClone, time.Since(start))
+	logger.With(log.Fields{
+		"elapsed": time.Since(start).String(),
+	}).Debugf("read")
+
+	root, err := RootCommit(ctx, repo, id.String())
+	if err != nil {
+		return "", err
+	}
+
+	locID := borges.LocationID(root.Hash.String())
+	logger.With(log.Fields{"root": locID}).Debugf("root commit found")
+
+	unlock, err := job.LockLocation(ctx, locID)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	locked := time.Now()
+
+	start = time.Now()
+	r, err := PrepareRepository(ctx, lib, locID, id, endpoint, tmp, clonePath)
+	if err != nil {
+		return "", err
+	}
+
+	// the repository was copied if the location is new, otherwise the
+	// objects missing in the location are added.
+	if err := transferObjects(ctx, r.R().Storer, sto, refs); err != nil {
+		r.Close()
+		return "", err
+	}
+
+	if err := setRemoteRefs(r.R().Storer, id, refs); err != nil {
+		r.Close()
+		return "", err
+	}
+
+	job.ObservePhase(library.PhaseFetch, time.Since(start))
+
+	start = time.Now()
+	committed := false
+	err = job.RunCommit(ctx, locked, func() error {
+		committed = true
+		return r.Commit()
+	})
+	if err != nil {
+		if !committed {
+			r.Close()
+		}
+
+		return "", err
+	}
+
+	job.ObservePhase(library.PhaseCommit, time.Since(start))
+	return locID, nil
+}
+
+// readBareRepository writes the objects reachable from the references of the
+// bare repository at the given path into the storer. It returns those
+// references, with HEAD resolved, and the endpoint of its origin remote if
+// any.
+func readBareRepository(
+	ctx context.Context,
+	sto *filesystem.Storage,
+	path string,
+) ([]*plumbing.Reference, string, error) {
+	fs := osfs.New(path)
+	if _, err := fs.Stat("objects"); err != nil {
+		// not a bare repository.
+		if fs, err = fs.Chroot(git.GitDirName); err != nil {
+			return nil, "", err
+		}
+	}
+
+	src := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	iter, err := src.IterReferences()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference &&
+			!ref.Name().IsRemote() {
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	head, err := storer.ResolveReference(src, plumbing.HEAD)
+	if err == nil {
+		refs = append(refs, plumbing.NewHashReference(
+			plumbing.HEAD, head.Hash(),
+		))
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, "", err
+	}
+
+	if err := transferObjects(ctx, sto, src, refs); err != nil {
+		return nil, "", err
+	}
+
+	var endpoint string
+	cfg, err := src.Config()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if origin, ok := cfg.Remotes[originRemote]; ok && len(origin.URLs) > 0 {
+		endpoint = origin.URLs[0]
+	}
+
+	return refs, endpoint, nil
+}
+
+// readBundle writes the packfile of the git bundle at the given path into the
+// storer and returns its references.
+func readBundle(
+	ctx context.Context,
+	sto *filesystem.Storage,
+	path string,
+) ([]*plumbing.Reference, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(newContextReader(ctx, f))
+	line, err := r.ReadString('\n')
+	if err != nil || line != bundleSignature {
+		return nil, ErrWrongBundle.New(path, "unsupported signature")
+	}
+
+	var refs []*plumbing.Reference
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, ErrWrongBundle.New(path, err.Error())
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "-") {
+			return nil, ErrWrongBundle.New(
+				path, "bundles with prerequisites aren't supported",
+			)
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[0]) != 40 {
+			return nil, ErrWrongBundle.New(path, "wrong reference")
+		}
+
+		refs = append(refs, plumbing.NewHashReference(
+			plumbing.ReferenceName(fields[1]),
+			plumbing.NewHash(fields[0]),
+		))
+	}
+
+	w, err := sto.PackfileWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, ErrWrongBundle.New(path, err.Error())
+	}
+
+	return refs, nil
+}
+
+// transferObjects writes into dst the objects reachable from the given
+// references found in src and missing in dst.
+func transferObjects(
+	ctx context.Context,
+	dst storer.Storer,
+	src storer.EncodedObjectStorer,
+	refs []*plumbing.Reference,
+) error {
+	tips := make([]plumbing.Hash, 0, len(refs))
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash())
+	}
+
+	objects, err := revlist.Objects(src, tips, nil)
+	if err != nil {
+		return err
+	}
+
+	var missing []plumbing.Hash
+	for _, h := range objects {
+		if dst.HasEncodedObject(h) != nil {
+			missing = append(missing, h)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pw, ok := dst.(storer.PackfileWriter)
+	if !ok {
+		for _, h := range missing {
+			obj, err := src.EncodedObject(plumbing.AnyObject, h)
+			if err != nil {
+				return err
+			}
+
+			if _, err := dst.SetEncodedObject(obj); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	w, err := pw.PackfileWriter()
+	if err != nil {
+		return err
+	}
+
+	_, err = packfile.NewEncoder(w, src, false).Encode(missing, packWindow)
+	if cErr := w.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+// setRemoteRefs stores the given references as the ones of the remote with
+// the given ID. A HEAD is set if they don't include one.
+func setRemoteRefs(
+	sto storer.ReferenceStorer,
+	id borges.RepositoryID,
+	refs []*plumbing.Reference,
+) error {
+	var head, branch *plumbing.Reference
+	for _, ref := range refs {
+		name := plumbing.NewRemoteHEADReferenceName(id.String())
+		switch {
+		case ref.Name() == plumbing.HEAD:
+			head = ref
+		case ref.Name().IsBranch():
+			if branch == nil || ref.Name() == plumbing.Master {
+				branch = ref
+			}
+
+			fallthrough
+		default:
+			name = plumbing.ReferenceName(fmt.Sprintf(
+				"refs/remotes/%s/%s", id,
+				strings.TrimPrefix(ref.Name().String(), "refs/"),
+			))
+		}
+
+		err := sto.SetReference(plumbing.NewHashReference(name, ref.Hash()))
+		if err != nil {
+			return err
+		}
+	}
+
+	if head != nil || branch == nil {
+		return nil
+	}
+
+	return sto.SetReference(plumbing.NewHashReference(
+		plumbing.NewRemoteHEADReferenceName(id.String()), branch.Hash(),
+	))
+}
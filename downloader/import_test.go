@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitcollector/downloader/testhelper"
+	"github.com/src-d/gitcollector/library"
+
+	"github.com/src-d/go-borges"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestImport(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+
+	var require = require.New(t)
+
+	h, close, err := testhelper.NewHelper()
+	require.NoError(err)
+	defer close()
+
+	git := func(args ...string) string {
+		args = append([]string{
+			"-c", "user.name=foo", "-c", "user.email=foo@bar",
+			"-c", "init.defaultBranch=master",
+		}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	src := filepath.Join(h.Dir, "src")
+	git("init", src)
+	git("-C", src, "commit", "--allow-empty", "-m", "first")
+	git("-C", src, "tag", "-a", "v1", "-m", "v1")
+	git("-C", src, "checkout", "-b", "dev")
+	git("-C", src, "commit", "--allow-empty", "-m", "second")
+	git("-C", src, "checkout", "master")
+
+	bare := filepath.Join(h.Dir, "repo.git")
+	git("clone", "--bare", src, bare)
+	git("-C", bare, "remote", "set-url", "origin",
+		"https://github.com/org/repo.git")
+
+	// the fork shares the root commit with the repository.
+	git("-C", src, "commit", "--allow-empty", "-m", "fork")
+	bundle := filepath.Join(h.Dir, "fork.bundle")
+	git("-C", src, "bundle", "create", bundle, "--all")
+
+	newJob := func(source string, id borges.RepositoryID) *library.Job {
+		job := &library.Job{
+			Lib:          h.Lib,
+			Type:         library.JobImport,
+			TempFS:       h.TempFS,
+			RepositoryID: id,
+			Logger:       log.New(nil),
+		}
+		job.SetEndpoints([]string{source})
+		return job
+	}
+
+	ctx := context.Background()
+	require.NoError(Import(ctx, newJob(bare, "")))
+	err = Import(ctx, newJob(bare, ""))
+	require.True(ErrRepoAlreadyExists.Is(err))
+
+	err = Import(ctx, newJob(bundle, ""))
+	require.True(ErrNoRepositoryID.Is(err))
+	require.NoError(Import(ctx, newJob(bundle, "github.com/other/repo")))
+
+	var locs []borges.LocationID
+	for _, id := range []borges.RepositoryID{
+		"github.com/org/repo", "github.com/other/repo",
+	} {
+		ok, _, loc, err := h.Lib.Has(id)
+		require.NoError(err)
+		require.True(ok, id.String())
+		locs = append(locs, loc)
+	}
+	require.Equal(locs[0], locs[1])
+
+	for _, test := range []struct {
+		id     borges.RepositoryID
+		source string
+	}{
+		{"github.com/org/repo", bare},
+		{"github.com/other/repo", src},
+	} {
+		dst := filepath.Join(h.Dir, "export", test.id.String())
+		require.NoError(ExportBare(ctx, h.Lib, test.id, osfs.New(dst)))
+		git("-C", dst, "fsck", "--strict")
+		require.Equal(
+			git("-C", test.source, "for-each-ref", "refs/heads", "refs/tags"),
+			git("-C", dst, "for-each-ref"),
+		)
+	}
+}
@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/downloader/testhelper"
+	"github.com/src-d/gitcollector/library"
+
+	"github.com/src-d/go-borges"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestDownloadSSH(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+
+	var require = require.New(t)
+
+	h, close, err := testhelper.NewHelper()
+	require.NoError(err)
+	defer close()
+
+	root := filepath.Join(h.Dir, "remote")
+	repoPath := filepath.Join(root, "org", "repo.git")
+	for _, args := range [][]string{
+		{"init", repoPath},
+		{"-C", repoPath, "-c", "user.name=foo", "-c", "user.email=foo@bar",
+			"commit", "--allow-empty", "-m", "first"},
+	} {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(err, string(out))
+	}
+
+	hostKey, _ := newTestKey(t, "")
+	clientKey, clientKeyFile := newTestKey(t, h.Dir)
+	_, otherKeyFile := newTestKey(t, h.Dir)
+
+	server, err := testhelper.NewSSHServer(root, hostKey, clientKey.PublicKey())
+	require.NoError(err)
+	defer server.Close()
+
+	knownHosts := filepath.Join(h.Dir, "known_hosts")
+	line := knownhosts.Line(
+		[]string{knownhosts.Normalize(server.Addr)},
+		server.HostKey,
+	)
+	require.NoError(ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0644))
+
+	emptyKnownHosts := filepath.Join(h.Dir, "empty_known_hosts")
+	require.NoError(ioutil.WriteFile(emptyKnownHosts, nil, 0644))
+
+	endpoint := fmt.Sprintf("ssh://git@%s/org/repo.git", server.Addr)
+	newJob := func(opts *library.SSHAuthOpts) *library.Job {
+		job := &library.Job{
+			Lib:       h.Lib,
+			Type:      library.JobDownload,
+			TempFS:    h.TempFS,
+			AuthToken: func(string) string { return "" },
+			Auth:      library.NewSSHAuthMethodFn(opts),
+			Logger:    log.New(nil),
+		}
+		job.SetEndpoints([]string{endpoint})
+		return job
+	}
+
+	ctx := context.Background()
+	err = Download(ctx, newJob(&library.SSHAuthOpts{
+		KeyFile:    clientKeyFile,
+		KnownHosts: []string{emptyKnownHosts},
+	}))
+	require.Error(err)
+	require.Contains(err.Error(), "key is unknown")
+
+	err = Download(ctx, newJob(&library.SSHAuthOpts{
+		KeyFile:    otherKeyFile,
+		KnownHosts: []string{knownHosts},
+	}))
+	require.Error(err)
+	require.Contains(err.Error(), "unable to authenticate")
+
+	id, err := library.NewRepositoryID(endpoint)
+	require.NoError(err)
+
+	// the timed out phases leave neither temporal clones nor locked
+	// locations behind.
+	for _, phase := range []library.Phase{
+		library.PhaseClone, library.PhasePrepare,
+	} {
+		job := newJob(&library.SSHAuthOpts{
+			KeyFile:    clientKeyFile,
+			KnownHosts: []string{knownHosts},
+		})
+		job.Timeouts = map[library.Phase]time.Duration{phase: time.Nanosecond}
+		err = Download(ctx, job)
+		require.True(gitcollector.ErrTimeout.Is(err), "%s: %v", phase, err)
+
+		clones, err := h.TempFS.ReadDir(filepath.Dir(
+			filepath.Join(cloneRootPath, id.String()),
+		))
+		require.NoError(err)
+		require.Len(clones, 0)
+	}
+
+	job := newJob(&library.SSHAuthOpts{
+		KeyFile:    clientKeyFile,
+		KnownHosts: []string{knownHosts},
+	})
+	require.NoError(Download(ctx, job))
+
+	ok, _, _, err := h.Lib.Has(borges.RepositoryID(id))
+	require.NoError(err)
+	require.True(ok)
+}
+
+// newTestKey generates a new private key, it's also written to a file in the
+// given directory if it isn't empty.
+func newTestKey(t *testing.T, dir string) (ssh.Signer, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	if dir == "" {
+		return signer, ""
+	}
+
+	f, err := ioutil.TempFile(dir, "id_rsa")
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, pem.Encode(f, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+
+	return signer, f.Name()
+}
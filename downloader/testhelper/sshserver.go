@@ -0,0 +1,150 @@
+package testhelper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHServer is an in-process ssh server serving with git-upload-pack the git
+// repositories found in a directory.
+type SSHServer struct {
+	// Addr is the address the server is listening on.
+	Addr string
+	// HostKey is the public key of the server.
+	HostKey ssh.PublicKey
+
+	root     string
+	config   *ssh.ServerConfig
+	listener net.Listener
+}
+
+// NewSSHServer starts a new SSHServer serving the repositories in root. Only
+// the clients authenticated with the given key are accepted.
+func NewSSHServer(
+	root string,
+	hostKey ssh.Signer,
+	authorized ssh.PublicKey,
+) (*SSHServer, error) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(
+			_ ssh.ConnMetadata,
+			key ssh.PublicKey,
+		) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, fmt.Errorf("unauthorized key")
+			}
+
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SSHServer{
+		Addr:     lis.Addr().String(),
+		HostKey:  hostKey.PublicKey(),
+		root:     root,
+		config:   config,
+		listener: lis,
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server.
+func (s *SSHServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *SSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *SSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unknown channel")
+			continue
+		}
+
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+
+		go s.session(ch, reqs)
+	}
+}
+
+func (s *SSHServer) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			req.Reply(false, nil)
+			continue
+		}
+
+		command := string(req.Payload[4:])
+		parts := strings.SplitN(command, " ", 2)
+		if len(parts) != 2 || parts[0] != "git-upload-pack" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		req.Reply(true, nil)
+		path := filepath.Join(s.root, strings.Trim(parts[1], "'"))
+		status := s.uploadPack(ch, path)
+
+		exit := make([]byte, 4)
+		binary.BigEndian.PutUint32(exit, status)
+		ch.SendRequest("exit-status", false, exit)
+		return
+	}
+}
+
+func (s *SSHServer) uploadPack(ch ssh.Channel, path string) uint32 {
+	cmd := exec.Command("git", "upload-pack", path)
+	cmd.Stdout = ch
+	cmd.Stderr = ch.Stderr()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 1
+	}
+
+	go func() {
+		io.Copy(stdin, ch)
+		stdin.Close()
+	}()
+
+	if err := cmd.Run(); err != nil {
+		return 1
+	}
+
+	return 0
+}
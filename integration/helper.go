@@ -53,10 +53,13 @@ func NewHelper(orgs string) (*helper, error) {
 	return &helper{
 		address: addr,
 		cmd: subcmd.DownloadCmd{
-			Orgs:    orgs,
-			LibPath: lib,
-			TmpPath: tmp,
-			Token:   os.Getenv("GITHUB_TOKEN"),
+			CommonOpts: subcmd.CommonOpts{
+				LibPath:   lib,
+				LibBucket: 2,
+				TmpPath:   tmp,
+				Token:     os.Getenv("GITHUB_TOKEN"),
+			},
+			Orgs: orgs,
 		},
 		cli: cli,
 		closers: []func(){
@@ -20,8 +20,13 @@ type MetricsCollector interface {
 	Success(Job)
 	// Faile register metrics about a failed processed Job.
 	Fail(Job)
+	// Timeout register metrics about a Job which processing timed out.
+	Timeout(Job)
 	// Discover register metrics about a discovered Job.
 	Discover(Job)
+	// Filter register metrics about a discovered Job which was filtered
+	// out.
+	Filter(Job)
 }
 
 // Provider interface represents a service to generate new Jobs.
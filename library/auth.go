@@ -0,0 +1,191 @@
+package library
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+var (
+	// ErrSSHAuth is returned when the authentication for a ssh endpoint
+	// can't be built.
+	ErrSSHAuth = errors.NewKind("couldn't build ssh authentication")
+)
+
+// AuthMethodFn retrieves the transport.AuthMethod, if any, to use with the
+// given endpoint.
+type AuthMethodFn func(endpoint string) (transport.AuthMethod, error)
+
+const (
+	protocolSSH = "ssh"
+
+	tokenUser = "gitcollector"
+	sshUser   = "git"
+)
+
+// AuthMethod returns the transport.AuthMethod to use with the given endpoint.
+// The Auth function of the Job is used if it's set and it has a method for
+// the endpoint, otherwise the token from AuthToken is used as basic
+// authentication.
+func (j *Job) AuthMethod(endpoint string) (transport.AuthMethod, error) {
+	if j.Auth != nil {
+		auth, err := j.Auth(endpoint)
+		if err != nil || auth != nil {
+			return auth, err
+		}
+	}
+
+	if j.AuthToken == nil {
+		return nil, nil
+	}
+
+	return TokenAuth(endpoint, j.AuthToken(endpoint)), nil
+}
+
+// TokenAuth returns a basic authentication using the given token. It returns
+// nil for an empty token or any endpoint but http and https ones, e.g. ssh or
+// git ones.
+func TokenAuth(endpoint, token string) transport.AuthMethod {
+	if token == "" {
+		return nil
+	}
+
+	ep, err := transport.NewEndpoint(endpoint)
+	if err != nil || (ep.Protocol != "http" && ep.Protocol != "https") {
+		return nil
+	}
+
+	return &http.BasicAuth{
+		Username: tokenUser,
+		Password: token,
+	}
+}
+
+// ChainAuth builds an AuthMethodFn which returns the first method found by
+// the given functions.
+func ChainAuth(fns ...AuthMethodFn) AuthMethodFn {
+	return func(endpoint string) (transport.AuthMethod, error) {
+		for _, fn := range fns {
+			auth, err := fn(endpoint)
+			if err != nil || auth != nil {
+				return auth, err
+			}
+		}
+
+		return nil, nil
+	}
+}
+
+// WithAuth wraps the given JobFn to process the jobs using the given
+// AuthMethodFn.
+func WithAuth(fn JobFn, auth AuthMethodFn) JobFn {
+	return func(ctx context.Context, job *Job) error {
+		job.Auth = auth
+		return fn(ctx, job)
+	}
+}
+
+// SSHAuthOpts represents the configuration to authenticate on ssh endpoints.
+type SSHAuthOpts struct {
+	// User is used when the endpoint has no user, by default "git".
+	User string
+	// KeyFile is the path to a private key. If it's not set the keys are
+	// retrieved from the ssh-agent.
+	KeyFile string
+	// KeyPassword decrypts the KeyFile.
+	KeyPassword string
+	// KnownHosts are the files to verify the host keys. By default the
+	// files in SSH_KNOWN_HOSTS or ~/.ssh/known_hosts are used.
+	KnownHosts []string
+	// InsecureIgnoreHostKey disables the host keys verification.
+	InsecureIgnoreHostKey bool
+}
+
+// NewSSHAuthMethodFn builds an AuthMethodFn which authenticates on ssh
+// endpoints with the given options. It returns nil for any other endpoint.
+func NewSSHAuthMethodFn(opts *SSHAuthOpts) AuthMethodFn {
+	if opts == nil {
+		opts = &SSHAuthOpts{}
+	}
+
+	if opts.User == "" {
+		opts.User = sshUser
+	}
+
+	var (
+		once     sync.Once
+		signer   ssh.Signer
+		callback ssh.HostKeyCallback
+		initErr  error
+	)
+
+	init := func() {
+		if opts.KeyFile != "" {
+			signer, initErr = readSigner(
+				opts.KeyFile, opts.KeyPassword,
+			)
+			if initErr != nil {
+				return
+			}
+		}
+
+		if opts.InsecureIgnoreHostKey {
+			callback = ssh.InsecureIgnoreHostKey()
+			return
+		}
+
+		callback, initErr = gitssh.NewKnownHostsCallback(
+			opts.KnownHosts...,
+		)
+	}
+
+	return func(endpoint string) (transport.AuthMethod, error) {
+		ep, err := transport.NewEndpoint(endpoint)
+		if err != nil || ep.Protocol != protocolSSH {
+			return nil, nil
+		}
+
+		once.Do(init)
+		if initErr != nil {
+			return nil, ErrSSHAuth.Wrap(initErr)
+		}
+
+		user := ep.User
+		if user == "" {
+			user = opts.User
+		}
+
+		if signer != nil {
+			auth := &gitssh.PublicKeys{User: user, Signer: signer}
+			auth.HostKeyCallback = callback
+			return auth, nil
+		}
+
+		auth, err := gitssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, ErrSSHAuth.Wrap(err)
+		}
+
+		auth.HostKeyCallback = callback
+		return auth, nil
+	}
+}
+
+func readSigner(path, password string) (ssh.Signer, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if password != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pem, []byte(password))
+	}
+
+	return ssh.ParsePrivateKey(pem)
+}
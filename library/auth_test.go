@@ -0,0 +1,50 @@
+package library
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+func TestJobAuthMethod(t *testing.T) {
+	var require = require.New(t)
+
+	job := &Job{
+		AuthToken: func(string) string { return "foo" },
+		Auth: NewSSHAuthMethodFn(&SSHAuthOpts{
+			KeyFile:               "/does/not/exist",
+			InsecureIgnoreHostKey: true,
+		}),
+	}
+
+	auth, err := job.AuthMethod("https://github.com/src-d/gitcollector")
+	require.NoError(err)
+	require.Equal(&http.BasicAuth{
+		Username: "gitcollector",
+		Password: "foo",
+	}, auth)
+
+	_, err = job.AuthMethod("git@github.com:src-d/gitcollector.git")
+	require.True(ErrSSHAuth.Is(err))
+
+	job.Auth = func(string) (transport.AuthMethod, error) {
+		return &gitssh.PublicKeys{User: "bar"}, nil
+	}
+
+	auth, err = job.AuthMethod("ssh://github.com/src-d/gitcollector.git")
+	require.NoError(err)
+	require.Equal("bar", auth.(*gitssh.PublicKeys).User)
+
+	job.Auth = nil
+	auth, err = job.AuthMethod("ssh://github.com/src-d/gitcollector.git")
+	require.NoError(err)
+	require.Nil(auth)
+
+	// the git transport doesn't support any authentication.
+	auth, err = job.AuthMethod("git://github.com/src-d/gitcollector.git")
+	require.NoError(err)
+	require.Nil(auth)
+}
@@ -0,0 +1,402 @@
+package library
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+var (
+	// ErrCatalogCorrupted is returned when the entries of a Catalog can't
+	// be read.
+	ErrCatalogCorrupted = errors.NewKind("catalog corrupted at line %d")
+)
+
+// CatalogRepository is a repository stored in a location.
+type CatalogRepository struct {
+	ID        borges.RepositoryID `json:"id"`
+	Endpoints []string            `json:"endpoints"`
+	Refs      int                 `json:"refs"`
+}
+
+// CatalogEntry describes the contents of a location.
+type CatalogEntry struct {
+	Location     borges.LocationID    `json:"location"`
+	Repositories []*CatalogRepository `json:"repositories"`
+	Size         int64                `json:"size"`
+	Time         time.Time            `json:"time"`
+}
+
+// NewCatalogEntry reads the CatalogEntry of the given location. The size is
+// the one of its siva file in the given billy.Filesystem, the bucket level
+// must be the one used by the library.
+func NewCatalogEntry(
+	loc borges.Location,
+	fs billy.Filesystem,
+	bucket int,
+) (*CatalogEntry, error) {
+	e := &CatalogEntry{Location: loc.ID(), Time: time.Now()}
+	info, err := fs.Stat(LocationPath(loc.ID(), bucket))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		e.Size = info.Size()
+	}
+
+	repo, err := loc.Get("", borges.ReadOnlyMode)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	remotes, err := repo.R().Remotes()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make(map[string]*CatalogRepository, len(remotes))
+	for _, remote := range remotes {
+		cfg := remote.Config()
+		r := &CatalogRepository{
+			ID:        borges.RepositoryID(cfg.Name),
+			Endpoints: cfg.URLs,
+		}
+
+		e.Repositories = append(e.Repositories, r)
+		prefixes["refs/remotes/"+cfg.Name+"/"] = r
+	}
+
+	sort.Slice(e.Repositories, func(i, j int) bool {
+		return e.Repositories[i].ID < e.Repositories[j].ID
+	})
+
+	refs, err := repo.R().References()
+	if err != nil {
+		return nil, err
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		for prefix, r := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				r.Refs++
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return e, err
+}
+
+// Catalog is an index of the contents of a library, so it can be listed
+// without opening every siva file. The entries are recorded as JSON lines in
+// a file, the last one recorded for a location replaces the previous ones.
+// Several processes can share the file: the writes and the compactions are
+// serialized with a lock file next to it, and a process reopens the file
+// before writing if it was compacted by another one.
+type Catalog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[borges.LocationID]*CatalogEntry
+	fs      billy.Filesystem
+	bucket  int
+}
+
+// OpenCatalog opens the Catalog at the given path, creating it if it doesn't
+// exist. The siva files of the library are in the given billy.Filesystem
+// with the given bucket level.
+func OpenCatalog(
+	path string,
+	fs billy.Filesystem,
+	bucket int,
+) (*Catalog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{
+		path:    path,
+		entries: make(map[borges.LocationID]*CatalogEntry),
+		fs:      fs,
+		bucket:  bucket,
+	}
+
+	unlock, err := lockCatalog(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := loadCatalog(path, c.entries); err != nil {
+		return nil, err
+	}
+
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// lockCatalog locks the Catalog at the given path, waiting while it's locked
+// by another process. The returned function unlocks it.
+func lockCatalog(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		ok, err := flock(f, false)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if ok {
+			// closing the file releases the lock.
+			return func() { f.Close() }, nil
+		}
+
+		time.Sleep(fileLockRetry)
+	}
+}
+
+// ReadCatalog returns the entries of the Catalog at the given path sorted by
+// location ID. Unlike OpenCatalog it doesn't modify the file, so it can be
+// used while the Catalog is being updated by another process.
+func ReadCatalog(path string) ([]*CatalogEntry, error) {
+	c := &Catalog{entries: make(map[borges.LocationID]*CatalogEntry)}
+	if err := loadCatalog(path, c.entries); err != nil {
+		return nil, err
+	}
+
+	return c.sorted(), nil
+}
+
+func loadCatalog(
+	path string,
+	entries map[borges.LocationID]*CatalogEntry,
+) error {
+	return readLog(path, func(line int, data []byte) error {
+		var e CatalogEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return ErrCatalogCorrupted.Wrap(err, line)
+		}
+
+		entries[e.Location] = &e
+		return nil
+	})
+}
+
+// compact rewrites the log keeping only the last entry of every location. The
+// Catalog must be locked.
+func (c *Catalog) compact() error {
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, e := range c.sorted() {
+		if err := writeRecord(w, e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	return c.reopen()
+}
+
+// reopen opens the file of the Catalog again, closing the previous one.
+func (c *Catalog) reopen() error {
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if c.file != nil {
+		c.file.Close()
+	}
+
+	c.file = f
+	return nil
+}
+
+// replaced reports whether the file of the Catalog was replaced by the
+// compaction of another process.
+func (c *Catalog) replaced() (bool, error) {
+	current, err := c.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(c.path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return !os.SameFile(current, info), nil
+}
+
+// Update records the current contents of the given location.
+func (c *Catalog) Update(loc borges.Location) error {
+	e, err := NewCatalogEntry(loc, c.fs, c.bucket)
+	if err != nil {
+		return err
+	}
+
+	return c.Put(e)
+}
+
+// Put records the given entry.
+func (c *Catalog) Put(e *CatalogEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := lockCatalog(c.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	replaced, err := c.replaced()
+	if err != nil {
+		return err
+	}
+
+	if replaced {
+		if err := c.reopen(); err != nil {
+			return err
+		}
+	}
+
+	if err := writeRecord(c.file, e); err != nil {
+		return err
+	}
+
+	c.entries[e.Location] = e
+	return nil
+}
+
+// Rebuild replaces the entries of the Catalog with the current contents of
+// every location of the given library, e.g. when the Catalog was enabled
+// after the library was written.
+func (c *Catalog) Rebuild(lib borges.Library) error {
+	locs, err := lib.Locations()
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[borges.LocationID]*CatalogEntry)
+	err = locs.ForEach(func(loc borges.Location) error {
+		e, err := NewCatalogEntry(loc, c.fs, c.bucket)
+		if err != nil {
+			return err
+		}
+
+		entries[e.Location] = e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := lockCatalog(c.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c.entries = entries
+	return c.compact()
+}
+
+// Entries returns the last entry recorded for every location sorted by
+// location ID.
+func (c *Catalog) Entries() []*CatalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sorted()
+}
+
+func (c *Catalog) sorted() []*CatalogEntry {
+	entries := make([]*CatalogEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Location < entries[j].Location
+	})
+
+	return entries
+}
+
+// Close closes the Catalog.
+func (c *Catalog) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.file.Close()
+}
+
+// WithCatalog wraps the given JobFn to keep the given Catalog updated with
+// the locations modified by the jobs.
+func WithCatalog(fn JobFn, c *Catalog) JobFn {
+	return func(ctx context.Context, job *Job) error {
+		job.Catalog = c
+		return fn(ctx, job)
+	}
+}
+
+// UpdateCatalog records the current contents of the given location in the
+// Catalog of the job, if any. A failure is logged but doesn't fail the job.
+func (j *Job) UpdateCatalog(loc borges.Location) {
+	if j.Catalog == nil {
+		return
+	}
+
+	if err := j.Catalog.Update(loc); err != nil {
+		j.Logger.Warningf("couldn't update catalog for location %s: %s",
+			loc.ID(), err.Error())
+	}
+}
@@ -0,0 +1,155 @@
+package library
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestCatalog(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-catalog")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fs := osfs.New(filepath.Join(dir, "lib"))
+	lib, err := siva.NewLibrary("test", fs, &siva.LibraryOptions{
+		Bucket:        2,
+		Transactional: true,
+	})
+	require.NoError(err)
+
+	loc, err := lib.AddLocation("foo")
+	require.NoError(err)
+
+	repo, err := loc.Init("github.com/src-d/foo")
+	require.NoError(err)
+
+	hash := plumbing.NewHash("a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2")
+	for _, name := range []string{"master", "dev"} {
+		require.NoError(repo.R().Storer.SetReference(
+			plumbing.NewHashReference(plumbing.ReferenceName(
+				"refs/remotes/github.com/src-d/foo/"+name,
+			), hash),
+		))
+	}
+	require.NoError(repo.Commit())
+
+	path := filepath.Join(dir, "state", "catalog")
+	c, err := OpenCatalog(path, fs, 2)
+	require.NoError(err)
+
+	require.NoError(c.Update(loc))
+	require.NoError(c.Put(&CatalogEntry{Location: "bar"}))
+	require.NoError(c.Close())
+
+	c, err = OpenCatalog(path, fs, 2)
+	require.NoError(err)
+	defer c.Close()
+
+	entries := c.Entries()
+	require.Len(entries, 2)
+	require.Equal(borges.LocationID("bar"), entries[0].Location)
+
+	e := entries[1]
+	require.Equal(borges.LocationID("foo"), e.Location)
+	require.True(e.Size > 0)
+	require.Len(e.Repositories, 1)
+	require.Equal(
+		borges.RepositoryID("github.com/src-d/foo"),
+		e.Repositories[0].ID,
+	)
+	require.Equal(2, e.Repositories[0].Refs)
+
+	// the last entry of a location replaces the previous ones.
+	require.NoError(c.Put(&CatalogEntry{Location: "foo", Size: 1}))
+	entries, err = ReadCatalog(path)
+	require.NoError(err)
+	require.Len(entries, 2)
+	require.Equal(int64(1), entries[1].Size)
+
+	require.NoError(ioutil.WriteFile(path, []byte("{}\nfoo\n"), 0644))
+	_, err = ReadCatalog(path)
+	require.True(ErrCatalogCorrupted.Is(err))
+}
+
+func TestCatalogShared(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-catalog")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "catalog")
+	a, err := OpenCatalog(path, nil, 2)
+	require.NoError(err)
+	defer a.Close()
+
+	require.NoError(a.Put(&CatalogEntry{Location: "foo"}))
+
+	// the compaction of another process replaces the file, the entries
+	// written afterwards mustn't go to the old one.
+	b, err := OpenCatalog(path, nil, 2)
+	require.NoError(err)
+	defer b.Close()
+
+	require.NoError(a.Put(&CatalogEntry{Location: "bar"}))
+	require.NoError(b.Put(&CatalogEntry{Location: "baz"}))
+
+	entries, err := ReadCatalog(path)
+	require.NoError(err)
+
+	var locs []borges.LocationID
+	for _, e := range entries {
+		locs = append(locs, e.Location)
+	}
+
+	require.Equal([]borges.LocationID{"bar", "baz", "foo"}, locs)
+}
+
+func TestCatalogRebuild(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-catalog")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fs := osfs.New(filepath.Join(dir, "lib"))
+	lib, err := siva.NewLibrary("test", fs, &siva.LibraryOptions{
+		Bucket:        2,
+		Transactional: true,
+	})
+	require.NoError(err)
+
+	loc, err := lib.AddLocation("foo")
+	require.NoError(err)
+	repo, err := loc.Init("github.com/src-d/foo")
+	require.NoError(err)
+	require.NoError(repo.Commit())
+
+	path := filepath.Join(dir, "catalog")
+	c, err := OpenCatalog(path, fs, 2)
+	require.NoError(err)
+	defer c.Close()
+
+	require.NoError(c.Put(&CatalogEntry{Location: "bar"}))
+	require.NoError(c.Rebuild(lib))
+
+	entries, err := ReadCatalog(path)
+	require.NoError(err)
+	require.Len(entries, 1)
+	require.Equal(borges.LocationID("foo"), entries[0].Location)
+	require.Equal(
+		borges.RepositoryID("github.com/src-d/foo"),
+		entries[0].Repositories[0].ID,
+	)
+	require.Len(c.Entries(), 1)
+}
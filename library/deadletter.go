@@ -0,0 +1,176 @@
+package library
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+var (
+	// ErrDeadLetterCorrupted is returned when the entries of a
+	// DeadLetterStore can't be read.
+	ErrDeadLetterCorrupted = errors.NewKind(
+		"dead letter corrupted at line %d")
+)
+
+// DeadLetterEntry is a Job which failed permanently.
+type DeadLetterEntry struct {
+	Type      JobType           `json:"type"`
+	Endpoints []string          `json:"endpoints,omitempty"`
+	Location  borges.LocationID `json:"location,omitempty"`
+	Attempts  int               `json:"attempts"`
+	Error     string            `json:"error"`
+	Time      time.Time         `json:"time"`
+}
+
+// Job builds a new Job to process the entry again.
+func (e *DeadLetterEntry) Job() *Job {
+	job := &Job{
+		Type:       e.Type,
+		LocationID: e.Location,
+	}
+
+	job.SetEndpoints(e.Endpoints)
+	return job
+}
+
+// DeadLetterOpts represents configuration options for a DeadLetterStore.
+type DeadLetterOpts struct {
+	// Ignore filters out the errors which shouldn't be recorded.
+	Ignore func(error) bool
+	Logger log.Logger
+}
+
+// DeadLetterStore is a gitcollector.DeadLetter which records the failed jobs
+// as JSON lines in a file.
+type DeadLetterStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	opts *DeadLetterOpts
+}
+
+var _ gitcollector.DeadLetter = (*DeadLetterStore)(nil)
+
+// OpenDeadLetterStore opens the DeadLetterStore at the given path, creating
+// it if it doesn't exist.
+func OpenDeadLetterStore(
+	path string,
+	opts *DeadLetterOpts,
+) (*DeadLetterStore, error) {
+	if opts == nil {
+		opts = &DeadLetterOpts{}
+	}
+
+	if opts.Ignore == nil {
+		opts.Ignore = func(error) bool { return false }
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterStore{
+		path: path,
+		file: f,
+		opts: opts,
+	}, nil
+}
+
+// Put implements the gitcollector.DeadLetter interface.
+func (s *DeadLetterStore) Put(j gitcollector.Job, attempts int, err error) {
+	if err == nil || s.opts.Ignore(err) {
+		return
+	}
+
+	job, ok := j.(*Job)
+	if !ok {
+		s.opts.Logger.Warningf("wrong job found: %T", j)
+		return
+	}
+
+	e := &DeadLetterEntry{
+		Type:      job.Type,
+		Endpoints: job.Endpoints(),
+		Location:  job.LocationID,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		Time:      time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(e); err != nil {
+		s.opts.Logger.Errorf(err, "couldn't record failed job")
+	}
+}
+
+func (s *DeadLetterStore) write(e *DeadLetterEntry) error {
+	if err := writeRecord(s.file, e); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// Entries returns all the recorded entries.
+func (s *DeadLetterStore) Entries() ([]*DeadLetterEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*DeadLetterEntry
+	err := readLog(s.path, func(line int, data []byte) error {
+		var e DeadLetterEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return ErrDeadLetterCorrupted.Wrap(err, line)
+		}
+
+		entries = append(entries, &e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// Reset removes all the recorded entries but the given ones.
+func (s *DeadLetterStore) Reset(keep ...*DeadLetterEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+
+	for _, e := range keep {
+		if err := writeRecord(s.file, e); err != nil {
+			return err
+		}
+	}
+
+	return s.file.Sync()
+}
+
+// Close closes the DeadLetterStore.
+func (s *DeadLetterStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
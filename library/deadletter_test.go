@@ -0,0 +1,62 @@
+package library
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterStore(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-deadletter")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	errIgnored := fmt.Errorf("ignored")
+	path := filepath.Join(dir, "failed", "dead-letter.log")
+	s, err := OpenDeadLetterStore(path, &DeadLetterOpts{
+		Ignore: func(err error) bool { return err == errIgnored },
+	})
+	require.NoError(err)
+
+	download := &Job{Type: JobDownload}
+	download.SetEndpoints([]string{"git://foo/bar"})
+	s.Put(download, 3, fmt.Errorf("timeout"))
+	s.Put(&Job{Type: JobUpdate, LocationID: "baz"}, 1, fmt.Errorf("auth"))
+	s.Put(download, 1, errIgnored)
+	require.NoError(s.Close())
+
+	s, err = OpenDeadLetterStore(path, nil)
+	require.NoError(err)
+	defer s.Close()
+
+	entries, err := s.Entries()
+	require.NoError(err)
+	require.Len(entries, 2)
+
+	require.Equal(3, entries[0].Attempts)
+	require.Equal("timeout", entries[0].Error)
+	job := entries[0].Job()
+	require.Equal(JobDownload, int(job.Type))
+	require.Equal([]string{"git://foo/bar"}, job.Endpoints())
+
+	job = entries[1].Job()
+	require.Equal(JobUpdate, int(job.Type))
+	require.Equal("baz", string(job.LocationID))
+
+	require.NoError(s.Reset(entries[1]))
+	entries, err = s.Entries()
+	require.NoError(err)
+	require.Len(entries, 1)
+	require.Equal("auth", entries[0].Error)
+
+	require.NoError(s.Reset())
+	entries, err = s.Entries()
+	require.NoError(err)
+	require.Len(entries, 0)
+}
@@ -0,0 +1,446 @@
+package library
+
+import (
+	"context"
+	"sync"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrFairQueueClosed is returned when a FairQueue is used after Close.
+	ErrFairQueueClosed = errors.NewKind("fair queue is closed")
+)
+
+// FairQueueOpts represents configuration options for a FairQueue.
+type FairQueueOpts struct {
+	// Key returns the key the jobs are grouped by to share the workers
+	// between them. By default it's the organization of the first
+	// endpoint of the job.
+	Key func(*Job) string
+	// Weights are the shares of the workers of every key, the keys
+	// without a weight have a share of 1.
+	Weights map[string]int
+	// TypeWeights are the shares of the workers of every JobType when
+	// jobs of several types are waiting, the types without a weight have
+	// a share of 1.
+	TypeWeights map[JobType]int
+	// Capacity is the maximum number of jobs held by the queue, the
+	// consumed channels aren't read while it's full.
+	Capacity int
+}
+
+const fairCapacity = 10000
+
+// FairQueue is an in-memory queue of Jobs which takes the jobs with the
+// highest Priority first. The jobs with the same priority are taken in turns
+// from every type and key, e.g. the organization, proportionally to their
+// weights, so a big organization doesn't starve the rest.
+//
+// The jobs are ordered when they leave the FairQueue, so the
+// gitcollector.WorkerPool scheduling its jobs should have a small
+// SchedulerCapacity.
+type FairQueue struct {
+	mu     sync.Mutex
+	levels map[int]*fairLevel
+	len    int
+	inputs int
+	notify chan struct{}
+	space  chan struct{}
+	done   chan struct{}
+	closed bool
+	opts   *FairQueueOpts
+}
+
+// fairLevel holds the jobs with the same priority by type.
+type fairLevel struct {
+	len    int
+	types  *stride
+	queues map[JobType]*fairTypeQueue
+}
+
+// fairTypeQueue holds the jobs with the same priority and type by key.
+type fairTypeQueue struct {
+	len  int
+	keys *stride
+	jobs map[string][]*Job
+}
+
+// NewFairQueue builds a new empty FairQueue.
+func NewFairQueue(opts *FairQueueOpts) *FairQueue {
+	if opts == nil {
+		opts = &FairQueueOpts{}
+	}
+
+	if opts.Key == nil {
+		opts.Key = orgKey
+	}
+
+	if opts.Capacity <= 0 {
+		opts.Capacity = fairCapacity
+	}
+
+	return &FairQueue{
+		levels: make(map[int]*fairLevel),
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		opts:   opts,
+	}
+}
+
+func orgKey(job *Job) string {
+	endpoints := job.Endpoints()
+	if len(endpoints) == 0 {
+		return ""
+	}
+
+	if _, err := NewRepositoryID(endpoints[0]); err != nil {
+		return ""
+	}
+
+	return GetOrgFromEndpoint(endpoints[0])
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Push adds the given Job to the FairQueue. It doesn't wait for the queue to
+// have free capacity.
+func (q *FairQueue) Push(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrFairQueueClosed.New()
+	}
+
+	level, ok := q.levels[job.Priority]
+	if !ok {
+		level = &fairLevel{
+			types:  newStride(),
+			queues: make(map[JobType]*fairTypeQueue),
+		}
+
+		q.levels[job.Priority] = level
+	}
+
+	tq, ok := level.queues[job.Type]
+	if !ok {
+		tq = &fairTypeQueue{
+			keys: newStride(),
+			jobs: make(map[string][]*Job),
+		}
+
+		level.queues[job.Type] = tq
+	}
+
+	if tq.len == 0 {
+		level.types.activate(job.Type)
+	}
+
+	key := q.opts.Key(job)
+	if len(tq.jobs[key]) == 0 {
+		tq.keys.activate(key)
+	}
+
+	tq.jobs[key] = append(tq.jobs[key], job)
+	tq.len++
+	level.len++
+	q.len++
+	wake(q.notify)
+	return nil
+}
+
+// Pop returns the next Job. If there are no jobs it waits for new ones until
+// the context is done, returning a gitcollector.ErrNewJobsNotFound. Once
+// there are no jobs and all the consumed channels are closed it returns a
+// gitcollector.ErrJobSource.
+func (q *FairQueue) Pop(ctx context.Context) (*Job, error) {
+	for {
+		job, err := q.pop()
+		if err != nil || job != nil {
+			return job, err
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, gitcollector.ErrNewJobsNotFound.New()
+		}
+	}
+}
+
+func (q *FairQueue) pop() (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, gitcollector.ErrJobSource.New()
+	}
+
+	if q.len == 0 {
+		if q.inputs <= 0 {
+			return nil, gitcollector.ErrJobSource.New()
+		}
+
+		return nil, nil
+	}
+
+	var (
+		priority int
+		level    *fairLevel
+	)
+
+	for p, l := range q.levels {
+		if level == nil || p > priority {
+			priority, level = p, l
+		}
+	}
+
+	t := level.types.next(
+		func(k interface{}) bool {
+			return level.queues[k.(JobType)].len > 0
+		},
+		func(k interface{}) int {
+			return q.opts.TypeWeights[k.(JobType)]
+		},
+	).(JobType)
+
+	tq := level.queues[t]
+	key := tq.keys.next(
+		func(k interface{}) bool {
+			return len(tq.jobs[k.(string)]) > 0
+		},
+		func(k interface{}) int {
+			return q.opts.Weights[k.(string)]
+		},
+	).(string)
+
+	jobs := tq.jobs[key]
+	job := jobs[0]
+	jobs[0] = nil
+	if len(jobs) == 1 {
+		delete(tq.jobs, key)
+	} else {
+		tq.jobs[key] = jobs[1:]
+	}
+
+	tq.len--
+	level.len--
+	if level.len == 0 {
+		delete(q.levels, priority)
+	}
+
+	q.len--
+	wake(q.space)
+	return job, nil
+}
+
+// Len returns the number of jobs in the FairQueue.
+func (q *FairQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.len
+}
+
+func (q *FairQueue) full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.len >= q.opts.Capacity
+}
+
+// Consume adds in background all the Jobs received from the given channel
+// until it's closed, it stops reading from it while the FairQueue is full.
+// The FairQueue won't return a gitcollector.ErrJobSource while there are
+// channels being consumed.
+func (q *FairQueue) Consume(jobs <-chan gitcollector.Job, logger log.Logger) {
+	q.mu.Lock()
+	q.inputs++
+	q.mu.Unlock()
+
+	go func() {
+		defer func() {
+			q.mu.Lock()
+			q.inputs--
+			q.mu.Unlock()
+			wake(q.notify)
+		}()
+
+		for {
+			for q.full() {
+				select {
+				case <-q.space:
+				case <-q.done:
+				}
+			}
+
+			j, ok := <-jobs
+			if !ok {
+				return
+			}
+
+			job, ok := j.(*Job)
+			if !ok {
+				logger.Warningf("wrong job found: %T", j)
+				continue
+			}
+
+			err := q.Push(job)
+			if err != nil && !ErrFairQueueClosed.Is(err) {
+				logger.Errorf(err, "couldn't enqueue job")
+			}
+		}
+	}()
+}
+
+// Close closes the FairQueue discarding its jobs.
+func (q *FairQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	q.closed = true
+	q.levels = nil
+	q.len = 0
+	close(q.done)
+	wake(q.notify)
+	return nil
+}
+
+// stride shares turns between keys proportionally to their weights: the
+// active key with the lowest pass takes the turn and its pass advances
+// inversely to its weight. A key becoming active starts at the pass of the
+// last turn, so it can't claim the turns it missed while idle.
+type stride struct {
+	vtime  float64
+	seq    int
+	passes map[interface{}]*strideEntry
+}
+
+type strideEntry struct {
+	pass float64
+	seq  int
+}
+
+func newStride() *stride {
+	return &stride{passes: make(map[interface{}]*strideEntry)}
+}
+
+func (s *stride) activate(key interface{}) {
+	e, ok := s.passes[key]
+	if !ok {
+		s.seq++
+		s.passes[key] = &strideEntry{pass: s.vtime, seq: s.seq}
+		return
+	}
+
+	if e.pass < s.vtime {
+		e.pass = s.vtime
+	}
+}
+
+// next returns the key taking the turn among the active ones, it must be at
+// least one.
+func (s *stride) next(
+	active func(interface{}) bool,
+	weight func(interface{}) int,
+) interface{} {
+	var (
+		key  interface{}
+		best *strideEntry
+	)
+
+	for k, e := range s.passes {
+		if !active(k) {
+			if e.pass <= s.vtime {
+				delete(s.passes, k)
+			}
+
+			continue
+		}
+
+		if best == nil || e.pass < best.pass ||
+			(e.pass == best.pass && e.seq < best.seq) {
+			key, best = k, e
+		}
+	}
+
+	w := weight(key)
+	if w <= 0 {
+		w = 1
+	}
+
+	s.vtime = best.pass
+	best.pass += 1 / float64(w)
+	return key
+}
+
+// NewFairJobScheduleFn builds a new gitcollector.ScheduleFn that schedules
+// the download and update jobs from the given FairQueue. The jobs received
+// from the download and update channels are added to the FairQueue before
+// being scheduled.
+func NewFairJobScheduleFn(
+	q *FairQueue,
+	lib borges.Library,
+	download, update chan gitcollector.Job,
+	downloadFn, updateFn JobFn,
+	updateOnDownload bool,
+	authTokens map[string]string,
+	jobLogger log.Logger,
+	temp billy.Filesystem,
+) gitcollector.JobScheduleFn {
+	setupJob := NewJobSetupFn(
+		lib,
+		downloadFn, updateFn,
+		updateOnDownload,
+		authTokens,
+		jobLogger,
+		temp,
+	)
+
+	for _, ch := range []chan gitcollector.Job{download, update} {
+		if ch != nil {
+			q.Consume(ch, jobLogger)
+		}
+	}
+
+	return func(ctx context.Context) (gitcollector.Job, error) {
+		job, err := q.Pop(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.ID == "" {
+			id, err := uuid.NewRandom()
+			if err != nil {
+				return nil, errNotJobID.Wrap(err)
+			}
+
+			job.ID = id.String()
+		}
+
+		if err := setupJob(job); err != nil {
+			jobLogger.With(log.Fields{"id": job.ID}).
+				Errorf(err, "couldn't set up job")
+			return nil, gitcollector.ErrNewJobsNotFound.New()
+		}
+
+		return job, nil
+	}
+}
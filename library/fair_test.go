@@ -0,0 +1,99 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestFairQueue(t *testing.T) {
+	var require = require.New(t)
+
+	q := NewFairQueue(&FairQueueOpts{
+		Weights:     map[string]int{"big": 2},
+		TypeWeights: map[JobType]int{JobDownload: 3},
+	})
+
+	push := func(typ JobType, org string, n, priority int) {
+		for i := 0; i < n; i++ {
+			job := &Job{Type: typ, Priority: priority}
+			job.SetEndpoints([]string{
+				fmt.Sprintf("https://github.com/%s/repo%d", org, i),
+			})
+			require.NoError(q.Push(job))
+		}
+	}
+
+	pop := func(n int) map[string]int {
+		orgs := make(map[string]int)
+		for i := 0; i < n; i++ {
+			job, err := q.Pop(context.Background())
+			require.NoError(err)
+			orgs[GetOrgFromEndpoint(job.Endpoints()[0])]++
+		}
+
+		return orgs
+	}
+
+	push(JobDownload, "big", 100, 0)
+	push(JobDownload, "foo", 10, 0)
+	push(JobDownload, "bar", 10, 0)
+	require.Equal(120, q.Len())
+	require.Equal(map[string]int{"big": 8, "foo": 4, "bar": 4}, pop(16))
+
+	// the jobs with higher priority go first.
+	push(JobDownload, "urgent", 2, 1)
+	require.Equal(map[string]int{"urgent": 2}, pop(2))
+
+	push(JobUpdate, "updated", 10, 0)
+	orgs := pop(8)
+	require.Equal(2, orgs["updated"])
+	require.Equal(6, orgs["big"]+orgs["foo"]+orgs["bar"])
+
+	require.NoError(q.Close())
+	_, err := q.Pop(context.Background())
+	require.True(gitcollector.ErrJobSource.Is(err))
+	require.True(ErrFairQueueClosed.Is(q.Push(&Job{})))
+}
+
+func TestFairQueueConsume(t *testing.T) {
+	var require = require.New(t)
+
+	q := NewFairQueue(&FairQueueOpts{Capacity: 2})
+	jobs := make(chan gitcollector.Job, 5)
+	for i := 0; i < 5; i++ {
+		job := &Job{Type: JobDownload}
+		job.SetEndpoints([]string{
+			fmt.Sprintf("https://github.com/org/repo%d", i),
+		})
+		jobs <- job
+	}
+
+	q.Consume(jobs, log.New(nil))
+
+	// the queue stops consuming once it's full.
+	require.Eventually(func() bool {
+		return q.Len() == 2 && len(jobs) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		_, err := q.Pop(ctx)
+		require.NoError(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := q.Pop(ctx)
+	require.True(gitcollector.ErrNewJobsNotFound.Is(err))
+
+	close(jobs)
+	_, err = q.Pop(context.Background())
+	require.True(gitcollector.ErrJobSource.Is(err))
+}
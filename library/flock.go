@@ -0,0 +1,253 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-errors.v1"
+)
+
+var (
+	// ErrLocationLocked is returned when a location is locked by another
+	// process.
+	ErrLocationLocked = errors.NewKind(
+		"location %s is locked by another process (%s)")
+
+	// ErrLibraryLocked is returned when the whole library is locked by
+	// another process.
+	ErrLibraryLocked = errors.NewKind(
+		"library is locked by another process (%s)")
+)
+
+const (
+	// LockDir is the directory of a library where the FileLocker keeps
+	// the lock files.
+	LockDir = ".locks"
+
+	libraryLockFile = ".library.lock"
+	fileLockRetry   = 100 * time.Millisecond
+)
+
+// FileLockerOpts represents configuration options for a FileLocker.
+type FileLockerOpts struct {
+	// Timeout is the maximum time to wait for a lock held by another
+	// process. If it's zero the lock fails right away and if it's
+	// negative it waits until the context is done.
+	Timeout time.Duration
+	// Retry is the time to wait before trying again to take a lock held
+	// by another process, by default 100ms.
+	Retry time.Duration
+}
+
+// FileLocker locks the locations of a siva library with advisory file locks
+// so several processes can write the same library. The locations are locked
+// once per process, its jobs are coordinated by the library itself. Every
+// location lock holds a shared lock of the library, which is locked
+// exclusively by the operations changing its structure.
+type FileLocker struct {
+	dir  string
+	opts *FileLockerOpts
+	mu   sync.Mutex
+	held map[string]*fileLock
+}
+
+type fileLock struct {
+	f         *os.File
+	shared    bool
+	exclusive bool
+	n         int
+}
+
+// NewFileLocker builds a new FileLocker for the library at the given path.
+// The lock files are kept in its LockDir, they're never removed.
+func NewFileLocker(path string, opts *FileLockerOpts) (*FileLocker, error) {
+	if opts == nil {
+		opts = &FileLockerOpts{}
+	}
+
+	if opts.Retry <= 0 {
+		opts.Retry = fileLockRetry
+	}
+
+	dir := filepath.Join(path, LockDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileLocker{
+		dir:  dir,
+		opts: opts,
+		held: make(map[string]*fileLock),
+	}, nil
+}
+
+var _ LockFn = (*FileLocker)(nil).Lock
+
+// Lock locks the given location, waiting while it's locked by another process
+// up to the configured timeout. In that case the returned error is an
+// ErrLocationLocked. The returned function unlocks it.
+func (l *FileLocker) Lock(
+	ctx context.Context,
+	id borges.LocationID,
+) (func(), error) {
+	deadline := time.Now().Add(l.opts.Timeout)
+	unlockLib, err := l.lock(ctx, deadline, libraryLockFile, true, false,
+		libraryLocked,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := l.lock(ctx, deadline, string(id)+".lock", false, false,
+		func(path string) error {
+			return ErrLocationLocked.New(
+				id, lockHolder(path, "unknown holder"),
+			)
+		},
+	)
+	if err != nil {
+		unlockLib()
+		return nil, err
+	}
+
+	return func() {
+		unlock()
+		unlockLib()
+	}, nil
+}
+
+// LockLibrary locks the whole library to change its structure, waiting while
+// any of its locations is locked up to the configured timeout. In that case
+// the returned error is an ErrLibraryLocked. The returned function unlocks it.
+func (l *FileLocker) LockLibrary(ctx context.Context) (func(), error) {
+	deadline := time.Now().Add(l.opts.Timeout)
+	return l.lock(ctx, deadline, libraryLockFile, false, true,
+		libraryLocked,
+	)
+}
+
+func libraryLocked(path string) error {
+	// the library is locked exclusively by a process or shared by the
+	// ones writing its locations.
+	return ErrLibraryLocked.New(
+		lockHolder(path, "its locations are being written"),
+	)
+}
+
+// lock takes the lock of the given file, shared with other processes or not.
+// An exclusive lock isn't shared with the rest of the process either. The
+// locked function builds the error returned with the lock file once the
+// deadline is reached.
+func (l *FileLocker) lock(
+	ctx context.Context,
+	deadline time.Time,
+	name string,
+	shared, exclusive bool,
+	locked func(path string) error,
+) (func(), error) {
+	path := filepath.Join(l.dir, name)
+	for {
+		ok, err := l.tryLock(path, shared, exclusive)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return func() { l.unlock(path) }, nil
+		}
+
+		if l.opts.Timeout == 0 ||
+			(l.opts.Timeout > 0 && !time.Now().Before(deadline)) {
+			return nil, locked(path)
+		}
+
+		select {
+		case <-time.After(l.opts.Retry):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *FileLocker) tryLock(path string, shared, exclusive bool) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if h, ok := l.held[path]; ok {
+		if h.exclusive || exclusive {
+			return false, nil
+		}
+
+		h.n++
+		return true, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := flock(f, shared)
+	if err != nil || !ok {
+		f.Close()
+		return false, err
+	}
+
+	if !shared {
+		// the holder is recorded to report who has the lock.
+		host, _ := os.Hostname()
+		holder := fmt.Sprintf("pid %d on %s", os.Getpid(), host)
+		if err := f.Truncate(0); err == nil {
+			f.WriteAt([]byte(holder), 0)
+		}
+	}
+
+	l.held[path] = &fileLock{
+		f:         f,
+		shared:    shared,
+		exclusive: exclusive,
+		n:         1,
+	}
+	return true, nil
+}
+
+func (l *FileLocker) unlock(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h, ok := l.held[path]
+	if !ok {
+		return
+	}
+
+	if h.n--; h.n > 0 {
+		return
+	}
+
+	if !h.shared {
+		h.f.Truncate(0)
+	}
+
+	// closing the file releases the lock.
+	h.f.Close()
+	delete(l.held, path)
+}
+
+// lockHolder returns the holder recorded in the given lock file, or the
+// given default if there's none. The shared locks don't record it.
+func lockHolder(path, def string) string {
+	holder, err := ioutil.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(holder)) == 0 {
+		return def
+	}
+
+	return strings.TrimSpace(string(holder))
+}
@@ -0,0 +1,115 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-borges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLocker(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-flock")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// the file locks of different lockers conflict as if they were taken
+	// by different processes.
+	l1, err := NewFileLocker(dir, nil)
+	require.NoError(err)
+	l2, err := NewFileLocker(dir, nil)
+	require.NoError(err)
+
+	ctx := context.Background()
+	unlock1, err := l1.Lock(ctx, "foo")
+	require.NoError(err)
+	unlock2, err := l1.Lock(ctx, "foo")
+	require.NoError(err)
+
+	_, err = l2.Lock(ctx, "foo")
+	require.True(ErrLocationLocked.Is(err))
+	require.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid()))
+
+	unlockBar, err := l2.Lock(ctx, "bar")
+	require.NoError(err)
+
+	_, err = l2.LockLibrary(ctx)
+	require.True(ErrLibraryLocked.Is(err))
+
+	unlock1()
+	_, err = l2.Lock(ctx, "foo")
+	require.True(ErrLocationLocked.Is(err))
+
+	unlock2()
+	unlockFoo, err := l2.Lock(ctx, "foo")
+	require.NoError(err)
+	unlockFoo()
+	unlockBar()
+
+	unlockLib, err := l1.LockLibrary(ctx)
+	require.NoError(err)
+	_, err = l2.Lock(ctx, "foo")
+	require.True(ErrLibraryLocked.Is(err))
+	_, err = l1.Lock(ctx, "foo")
+	require.True(ErrLibraryLocked.Is(err))
+
+	// the lock is taken once it's released.
+	l3, err := NewFileLocker(dir, &FileLockerOpts{
+		Timeout: time.Second,
+		Retry:   10 * time.Millisecond,
+	})
+	require.NoError(err)
+
+	time.AfterFunc(50*time.Millisecond, unlockLib)
+	unlock, err := l3.Lock(ctx, "foo")
+	require.NoError(err)
+
+	// without timeout it waits until the context is done.
+	l2.opts.Timeout = -1
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = l2.Lock(cctx, "foo")
+	require.Equal(context.DeadlineExceeded, err)
+	unlock()
+}
+
+func TestWithLocker(t *testing.T) {
+	var require = require.New(t)
+
+	var locks []string
+	locker := func(name string) LockFn {
+		return func(
+			_ context.Context,
+			id borges.LocationID,
+		) (func(), error) {
+			locks = append(locks, name+" "+string(id))
+			return func() {
+				locks = append(locks, "un"+name+" "+string(id))
+			}, nil
+		}
+	}
+
+	fn := WithLocker(func(ctx context.Context, job *Job) error {
+		unlock, err := job.LockLocation(ctx, "foo")
+		if err != nil {
+			return err
+		}
+
+		unlock()
+		return nil
+	}, locker("file"))
+
+	job := &Job{Locker: locker("cluster")}
+	require.NoError(fn(context.Background(), job))
+	require.NoError(fn(context.Background(), job))
+	require.Equal([]string{
+		"cluster foo", "file foo", "unfile foo", "uncluster foo",
+		"cluster foo", "file foo", "unfile foo", "uncluster foo",
+	}, locks)
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package library
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock tries to lock the given file without blocking, it returns whether it
+// was locked.
+func flock(f *os.File, shared bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package library
+
+import "os"
+
+// flock doesn't lock the files on windows, so the processes writing the same
+// library aren't coordinated.
+func flock(f *os.File, shared bool) (bool, error) {
+	return true, nil
+}
@@ -3,6 +3,7 @@ package library
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/src-d/gitcollector"
 	"github.com/src-d/go-borges"
@@ -28,21 +29,65 @@ const (
 	JobDownload = 1 << iota
 	// JobUpdate represents an Update Job.
 	JobUpdate
+	// JobImport represents an Import Job of a local repository or bundle.
+	JobImport
+)
+
+// String returns the name of the JobType.
+func (t JobType) String() string {
+	switch t {
+	case JobDownload:
+		return "download"
+	case JobUpdate:
+		return "update"
+	case JobImport:
+		return "import"
+	default:
+		return "unknown"
+	}
+}
+
+// Phase represents a step in the processing of a Job.
+type Phase string
+
+const (
+	// PhaseClone is the clone of a repository to download.
+	PhaseClone Phase = "clone"
+	// PhaseRootCommit is the search of the root commit of a cloned
+	// repository.
+	PhaseRootCommit Phase = "root_commit"
+	// PhasePrepare is the copy of a cloned repository to its location.
+	PhasePrepare Phase = "prepare"
+	// PhaseFetch is the fetch of the changes of a repository.
+	PhaseFetch Phase = "fetch"
+	// PhaseCommit is the commit of the changes into the library.
+	PhaseCommit Phase = "commit"
 )
 
 // Job represents a gitcollector.Job to perform a task on a borges.Library.
 type Job struct {
-	mu          sync.Mutex
-	endpoints   []string
-	ID          string
-	Type        JobType
-	Lib         borges.Library
-	TempFS      billy.Filesystem
-	LocationID  borges.LocationID
-	AllowUpdate bool
-	AuthToken   AuthTokenFn
-	ProcessFn   JobFn
-	Logger      log.Logger
+	mu           sync.Mutex
+	endpoints    []string
+	elapsed      time.Duration
+	phases       map[Phase]time.Duration
+	ID           string
+	Type         JobType
+	Lib          borges.Library
+	TempFS       billy.Filesystem
+	LocationID   borges.LocationID
+	RepositoryID borges.RepositoryID
+	Priority     int
+	AllowUpdate  bool
+	AuthToken    AuthTokenFn
+	Auth         AuthMethodFn
+	Catalog      *Catalog
+	Timeouts     map[Phase]time.Duration
+	Locker       LockFn
+	ProcessFn    JobFn
+	Logger       log.Logger
+	// Queued is called once the Job is persisted by the Queue consuming
+	// it, e.g. to acknowledge it to its provider.
+	Queued func()
 }
 
 var _ gitcollector.Job = (*Job)(nil)
@@ -65,18 +110,67 @@ func (j *Job) Endpoints() []string {
 	return j.endpoints
 }
 
+// ObservePhase records the time spent by the Job in the given phase.
+func (j *Job) ObservePhase(phase Phase, elapsed time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.phases == nil {
+		j.phases = make(map[Phase]time.Duration)
+	}
+
+	j.phases[phase] += elapsed
+}
+
+// Phases returns the time spent in every phase by the last processing of the
+// Job.
+func (j *Job) Phases() map[Phase]time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	phases := make(map[Phase]time.Duration, len(j.phases))
+	for p, d := range j.phases {
+		phases[p] = d
+	}
+
+	return phases
+}
+
+// Elapsed returns the time spent by the last processing of the Job.
+func (j *Job) Elapsed() time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.elapsed
+}
+
 // Process implements the Job interface.
 func (j *Job) Process(ctx context.Context) error {
 	if j.ProcessFn == nil {
 		return ErrJobFnNotFound.New()
 	}
 
-	return j.ProcessFn(ctx, j)
+	j.mu.Lock()
+	j.phases = nil
+	j.mu.Unlock()
+
+	start := time.Now()
+	err := j.ProcessFn(ctx, j)
+
+	j.mu.Lock()
+	j.elapsed = time.Since(start)
+	j.mu.Unlock()
+
+	return err
 }
 
 // AuthTokenFn retrieve and authentication token if any for the given endpoint.
 type AuthTokenFn func(endpoint string) string
 
+// AnyOrg can be used as key in the authentication tokens map to set the
+// token used for the organizations without a specific one.
+const AnyOrg = "*"
+
 func getAuthTokenByOrg(tokens map[string]string) AuthTokenFn {
 	if tokens == nil {
 		tokens = map[string]string{}
@@ -84,7 +178,11 @@ func getAuthTokenByOrg(tokens map[string]string) AuthTokenFn {
 
 	return func(endpoint string) string {
 		org := GetOrgFromEndpoint(endpoint)
-		return tokens[org]
+		if token, ok := tokens[org]; ok {
+			return token
+		}
+
+		return tokens[AnyOrg]
 	}
 }
 
@@ -152,6 +250,33 @@ func NewUpdateJobScheduleFn(
 	}
 }
 
+// NewImportJobScheduleFn builds a new gitcollector.SchedulerFn that only
+// schedules import jobs.
+func NewImportJobScheduleFn(
+	lib borges.Library,
+	imports chan gitcollector.Job,
+	importFn JobFn,
+	jobLogger log.Logger,
+	temp billy.Filesystem,
+) gitcollector.JobScheduleFn {
+	return func(ctx context.Context) (gitcollector.Job, error) {
+		job, err := jobFrom(ctx, imports)
+		if err != nil {
+			if errClosedChan.Is(err) {
+				err = gitcollector.ErrJobSource.New()
+			}
+
+			return nil, err
+		}
+
+		job.Lib = lib
+		job.TempFS = temp
+		job.ProcessFn = importFn
+		job.Logger = jobLogger
+		return job, nil
+	}
+}
+
 // NewJobScheduleFn builds a new gitcollector.ScheduleFn that schedules download
 // and update jobs in different queues.
 func NewJobScheduleFn(
@@ -163,26 +288,14 @@ func NewJobScheduleFn(
 	jobLogger log.Logger,
 	temp billy.Filesystem,
 ) gitcollector.JobScheduleFn {
-	setupJob := func(job *Job) error {
-		if job.Lib == nil {
-			job.Lib = lib
-		}
-
-		switch job.Type {
-		case JobDownload:
-			job.TempFS = temp
-			job.AllowUpdate = updateOnDownload
-			job.ProcessFn = downloadFn
-		case JobUpdate:
-			job.ProcessFn = updateFn
-		default:
-			return errWrongJob.New()
-		}
-
-		job.AuthToken = getAuthTokenByOrg(authTokens)
-		job.Logger = jobLogger
-		return nil
-	}
+	setupJob := NewJobSetupFn(
+		lib,
+		downloadFn, updateFn,
+		updateOnDownload,
+		authTokens,
+		jobLogger,
+		temp,
+	)
 
 	return func(ctx context.Context) (gitcollector.Job, error) {
 		if download == nil && update == nil {
@@ -210,6 +323,8 @@ func NewJobScheduleFn(
 
 		if job != nil {
 			if err := setupJob(job); err != nil {
+				jobLogger.With(log.Fields{"id": job.ID}).
+					Errorf(err, "couldn't set up job")
 				return nil, gitcollector.
 					ErrNewJobsNotFound.New()
 			}
@@ -235,6 +350,8 @@ func NewJobScheduleFn(
 		}
 
 		if err := setupJob(job); err != nil {
+			jobLogger.With(log.Fields{"id": job.ID}).
+				Errorf(err, "couldn't set up job")
 			return nil, gitcollector.ErrNewJobsNotFound.New()
 		}
 
@@ -242,6 +359,38 @@ func NewJobScheduleFn(
 	}
 }
 
+// NewJobSetupFn returns a function to fill a Job with everything it needs to
+// be processed depending on its type.
+func NewJobSetupFn(
+	lib borges.Library,
+	downloadFn, updateFn JobFn,
+	updateOnDownload bool,
+	authTokens map[string]string,
+	jobLogger log.Logger,
+	temp billy.Filesystem,
+) func(*Job) error {
+	return func(job *Job) error {
+		if job.Lib == nil {
+			job.Lib = lib
+		}
+
+		switch job.Type {
+		case JobDownload:
+			job.TempFS = temp
+			job.AllowUpdate = updateOnDownload
+			job.ProcessFn = downloadFn
+		case JobUpdate:
+			job.ProcessFn = updateFn
+		default:
+			return errWrongJob.New()
+		}
+
+		job.AuthToken = getAuthTokenByOrg(authTokens)
+		job.Logger = jobLogger
+		return nil
+	}
+}
+
 func jobFrom(ctx context.Context, queue chan gitcollector.Job) (*Job, error) {
 	if queue == nil {
 		return nil, errClosedChan.New()
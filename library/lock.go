@@ -0,0 +1,68 @@
+package library
+
+import (
+	"context"
+
+	"github.com/src-d/go-borges"
+)
+
+// LockFn locks the given location to write it, waiting while it's locked by
+// others until the context is done. The returned function unlocks it.
+type LockFn func(context.Context, borges.LocationID) (func(), error)
+
+// LockLocation locks the given location with the Locker of the Job. If the
+// Job has no Locker the location isn't locked.
+func (j *Job) LockLocation(
+	ctx context.Context,
+	id borges.LocationID,
+) (func(), error) {
+	if j.Locker == nil {
+		return func() {}, nil
+	}
+
+	return j.Locker(ctx, id)
+}
+
+// WithLocker returns a JobFn which locks the locations written by the jobs
+// with the given LockFn, after the Locker they already have if any.
+func WithLocker(fn JobFn, lock LockFn) JobFn {
+	if lock == nil {
+		return fn
+	}
+
+	return func(ctx context.Context, job *Job) error {
+		prev := job.Locker
+		job.Locker = chainLocks(prev, lock)
+		defer func() { job.Locker = prev }()
+
+		return fn(ctx, job)
+	}
+}
+
+// chainLocks returns a LockFn taking both locks in order.
+func chainLocks(first, second LockFn) LockFn {
+	if first == nil {
+		return second
+	}
+
+	return func(
+		ctx context.Context,
+		id borges.LocationID,
+	) (func(), error) {
+		unlockFirst, err := first(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		unlockSecond, err := second(ctx, id)
+		if err != nil {
+			unlockFirst()
+			return nil, err
+		}
+
+		return func() {
+			unlockSecond()
+			unlockFirst()
+		}, nil
+	}
+}
@@ -0,0 +1,689 @@
+package library
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrQueueClosed is returned when a Queue is used after Close.
+	ErrQueueClosed = errors.NewKind("queue is closed")
+
+	// ErrQueueCorrupted is returned when the log of a Queue can't be read.
+	ErrQueueCorrupted = errors.NewKind("queue log corrupted at line %d")
+)
+
+// JobState is the state of a Job in a Queue.
+type JobState string
+
+const (
+	// JobEnqueued is the state of a Job waiting to be processed.
+	JobEnqueued JobState = "enqueued"
+	// JobLeased is the state of a Job being processed.
+	JobLeased JobState = "leased"
+	// JobDone is the state of a Job successfully processed.
+	JobDone JobState = "done"
+	// JobFailed is the state of a Job which processing failed.
+	JobFailed JobState = "failed"
+)
+
+// QueueOpts represents configuration options for a Queue.
+type QueueOpts struct {
+	// LeaseTimeout is the time a leased job can be processing before
+	// being delivered again. Zero means leased jobs are only delivered
+	// again when the Queue is reopened.
+	LeaseTimeout time.Duration
+	// CompactThreshold is the number of finished jobs recorded in the log
+	// which triggers its compaction.
+	CompactThreshold int
+}
+
+// Queue is a durable queue of Jobs. Every state change of the jobs is
+// recorded on an append-only log in a directory, so the pending and leased
+// jobs survive crashes and restarts and are delivered again when the Queue
+// is reopened.
+type Queue struct {
+	mu       sync.Mutex
+	dir      string
+	file     *os.File
+	entries  map[string]*queueEntry
+	order    []string
+	pending  []string
+	finished int
+	inputs   int
+	notify   chan struct{}
+	closed   bool
+	opts     *QueueOpts
+}
+
+type queueEntry struct {
+	ID        string            `json:"id"`
+	State     JobState          `json:"state"`
+	Type      JobType           `json:"type,omitempty"`
+	Endpoints []string          `json:"endpoints,omitempty"`
+	Location  borges.LocationID `json:"location,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+const (
+	queueLogFile     = "queue.log"
+	compactThreshold = 10000
+)
+
+// OpenQueue opens the Queue persisted in the given directory, creating it if
+// it doesn't exist. The jobs leased when the Queue was closed are pending
+// again.
+func OpenQueue(dir string, opts *QueueOpts) (*Queue, error) {
+	if opts == nil {
+		opts = &QueueOpts{}
+	}
+
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = compactThreshold
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:     dir,
+		entries: make(map[string]*queueEntry),
+		notify:  make(chan struct{}, 1),
+		opts:    opts,
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range q.order {
+		e, ok := q.entries[id]
+		if !ok {
+			continue
+		}
+
+		if e.State == JobLeased {
+			// the process died while the job was being
+			// processed, it must be delivered again.
+			e.State = JobEnqueued
+		}
+
+		if e.State == JobEnqueued {
+			q.pending = append(q.pending, id)
+		}
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	return readLog(
+		filepath.Join(q.dir, queueLogFile),
+		func(line int, data []byte) error {
+			var e queueEntry
+			if err := json.Unmarshal(data, &e); err != nil {
+				return ErrQueueCorrupted.Wrap(err, line)
+			}
+
+			q.apply(&e)
+			return nil
+		},
+	)
+}
+
+// readLog calls fn with every line of the log in the given path. A missing
+// log is read as an empty one.
+func readLog(path string, fn func(line int, data []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	var (
+		r    = bufio.NewReader(f)
+		line int
+	)
+
+	for {
+		line++
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial last line means the process died while
+			// writing it, the record is lost.
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(line, data); err != nil {
+			return err
+		}
+	}
+}
+
+// apply updates the in-memory state of the queue with a log record.
+func (q *Queue) apply(e *queueEntry) {
+	switch e.State {
+	case JobEnqueued:
+		if _, ok := q.entries[e.ID]; !ok {
+			q.order = append(q.order, e.ID)
+		}
+
+		q.entries[e.ID] = e
+	case JobLeased, JobFailed:
+		if entry, ok := q.entries[e.ID]; ok {
+			entry.State = e.State
+			entry.Error = e.Error
+			entry.Time = e.Time
+		}
+	case JobDone:
+		delete(q.entries, e.ID)
+	}
+}
+
+// compact rewrites the log keeping only the jobs not done yet.
+func (q *Queue) compact() error {
+	path := filepath.Join(q.dir, queueLogFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	var (
+		w     = bufio.NewWriter(f)
+		order = make([]string, 0, len(q.entries))
+	)
+
+	for _, id := range q.order {
+		e, ok := q.entries[id]
+		if !ok {
+			continue
+		}
+
+		order = append(order, id)
+		records := []*queueEntry{e}
+		if e.State != JobEnqueued {
+			enqueued := *e
+			enqueued.State = JobEnqueued
+			enqueued.Error = ""
+			records = []*queueEntry{&enqueued, e}
+		}
+
+		for _, r := range records {
+			if err := writeRecord(w, r); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+
+	q.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	q.order = order
+	q.finished = 0
+	return nil
+}
+
+func writeRecord(w io.Writer, e interface{}) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (q *Queue) record(e *queueEntry) error {
+	if err := writeRecord(q.file, e); err != nil {
+		return err
+	}
+
+	return q.file.Sync()
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue persists the given Job as pending.
+func (q *Queue) Enqueue(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed.New()
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return errNotJobID.Wrap(err)
+	}
+
+	e := &queueEntry{
+		ID:        id.String(),
+		State:     JobEnqueued,
+		Type:      job.Type,
+		Endpoints: job.Endpoints(),
+		Location:  job.LocationID,
+		Time:      time.Now(),
+	}
+
+	if err := q.record(e); err != nil {
+		return err
+	}
+
+	q.apply(e)
+	q.pending = append(q.pending, e.ID)
+	q.signal()
+	return nil
+}
+
+// Lease returns the next pending Job and marks it as leased. If there are no
+// pending jobs it waits for new ones until the context is done, returning a
+// gitcollector.ErrNewJobsNotFound. Once there are no pending jobs and all the
+// consumed channels are closed it returns a gitcollector.ErrJobSource.
+func (q *Queue) Lease(ctx context.Context) (*Job, error) {
+	for {
+		job, err := q.lease()
+		if err != nil || job != nil {
+			return job, err
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, gitcollector.ErrNewJobsNotFound.New()
+		}
+	}
+}
+
+func (q *Queue) lease() (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, gitcollector.ErrJobSource.New()
+	}
+
+	q.expireLeases()
+	for len(q.pending) > 0 {
+		id := q.pending[0]
+		e, ok := q.entries[id]
+		if !ok || e.State != JobEnqueued {
+			q.pending = q.pending[1:]
+			continue
+		}
+
+		record := &queueEntry{ID: id, State: JobLeased, Time: time.Now()}
+		if err := q.record(record); err != nil {
+			return nil, err
+		}
+
+		q.pending = q.pending[1:]
+		q.apply(record)
+
+		job := &Job{
+			ID:         e.ID,
+			Type:       e.Type,
+			LocationID: e.Location,
+		}
+		job.SetEndpoints(e.Endpoints)
+		return job, nil
+	}
+
+	if q.inputs <= 0 {
+		return nil, gitcollector.ErrJobSource.New()
+	}
+
+	return nil, nil
+}
+
+// expireLeases puts back in the pending jobs the jobs leased for longer than
+// the LeaseTimeout.
+func (q *Queue) expireLeases() {
+	if q.opts.LeaseTimeout <= 0 {
+		return
+	}
+
+	var expired []string
+	for _, id := range q.order {
+		e, ok := q.entries[id]
+		if ok && e.State == JobLeased &&
+			time.Since(e.Time) > q.opts.LeaseTimeout {
+			e.State = JobEnqueued
+			expired = append(expired, id)
+		}
+	}
+
+	q.pending = append(expired, q.pending...)
+}
+
+// Release puts back as pending the leased job with the given ID, e.g. when
+// it has to be processed again by someone else.
+func (q *Queue) Release(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed.New()
+	}
+
+	e, ok := q.entries[id]
+	if !ok || e.State != JobLeased {
+		return nil
+	}
+
+	record := *e
+	record.State = JobEnqueued
+	record.Error = ""
+	record.Time = time.Now()
+	if err := q.record(&record); err != nil {
+		return err
+	}
+
+	q.apply(&record)
+	q.pending = append(q.pending, id)
+	q.signal()
+	return nil
+}
+
+// Done marks as successfully processed the job with the given ID.
+func (q *Queue) Done(id string) error {
+	return q.finish(&queueEntry{ID: id, State: JobDone})
+}
+
+// Fail marks as failed the job with the given ID. Failed jobs are not
+// delivered again.
+func (q *Queue) Fail(id string, cause error) error {
+	e := &queueEntry{ID: id, State: JobFailed}
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+
+	return q.finish(e)
+}
+
+func (q *Queue) finish(e *queueEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed.New()
+	}
+
+	if _, ok := q.entries[e.ID]; !ok {
+		return nil
+	}
+
+	e.Time = time.Now()
+	if err := q.record(e); err != nil {
+		return err
+	}
+
+	q.apply(e)
+	q.finished++
+	if q.finished >= q.opts.CompactThreshold {
+		return q.compact()
+	}
+
+	return nil
+}
+
+// Exhausted reports whether the Queue has no pending jobs and all the
+// consumed channels are closed, so no more jobs will be enqueued unless the
+// leased ones are released.
+func (q *Queue) Exhausted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inputs > 0 {
+		return false
+	}
+
+	for _, id := range q.pending {
+		if e, ok := q.entries[id]; ok && e.State == JobEnqueued {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Jobs returns the number of jobs in the given state.
+func (q *Queue) Jobs(state JobState) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	for _, e := range q.entries {
+		if e.State == state {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Consume enqueues in background all the Jobs received from the given
+// channel until it's closed. The Queue won't return a
+// gitcollector.ErrJobSource while there are channels being consumed.
+func (q *Queue) Consume(jobs <-chan gitcollector.Job, logger log.Logger) {
+	q.mu.Lock()
+	q.inputs++
+	q.mu.Unlock()
+
+	go func() {
+		defer func() {
+			q.mu.Lock()
+			q.inputs--
+			q.mu.Unlock()
+			q.signal()
+		}()
+
+		for j := range jobs {
+			job, ok := j.(*Job)
+			if !ok {
+				logger.Warningf("wrong job found: %T", j)
+				continue
+			}
+
+			if err := q.Enqueue(job); err != nil {
+				logger.Errorf(err, "couldn't enqueue job")
+				if job.Queued != nil {
+					// its provider mustn't go past it, it
+					// would be lost after a restart.
+					logger.With(log.Fields{
+						"endpoints": job.Endpoints(),
+					}).Warningf("job not acknowledged")
+				}
+
+				continue
+			}
+
+			if job.Queued != nil {
+				job.Queued()
+			}
+		}
+	}()
+}
+
+// Close closes the Queue.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	q.closed = true
+	q.signal()
+	return q.file.Close()
+}
+
+// NewQueueJobScheduleFn builds a new gitcollector.ScheduleFn that schedules
+// the download and update jobs from the given Queue. The jobs received from
+// the download and update channels are persisted on the Queue before being
+// scheduled, and every processed job is marked on the Queue as done or
+// failed. The jobs cancelled before finishing are released, and the ones
+// the worker pool will retry with the given options are kept leased, so only
+// the jobs failing permanently are marked as failed.
+func NewQueueJobScheduleFn(
+	q *Queue,
+	lib borges.Library,
+	download, update chan gitcollector.Job,
+	downloadFn, updateFn JobFn,
+	updateOnDownload bool,
+	authTokens map[string]string,
+	jobLogger log.Logger,
+	temp billy.Filesystem,
+	retry *gitcollector.RetryOpts,
+) gitcollector.JobScheduleFn {
+	setupJob := NewJobSetupFn(
+		lib,
+		downloadFn, updateFn,
+		updateOnDownload,
+		authTokens,
+		jobLogger,
+		temp,
+	)
+
+	for _, ch := range []chan gitcollector.Job{download, update} {
+		if ch != nil {
+			q.Consume(ch, jobLogger)
+		}
+	}
+
+	return func(ctx context.Context) (gitcollector.Job, error) {
+		job, err := q.Lease(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setupJob(job); err != nil {
+			q.Fail(job.ID, err)
+			return nil, gitcollector.ErrNewJobsNotFound.New()
+		}
+
+		var (
+			process  = job.ProcessFn
+			attempts int
+		)
+
+		job.ProcessFn = func(ctx context.Context, j *Job) error {
+			attempts++
+			err := process(ctx, j)
+			if err != nil {
+				switch {
+				case gitcollector.Cancelled(ctx):
+					// it was cancelled on purpose, it
+					// mustn't be delivered again.
+					if qerr := q.Fail(j.ID, err); qerr != nil {
+						jobLogger.Errorf(qerr,
+							"couldn't mark job as failed")
+					}
+				case ctx.Err() == context.Canceled:
+					// the job didn't fail by itself, it
+					// must be delivered again.
+					if qerr := q.Release(j.ID); qerr != nil {
+						jobLogger.Errorf(qerr,
+							"couldn't release job")
+					}
+				case willRetry(retry, attempts, err):
+					// it's kept leased while the worker
+					// pool processes it again.
+				default:
+					if qerr := q.Fail(j.ID, err); qerr != nil {
+						jobLogger.Errorf(qerr,
+							"couldn't mark job as failed")
+					}
+				}
+
+				return err
+			}
+
+			if qerr := q.Done(j.ID); qerr != nil {
+				jobLogger.Errorf(qerr, "couldn't mark job as done")
+			}
+
+			return nil
+		}
+
+		return job, nil
+	}
+}
+
+// willRetry reports whether a job which failed with the given error after the
+// given attempts is processed again by a worker pool with the given options.
+func willRetry(
+	retry *gitcollector.RetryOpts,
+	attempts int,
+	err error,
+) bool {
+	if retry == nil || attempts >= retry.MaxAttempts {
+		return false
+	}
+
+	if retry.Transient == nil {
+		return err != context.Canceled
+	}
+
+	return retry.Transient(err)
+}
@@ -0,0 +1,243 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestQueue(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-queue")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q, err := OpenQueue(dir, nil)
+	require.NoError(err)
+
+	for _, e := range []string{"a", "b", "c", "d"} {
+		job := &Job{Type: JobDownload}
+		job.SetEndpoints([]string{e})
+		require.NoError(q.Enqueue(job))
+	}
+
+	ctx := context.Background()
+	leased := make([]*Job, 3)
+	for i := range leased {
+		leased[i], err = q.Lease(ctx)
+		require.NoError(err)
+	}
+
+	require.Equal([]string{"a"}, leased[0].Endpoints())
+	require.NoError(q.Done(leased[0].ID))
+	require.NoError(q.Fail(leased[1].ID, fmt.Errorf("foo")))
+
+	require.Equal(1, q.Jobs(JobEnqueued))
+	require.Equal(1, q.Jobs(JobLeased))
+	require.Equal(1, q.Jobs(JobFailed))
+
+	// the log must be resilient to a partial record written on a crash
+	require.NoError(q.Close())
+	f, err := os.OpenFile(
+		filepath.Join(dir, queueLogFile), os.O_APPEND|os.O_WRONLY, 0644,
+	)
+	require.NoError(err)
+	_, err = f.WriteString(`{"id":"foo","sta`)
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	q, err = OpenQueue(dir, nil)
+	require.NoError(err)
+	require.Equal(2, q.Jobs(JobEnqueued))
+	require.Equal(0, q.Jobs(JobLeased))
+	require.Equal(1, q.Jobs(JobFailed))
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		job, err := q.Lease(ctx)
+		require.NoError(err)
+		require.Equal(JobDownload, int(job.Type))
+		got = append(got, job.Endpoints()[0])
+		require.NoError(q.Done(job.ID))
+	}
+
+	require.Equal([]string{"c", "d"}, got)
+
+	_, err = q.Lease(ctx)
+	require.True(gitcollector.ErrJobSource.Is(err))
+	require.NoError(q.Close())
+}
+
+func TestQueueLeaseTimeout(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-queue")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q, err := OpenQueue(dir, &QueueOpts{
+		LeaseTimeout:     50 * time.Millisecond,
+		CompactThreshold: 1,
+	})
+	require.NoError(err)
+	defer q.Close()
+
+	queued := make(chan struct{})
+	input := make(chan gitcollector.Job)
+	q.Consume(input, log.New(nil))
+	input <- &Job{
+		Type:       JobUpdate,
+		LocationID: "foo",
+		Queued:     func() { close(queued) },
+	}
+
+	ctx := context.Background()
+	job, err := q.Lease(ctx)
+	require.NoError(err)
+	<-queued
+
+	ctxto, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = q.Lease(ctxto)
+	require.True(gitcollector.ErrNewJobsNotFound.Is(err))
+
+	time.Sleep(60 * time.Millisecond)
+	again, err := q.Lease(ctx)
+	require.NoError(err)
+	require.Equal(job.ID, again.ID)
+	require.Equal(job.LocationID, again.LocationID)
+
+	require.NoError(q.Done(again.ID))
+	close(input)
+
+	_, err = q.Lease(ctx)
+	require.True(gitcollector.ErrJobSource.Is(err))
+}
+
+func TestQueueJobScheduleFn(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-queue")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q, err := OpenQueue(dir, nil)
+	require.NoError(err)
+
+	var (
+		endpoints = []string{
+			"a", "b", "c", "d", "e", "f", "g", "h", "i", "j",
+		}
+
+		mu        sync.Mutex
+		got       []string
+		processFn = func(_ context.Context, j *Job) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			got = append(got, j.Endpoints()[0])
+			if j.Endpoints()[0] == "a" {
+				return fmt.Errorf("failed")
+			}
+
+			return nil
+		}
+	)
+
+	download := make(chan gitcollector.Job, 5)
+	update := make(chan gitcollector.Job, 5)
+	sched := NewQueueJobScheduleFn(
+		q,
+		nil,
+		download, update,
+		processFn, processFn,
+		false,
+		nil,
+		log.New(nil),
+		nil,
+		nil,
+	)
+
+	queues := []chan gitcollector.Job{download, update}
+	expected := testScheduleFn(sched, endpoints, queues)
+	require.ElementsMatch(expected, got)
+
+	require.Equal(0, q.Jobs(JobEnqueued))
+	require.Equal(0, q.Jobs(JobLeased))
+	require.Equal(2, q.Jobs(JobFailed))
+	require.NoError(q.Close())
+}
+
+func TestQueueJobScheduleFnRetry(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-queue")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q, err := OpenQueue(dir, nil)
+	require.NoError(err)
+
+	for _, e := range []string{"a", "b"} {
+		job := &Job{Type: JobDownload}
+		job.SetEndpoints([]string{e})
+		require.NoError(q.Enqueue(job))
+	}
+
+	processFn := func(ctx context.Context, _ *Job) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("failed")
+	}
+
+	sched := NewQueueJobScheduleFn(
+		q,
+		nil,
+		nil, nil,
+		processFn, processFn,
+		false,
+		nil,
+		log.New(nil),
+		nil,
+		&gitcollector.RetryOpts{
+			MaxAttempts: 2,
+			Transient:   func(error) bool { return true },
+		},
+	)
+
+	ctx := context.Background()
+	job, err := sched(ctx)
+	require.NoError(err)
+
+	// the job is kept leased while it has attempts left.
+	require.Error(job.Process(ctx))
+	require.Equal(1, q.Jobs(JobLeased))
+	require.Equal(0, q.Jobs(JobFailed))
+
+	require.Error(job.Process(ctx))
+	require.Equal(0, q.Jobs(JobLeased))
+	require.Equal(1, q.Jobs(JobFailed))
+
+	job, err = sched(ctx)
+	require.NoError(err)
+
+	// a cancelled job is delivered again.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Error(job.Process(cancelled))
+	require.Equal(1, q.Jobs(JobEnqueued))
+	require.Equal(1, q.Jobs(JobFailed))
+	require.NoError(q.Close())
+}
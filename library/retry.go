@@ -0,0 +1,66 @@
+package library
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/src-d/gitcollector"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+var _ gitcollector.TransientErrorFn = IsTransientError
+
+// IsTransientError is a gitcollector.TransientErrorFn which classifies the
+// errors returned by the download and update jobs. Network errors, timeouts,
+// 5xx or 429 responses and locations or libraries locked by another process
+// are transient. Missing repositories, failed authentication and the rest of
+// errors are permanent.
+func IsTransientError(err error) bool {
+	for err != nil {
+		if ErrLocationLocked.Is(err) || ErrLibraryLocked.Is(err) {
+			return true
+		}
+
+		switch e := err.(type) {
+		case *plumbing.UnexpectedError:
+			err = e.Err
+			continue
+		case *plumbing.PermanentError:
+			return false
+		case *githttp.Err:
+			code := e.StatusCode()
+			return code >= http.StatusInternalServerError ||
+				code == http.StatusTooManyRequests ||
+				code == http.StatusRequestTimeout
+		case net.Error:
+			return true
+		}
+
+		switch err {
+		case context.DeadlineExceeded,
+			io.EOF,
+			io.ErrUnexpectedEOF:
+			return true
+		case context.Canceled,
+			transport.ErrRepositoryNotFound,
+			transport.ErrEmptyRemoteRepository,
+			transport.ErrAuthenticationRequired,
+			transport.ErrAuthorizationFailed,
+			transport.ErrInvalidAuthMethod:
+			return false
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+
+		err = cause.Cause()
+	}
+
+	return false
+}
@@ -0,0 +1,48 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+func TestIsTransientError(t *testing.T) {
+	var require = require.New(t)
+
+	httpErr := func(code int) error {
+		return githttp.NewErr(&http.Response{
+			StatusCode: code,
+			Request:    &http.Request{URL: &url.URL{}},
+		})
+	}
+
+	errWrapper := errors.NewKind("wrapper")
+	for _, tst := range []struct {
+		err       error
+		transient bool
+	}{
+		{fmt.Errorf("foo"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{transport.ErrRepositoryNotFound, false},
+		{errWrapper.Wrap(transport.ErrAuthenticationRequired), false},
+		{httpErr(http.StatusUnauthorized), false},
+		{httpErr(http.StatusNotFound), false},
+		{httpErr(http.StatusBadRequest), false},
+		{httpErr(http.StatusTooManyRequests), true},
+		{httpErr(http.StatusBadGateway), true},
+		{errWrapper.Wrap(httpErr(http.StatusServiceUnavailable)), true},
+		{&url.Error{Op: "Get", Err: fmt.Errorf("connection reset")}, true},
+		{ErrLocationLocked.New("foo", "pid 1"), true},
+		{errWrapper.Wrap(ErrLibraryLocked.New("pid 1")), true},
+	} {
+		require.Equal(tst.transient, IsTransientError(tst.err), "%v", tst.err)
+	}
+}
@@ -0,0 +1,78 @@
+package library
+
+import (
+	"context"
+	"time"
+
+	"github.com/src-d/gitcollector"
+)
+
+// WithTimeouts wraps the given JobFn to process the jobs limiting the time
+// spent in each phase to the given timeouts. Phases without a timeout aren't
+// limited.
+func WithTimeouts(fn JobFn, timeouts map[Phase]time.Duration) JobFn {
+	return func(ctx context.Context, job *Job) error {
+		job.Timeouts = timeouts
+		return fn(ctx, job)
+	}
+}
+
+// PhaseFn runs the given function as a phase of a Job.
+type PhaseFn func(context.Context, Phase, func(context.Context) error) error
+
+var _ PhaseFn = (*Job)(nil).RunPhase
+
+// RunPhase runs fn with a context cancelled once the timeout of the given
+// phase expires. In that case the returned error is a gitcollector.ErrTimeout.
+func (j *Job) RunPhase(
+	ctx context.Context,
+	phase Phase,
+	fn func(context.Context) error,
+) error {
+	timeout := j.Timeouts[phase]
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := fn(phaseCtx)
+	if err != nil && ctx.Err() == nil &&
+		phaseCtx.Err() == context.DeadlineExceeded &&
+		!gitcollector.ErrTimeout.Is(err) {
+		err = gitcollector.ErrTimeout.Wrap(err, phase, timeout)
+	}
+
+	return err
+}
+
+// CommitFn commits the changes written into a location since the given time.
+type CommitFn func(context.Context, time.Time, func() error) error
+
+var _ CommitFn = (*Job)(nil).RunCommit
+
+// RunCommit runs commit, which writes the changes into a location locked at
+// the given time. It can't be interrupted without leaving the siva file half
+// written, so it isn't started once the context is done or the commit timeout
+// since the location was locked expired. In the last case the returned error
+// is a gitcollector.ErrTimeout. The changes must be rolled back if commit
+// isn't run.
+func (j *Job) RunCommit(
+	ctx context.Context,
+	locked time.Time,
+	commit func() error,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	timeout := j.Timeouts[PhaseCommit]
+	if timeout > 0 && time.Since(locked) > timeout {
+		return gitcollector.ErrTimeout.Wrap(
+			context.DeadlineExceeded, PhaseCommit, timeout,
+		)
+	}
+
+	return commit()
+}
@@ -0,0 +1,84 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunPhase(t *testing.T) {
+	var require = require.New(t)
+
+	hung := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	job := &Job{}
+	ctx := context.Background()
+	require.NoError(job.RunPhase(ctx, PhaseClone, func(context.Context) error {
+		return nil
+	}))
+
+	errFail := fmt.Errorf("fail")
+	err := job.RunPhase(ctx, PhaseClone, func(context.Context) error {
+		return errFail
+	})
+	require.Equal(errFail, err)
+
+	var timeouts map[Phase]time.Duration
+	fn := WithTimeouts(func(ctx context.Context, j *Job) error {
+		timeouts = j.Timeouts
+		return nil
+	}, map[Phase]time.Duration{PhaseFetch: 10 * time.Millisecond})
+	require.NoError(fn(ctx, job))
+	require.Equal(job.Timeouts, timeouts)
+
+	err = job.RunPhase(ctx, PhaseFetch, hung)
+	require.True(gitcollector.ErrTimeout.Is(err))
+	require.True(IsTransientError(err))
+
+	// the job was cancelled, the phase didn't time out.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = job.RunPhase(cancelled, PhaseFetch, hung)
+	require.Equal(context.Canceled, err)
+}
+
+func TestRunCommit(t *testing.T) {
+	var require = require.New(t)
+
+	var committed int
+	commit := func() error {
+		committed++
+		return nil
+	}
+
+	ctx := context.Background()
+	job := &Job{Timeouts: map[Phase]time.Duration{
+		PhaseCommit: time.Minute,
+	}}
+	require.NoError(job.RunCommit(ctx, time.Now(), commit))
+	require.Equal(1, committed)
+
+	// the commit isn't started once the timeout since the location was
+	// locked expired.
+	err := job.RunCommit(ctx, time.Now().Add(-2*time.Minute), commit)
+	require.True(gitcollector.ErrTimeout.Is(err))
+	require.True(IsTransientError(err))
+	require.Equal(1, committed)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = job.RunCommit(cancelled, time.Now(), commit)
+	require.Equal(context.Canceled, err)
+	require.Equal(1, committed)
+
+	job = &Job{}
+	require.NoError(job.RunCommit(ctx, time.Now().Add(-time.Hour), commit))
+	require.Equal(2, committed)
+}
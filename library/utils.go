@@ -1,6 +1,8 @@
 package library
 
 import (
+	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/src-d/go-borges"
@@ -15,6 +17,10 @@ var (
 	// ErrNotSivaLocation is returned when a borges.Library is no a
 	// siva.Location
 	ErrNotSivaLocation = errors.NewKind("not siva location found")
+
+	// ErrWrongEndpoint is returned when an endpoint doesn't identify a
+	// repository of an organization.
+	ErrWrongEndpoint = errors.NewKind("wrong repository endpoint %s")
 )
 
 // NewRepositoryID builds a borges.RepositoryID from the given endpoint.
@@ -27,9 +33,54 @@ func NewRepositoryID(endpoint string) (borges.RepositoryID, error) {
 	return borges.RepositoryID(strings.TrimSuffix(id.String(), ".git")), nil
 }
 
-// GetOrgFromEndpoint retrieve the organization from an endpoint.
+// ValidateEndpoint checks a repository ID can be built from the given
+// endpoint, otherwise it returns an ErrWrongEndpoint.
+func ValidateEndpoint(endpoint string) error {
+	id, err := NewRepositoryID(endpoint)
+	if err != nil {
+		return ErrWrongEndpoint.Wrap(err, endpoint)
+	}
+
+	// a valid id has at least the host, the owner and the repository name
+	parts := strings.Split(id.String(), "/")
+	if len(parts) < 3 || parts[1] == "" || parts[len(parts)-1] == "" {
+		return ErrWrongEndpoint.New(endpoint)
+	}
+
+	return nil
+}
+
+// GetOrgFromEndpoint retrieve the organization from an endpoint. It returns
+// an empty string if the endpoint isn't valid or has no organization.
 func GetOrgFromEndpoint(endpoint string) string {
-	id, _ := NewRepositoryID(endpoint)
-	org := strings.Split(id.String(), "/")[1]
-	return strings.ToLower(org)
+	id, err := NewRepositoryID(endpoint)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(id.String(), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	return strings.ToLower(parts[1])
+}
+
+// LocationPath returns the path of the siva file for the given location in a
+// siva.Library using the given bucket level.
+func LocationPath(id borges.LocationID, bucket int) string {
+	siva := fmt.Sprintf("%s.siva", id)
+	if bucket <= 0 {
+		return siva
+	}
+
+	r := []rune(id)
+	var bucketDir string
+	if len(r) < bucket {
+		bucketDir = string(id) + strings.Repeat("-", bucket-len(r))
+	} else {
+		bucketDir = string(r[:bucket])
+	}
+
+	return filepath.Join(bucketDir, siva)
 }
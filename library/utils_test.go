@@ -0,0 +1,31 @@
+package library
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrgFromEndpoint(t *testing.T) {
+	var require = require.New(t)
+
+	for endpoint, org := range map[string]string{
+		"https://github.com/src-d/gitcollector": "src-d",
+		"git@github.com:SRC-D/go-borges.git":    "src-d",
+		"file:///tmp/repos/foo":                 "tmp",
+		"https://github.com":                    "",
+		"foo":                                   "",
+		"":                                      "",
+	} {
+		require.Equal(org, GetOrgFromEndpoint(endpoint), endpoint)
+	}
+
+	require.NoError(ValidateEndpoint("https://github.com/src-d/gitcollector"))
+	require.NoError(ValidateEndpoint("file:///tmp/repos/foo"))
+	for _, endpoint := range []string{
+		"https://github.com/src-d", "https://github.com", "foo", "",
+	} {
+		require.True(ErrWrongEndpoint.Is(ValidateEndpoint(endpoint)),
+			endpoint)
+	}
+}
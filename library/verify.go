@@ -0,0 +1,308 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	sivafmt "gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	// ErrCorruptedIndex is returned when the index of a siva file can't be
+	// read.
+	ErrCorruptedIndex = errors.NewKind("corrupted siva index")
+
+	// ErrCorruptedObjects is returned when an object reachable from the
+	// references of a location is missing or can't be decoded.
+	ErrCorruptedObjects = errors.NewKind("corrupted objects")
+
+	// ErrNoValidIndex is returned when a siva file can't be rolled back
+	// because none of its index blocks is valid.
+	ErrNoValidIndex = errors.NewKind("no valid index block found in %s")
+)
+
+const (
+	// indexFooterSize is the size of the footer written after every siva
+	// index.
+	indexFooterSize = 24
+	// indexEntrySize is the size of an index entry without its name.
+	indexEntrySize = 36
+)
+
+// indexSignature is written at the beginning of every siva index.
+var indexSignature = append(
+	append([]byte(nil), sivafmt.IndexSignature...),
+	sivafmt.IndexVersion,
+)
+
+// Verification is the result of checking the integrity of a location.
+type Verification struct {
+	Location borges.LocationID
+	Refs     int
+	Objects  int
+	Err      error
+}
+
+// Corrupted returns whether the location is corrupted or incomplete.
+func (v *Verification) Corrupted() bool {
+	return v.Err != nil
+}
+
+// VerifyLocation checks the index of the siva file of the given location in
+// the given billy.Filesystem, with the bucket level used by the library, and
+// confirms every object reachable from its references is present and can be
+// decoded.
+func VerifyLocation(
+	ctx context.Context,
+	loc borges.Location,
+	fs billy.Filesystem,
+	bucket int,
+) *Verification {
+	v := &Verification{Location: loc.ID()}
+	if err := checkIndex(fs, LocationPath(loc.ID(), bucket)); err != nil {
+		v.Err = ErrCorruptedIndex.Wrap(err)
+		return v
+	}
+
+	repo, err := loc.Get("", borges.ReadOnlyMode)
+	if err != nil {
+		v.Err = ErrCorruptedIndex.Wrap(err)
+		return v
+	}
+	defer repo.Close()
+
+	if err := checkObjects(ctx, repo.R().Storer, v); err != nil {
+		v.Err = ErrCorruptedObjects.Wrap(err)
+	}
+
+	return v
+}
+
+func checkIndex(fs billy.Filesystem, path string) error {
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = sivafmt.NewReader(f).Index()
+	return err
+}
+
+func checkObjects(
+	ctx context.Context,
+	sto storer.Storer,
+	v *Verification,
+) error {
+	refs, err := sto.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	var tips []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		// go-borges keeps some references to the zero hash.
+		if ref.Type() == plumbing.HashReference && !ref.Hash().IsZero() {
+			v.Refs++
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the commits, trees and tags are decoded while walking them.
+	hashes, err := revlist.Objects(sto, tips, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hashes {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if err := readObject(sto, h); err != nil {
+			return err
+		}
+
+		v.Objects++
+	}
+
+	return nil
+}
+
+// readObject reads the whole content of an object so its deltas are
+// resolved.
+func readObject(sto storer.EncodedObjectStorer, h plumbing.Hash) error {
+	obj, err := sto.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return err
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// RollbackLocation truncates the siva file of the given location after its
+// last valid index block, discarding the data written after it. It returns
+// the new size of the file.
+func RollbackLocation(
+	fs billy.Filesystem,
+	bucket int,
+	id borges.LocationID,
+) (int64, error) {
+	path := LocationPath(id, bucket)
+	f, err := fs.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	end, err := lastValidBlock(f)
+	if err != nil {
+		return 0, err
+	}
+
+	if end == 0 {
+		return 0, ErrNoValidIndex.New(path)
+	}
+
+	if err := f.Truncate(end); err != nil {
+		return 0, err
+	}
+
+	return end, nil
+}
+
+// lastValidBlock returns the end offset of the last siva block whose index
+// chain can be read, or 0 if there is none. The indexes are found looking
+// for their signature.
+func lastValidBlock(f billy.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	starts, err := indexSignatures(f)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(starts) - 1; i >= 0; i-- {
+		end, ok := indexEnd(f, starts[i], size)
+		if !ok {
+			continue
+		}
+
+		_, err := sivafmt.NewReaderWithOffset(f, uint64(end)).Index()
+		if err == nil {
+			return end, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// indexSignatures returns the offsets of the possible siva indexes.
+func indexSignatures(r io.ReadSeeker) ([]int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var (
+		offsets []int64
+		pos     int64
+		buf     = make([]byte, 32*1024)
+		tail    []byte
+	)
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// the bytes kept from the previous read start at base.
+			data := append(tail, buf[:n]...)
+			base := pos - int64(len(tail))
+			for i := 0; ; {
+				j := bytes.Index(data[i:], indexSignature)
+				if j < 0 {
+					break
+				}
+
+				offsets = append(offsets, base+int64(i+j))
+				i += j + 1
+			}
+
+			pos += int64(n)
+			keep := len(indexSignature) - 1
+			if len(data) < keep {
+				keep = len(data)
+			}
+
+			tail = append([]byte(nil), data[len(data)-keep:]...)
+		}
+
+		if err == io.EOF {
+			return offsets, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// indexEnd walks the entries of the index starting at the given offset until
+// they are followed by a footer matching them. It returns the offset where
+// the block of the index ends.
+func indexEnd(r io.ReadSeeker, start, size int64) (int64, bool) {
+	pos := start + int64(len(indexSignature))
+	for entries := uint32(0); pos+indexFooterSize <= size; entries++ {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, false
+		}
+
+		var footer sivafmt.IndexFooter
+		if err := footer.ReadFrom(r); err != nil {
+			return 0, false
+		}
+
+		if footer.EntryCount == entries &&
+			footer.IndexSize == uint64(pos-start) {
+			return pos + indexFooterSize, true
+		}
+
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, false
+		}
+
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return 0, false
+		}
+
+		pos += 4 + int64(length) + indexEntrySize
+	}
+
+	return 0, false
+}
@@ -0,0 +1,160 @@
+package library
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+func TestVerifyLocation(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-verify")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fs := osfs.New(dir)
+	lib := newVerifyLibrary(t, fs)
+	loc, err := lib.AddLocation("foo")
+	require.NoError(err)
+
+	repo, err := loc.Init("github.com/src-d/foo")
+	require.NoError(err)
+	commitFile(t, repo, "master", "foo")
+
+	info, err := fs.Stat(LocationPath("foo", 2))
+	require.NoError(err)
+	firstBlock := info.Size()
+
+	repo, err = loc.Get("github.com/src-d/foo", borges.RWMode)
+	require.NoError(err)
+	commitFile(t, repo, "dev", "bar")
+
+	ctx := context.Background()
+	v := VerifyLocation(ctx, loc, fs, 2)
+	require.False(v.Corrupted(), "%v", v.Err)
+	require.Equal(2, v.Refs)
+	require.Equal(6, v.Objects)
+
+	// the last block is truncated.
+	path := LocationPath("foo", 2)
+	info, err = fs.Stat(path)
+	require.NoError(err)
+	require.NoError(os.Truncate(filepath.Join(dir, path), info.Size()-10))
+
+	loc, err = newVerifyLibrary(t, fs).Location("foo")
+	require.NoError(err)
+	v = VerifyLocation(ctx, loc, fs, 2)
+	require.True(ErrCorruptedIndex.Is(v.Err))
+
+	size, err := RollbackLocation(fs, 2, "foo")
+	require.NoError(err)
+	require.Equal(firstBlock, size)
+
+	loc, err = newVerifyLibrary(t, fs).Location("foo")
+	require.NoError(err)
+	v = VerifyLocation(ctx, loc, fs, 2)
+	require.False(v.Corrupted(), "%v", v.Err)
+	require.Equal(1, v.Refs)
+
+	// a reference to a missing object.
+	repo, err = loc.Get("github.com/src-d/foo", borges.RWMode)
+	require.NoError(err)
+	require.NoError(repo.R().Storer.SetReference(plumbing.NewHashReference(
+		"refs/remotes/github.com/src-d/foo/missing",
+		plumbing.NewHash("a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2"),
+	)))
+	require.NoError(repo.Commit())
+
+	v = VerifyLocation(ctx, loc, fs, 2)
+	require.True(ErrCorruptedObjects.Is(v.Err))
+
+	require.NoError(fs.Remove(path))
+	f, err := fs.Create(path)
+	require.NoError(err)
+	_, err = f.Write([]byte("IBA\x01garbage"))
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	_, err = RollbackLocation(fs, 2, "foo")
+	require.True(ErrNoValidIndex.Is(err))
+}
+
+func newVerifyLibrary(t *testing.T, fs billy.Filesystem) *siva.Library {
+	lib, err := siva.NewLibrary("test", fs, &siva.LibraryOptions{
+		Bucket:        2,
+		Transactional: true,
+	})
+	require.NoError(t, err)
+	return lib
+}
+
+// commitFile commits a file with the given name to the given branch of the
+// repository remote and commits the transaction.
+func commitFile(t *testing.T, repo borges.Repository, branch, name string) {
+	var require = require.New(t)
+
+	sto := repo.R().Storer
+	blob := sto.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	require.NoError(err)
+	_, err = w.Write([]byte(name))
+	require.NoError(err)
+	require.NoError(w.Close())
+
+	tree := &object.Tree{Entries: []object.TreeEntry{{
+		Name: name,
+		Mode: filemode.Regular,
+		Hash: setObject(t, sto, blob),
+	}}}
+
+	sig := object.Signature{Name: "foo", Email: "foo@bar", When: time.Now()}
+	commit := &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   name,
+		TreeHash:  encodeObject(t, sto, tree),
+	}
+
+	require.NoError(sto.SetReference(plumbing.NewHashReference(
+		plumbing.ReferenceName(
+			"refs/remotes/"+repo.ID().String()+"/"+branch,
+		),
+		encodeObject(t, sto, commit),
+	)))
+	require.NoError(repo.Commit())
+}
+
+func encodeObject(
+	t *testing.T,
+	sto storer.Storer,
+	o object.Object,
+) plumbing.Hash {
+	obj := sto.NewEncodedObject()
+	require.NoError(t, o.Encode(obj))
+	return setObject(t, sto, obj)
+}
+
+func setObject(
+	t *testing.T,
+	sto storer.Storer,
+	obj plumbing.EncodedObject,
+) plumbing.Hash {
+	h, err := sto.SetEncodedObject(obj)
+	require.NoError(t, err)
+	return h
+}
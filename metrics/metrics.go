@@ -34,9 +34,15 @@ type Collector struct {
 	fail      chan gitcollector.Job
 	failCount uint64
 
+	timeout      chan gitcollector.Job
+	timeoutCount uint64
+
 	discover      chan gitcollector.Job
 	discoverCount uint64
 
+	filter      chan gitcollector.Job
+	filterCount uint64
+
 	wg     sync.WaitGroup
 	cancel chan bool
 }
@@ -80,7 +86,9 @@ func NewCollector(opts *CollectorOpts) *Collector {
 		opts:     opts,
 		success:  make(chan gitcollector.Job, capacity),
 		fail:     make(chan gitcollector.Job, capacity),
+		timeout:  make(chan gitcollector.Job, capacity),
 		discover: make(chan gitcollector.Job, capacity),
+		filter:   make(chan gitcollector.Job, capacity),
 		cancel:   make(chan bool),
 	}
 }
@@ -88,7 +96,9 @@ func NewCollector(opts *CollectorOpts) *Collector {
 const (
 	successKind = iota
 	failKind
+	timeoutKind
 	discoverKind
+	filterKind
 )
 
 // Start implements the gitcollector.MetricsCollector interface.
@@ -126,6 +136,13 @@ func (c *Collector) Start() {
 			}
 
 			j, kind = job, failKind
+		case job, ok := <-c.timeout:
+			if !ok {
+				c.timeout = nil
+				continue
+			}
+
+			j, kind = job, timeoutKind
 		case job, ok := <-c.discover:
 			if !ok {
 				c.discover = nil
@@ -133,6 +150,13 @@ func (c *Collector) Start() {
 			}
 
 			j, kind = job, discoverKind
+		case job, ok := <-c.filter:
+			if !ok {
+				c.filter = nil
+				continue
+			}
+
+			j, kind = job, filterKind
 		case stop = <-c.cancel:
 			c.close()
 			continue
@@ -195,6 +219,8 @@ func (c *Collector) logMetrics(debug bool) {
 		"download": c.successDownloadCount,
 		"update":   c.successUpdateCount,
 		"fail":     c.failCount,
+		"timeout":  c.timeoutCount,
+		"filter":   c.filterCount,
 	})
 
 	msg := "metrics updated"
@@ -206,13 +232,16 @@ func (c *Collector) logMetrics(debug bool) {
 }
 
 func (c *Collector) isClosed() bool {
-	return c.success == nil && c.fail == nil && c.discover == nil
+	return c.success == nil && c.fail == nil && c.timeout == nil &&
+		c.discover == nil && c.filter == nil
 }
 
 func (c *Collector) close() {
 	close(c.success)
 	close(c.fail)
+	close(c.timeout)
 	close(c.discover)
+	close(c.filter)
 	close(c.cancel)
 	c.cancel = nil
 }
@@ -232,10 +261,20 @@ func (c *Collector) modifyMetrics(job *library.Job, kind int) error {
 		for range job.Endpoints() {
 			c.failCount++
 		}
+	case timeoutKind:
+		// timed out jobs are failed jobs too.
+		for range job.Endpoints() {
+			c.failCount++
+			c.timeoutCount++
+		}
 	case discoverKind:
 		if job.Type == library.JobDownload {
 			c.discoverCount++
 		}
+	case filterKind:
+		for range job.Endpoints() {
+			c.filterCount++
+		}
 	default:
 		return fmt.Errorf("wrong metric type found: %d", kind)
 	}
@@ -278,11 +317,21 @@ func (c *Collector) Fail(job gitcollector.Job) {
 	c.fail <- job
 }
 
+// Timeout implements the gitcollector.MetricsCollector interface.
+func (c *Collector) Timeout(job gitcollector.Job) {
+	c.timeout <- job
+}
+
 // Discover implements the gitcollector.MetricsCollector interface.
 func (c *Collector) Discover(job gitcollector.Job) {
 	c.discover <- job
 }
 
+// Filter implements the gitcollector.MetricsCollector interface.
+func (c *Collector) Filter(job gitcollector.Job) {
+	c.filter <- job
+}
+
 // CollectorByOrg plays as a reverse proxy Collector for several organizations.
 type CollectorByOrg struct {
 	orgMetrics map[string]*Collector
@@ -335,6 +384,19 @@ func (c *CollectorByOrg) Fail(job gitcollector.Job) {
 	}
 }
 
+// Timeout implements the gitcollector.MetricsCollector interface.
+func (c *CollectorByOrg) Timeout(job gitcollector.Job) {
+	orgs := triageJob(job)
+	for org, job := range orgs {
+		m, ok := c.orgMetrics[org]
+		if !ok {
+			continue
+		}
+
+		m.Timeout(job)
+	}
+}
+
 // Discover implements the gitcollector.MetricsCollector interface.
 func (c *CollectorByOrg) Discover(job gitcollector.Job) {
 	orgs := triageJob(job)
@@ -348,6 +410,19 @@ func (c *CollectorByOrg) Discover(job gitcollector.Job) {
 	}
 }
 
+// Filter implements the gitcollector.MetricsCollector interface.
+func (c *CollectorByOrg) Filter(job gitcollector.Job) {
+	orgs := triageJob(job)
+	for org, job := range orgs {
+		m, ok := c.orgMetrics[org]
+		if !ok {
+			continue
+		}
+
+		m.Filter(job)
+	}
+}
+
 func triageJob(job gitcollector.Job) map[string]*library.Job {
 	organizations := map[string]*library.Job{}
 	lj, _ := job.(*library.Job)
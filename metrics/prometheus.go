@@ -0,0 +1,395 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/src-d/gitcollector/library"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// PrometheusOpts represents configuration options for a PrometheusCollector.
+type PrometheusOpts struct {
+	// Namespace prefixes the name of the metrics.
+	Namespace string
+	// Registerer is where the metrics are registered, by default
+	// prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+	// QueueDepth returns the number of jobs waiting to be processed.
+	QueueDepth func() int
+	// ActiveWorkers returns the number of workers processing a job.
+	ActiveWorkers func() int
+	// TokenQuotas returns the rate limit quota of the GitHub tokens.
+	TokenQuotas func() []discovery.TokenQuota
+	Log         log.Logger
+}
+
+// PrometheusCollector is an implementation of gitcollector.MetricsCollector
+// exposing the metrics as prometheus collectors.
+type PrometheusCollector struct {
+	logger     log.Logger
+	discovered *prometheus.CounterVec
+	succeeded  *prometheus.CounterVec
+	failed     *prometheus.CounterVec
+	timedOut   *prometheus.CounterVec
+	filtered   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	phases     *prometheus.HistogramVec
+}
+
+var _ gitcollector.MetricsCollector = (*PrometheusCollector)(nil)
+
+const (
+	namespace = "gitcollector"
+
+	orgLabel    = "org"
+	typeLabel   = "type"
+	resultLabel = "result"
+	phaseLabel  = "phase"
+	tokenLabel  = "token"
+)
+
+// NewPrometheusCollector builds a new PrometheusCollector and registers its
+// metrics.
+func NewPrometheusCollector(
+	opts *PrometheusOpts,
+) (*PrometheusCollector, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = namespace
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	if opts.Log == nil {
+		opts.Log = log.New(nil)
+	}
+
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      name,
+			Help:      help,
+		}, []string{orgLabel, typeLabel})
+	}
+
+	c := &PrometheusCollector{
+		logger: opts.Log.New(log.Fields{"metrics": "prometheus"}),
+		discovered: counter(
+			"jobs_discovered_total",
+			"Number of discovered jobs.",
+		),
+		succeeded: counter(
+			"jobs_succeeded_total",
+			"Number of successfully processed jobs.",
+		),
+		failed: counter(
+			"jobs_failed_total",
+			"Number of jobs which processing failed, "+
+				"including the ones which timed out.",
+		),
+		timedOut: counter(
+			"jobs_timed_out_total",
+			"Number of jobs which processing timed out.",
+		),
+		filtered: counter(
+			"jobs_filtered_total",
+			"Number of discovered jobs which were filtered out.",
+		),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Time spent processing the jobs.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+		}, []string{typeLabel, resultLabel}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "job_phase_duration_seconds",
+			Help:      "Time spent in every phase of the jobs.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 16),
+		}, []string{typeLabel, phaseLabel}),
+	}
+
+	collectors := []prometheus.Collector{
+		c.discovered, c.succeeded, c.failed, c.timedOut, c.filtered,
+		c.duration, c.phases,
+	}
+
+	if opts.QueueDepth != nil {
+		collectors = append(collectors, prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Name:      "queued_jobs",
+				Help:      "Number of jobs waiting to be processed.",
+			},
+			func() float64 { return float64(opts.QueueDepth()) },
+		))
+	}
+
+	if opts.ActiveWorkers != nil {
+		collectors = append(collectors, prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Name:      "active_workers",
+				Help:      "Number of workers processing a job.",
+			},
+			func() float64 { return float64(opts.ActiveWorkers()) },
+		))
+	}
+
+	if opts.TokenQuotas != nil {
+		collectors = append(collectors, newTokenQuotaCollector(
+			opts.Namespace, opts.TokenQuotas,
+		))
+	}
+
+	for _, collector := range collectors {
+		if err := opts.Registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Start implements the gitcollector.MetricsCollector interface.
+func (c *PrometheusCollector) Start() {}
+
+// Stop implements the gitcollector.MetricsCollector interface.
+func (c *PrometheusCollector) Stop(bool) {}
+
+// Success implements the gitcollector.MetricsCollector interface.
+func (c *PrometheusCollector) Success(job gitcollector.Job) {
+	c.processed(job, "success", c.succeeded)
+}
+
+// Fail implements the gitcollector.MetricsCollector interface.
+func (c *PrometheusCollector) Fail(job gitcollector.Job) {
+	c.processed(job, "fail", c.failed)
+}
+
+// Timeout implements the gitcollector.MetricsCollector interface. A job which
+// timed out is counted as failed too, like the Collector does.
+func (c *PrometheusCollector) Timeout(job gitcollector.Job) {
+	c.processed(job, "timeout", c.failed, c.timedOut)
+}
+
+// Discover implements the gitcollector.MetricsCollector interface.
+func (c *PrometheusCollector) Discover(job gitcollector.Job) {
+	lj, ok := c.libraryJob(job)
+	if !ok {
+		return
+	}
+
+	typ := jobType(lj)
+	for _, org := range jobOrgs(lj) {
+		c.discovered.WithLabelValues(org, typ).Inc()
+	}
+}
+
+// Filter implements the gitcollector.MetricsCollector interface.
+func (c *PrometheusCollector) Filter(job gitcollector.Job) {
+	lj, ok := c.libraryJob(job)
+	if !ok {
+		return
+	}
+
+	typ := jobType(lj)
+	for _, org := range jobOrgs(lj) {
+		c.filtered.WithLabelValues(org, typ).Inc()
+	}
+}
+
+func (c *PrometheusCollector) processed(
+	job gitcollector.Job,
+	result string,
+	counters ...*prometheus.CounterVec,
+) {
+	lj, ok := c.libraryJob(job)
+	if !ok {
+		return
+	}
+
+	typ := jobType(lj)
+	for _, org := range jobOrgs(lj) {
+		for _, counter := range counters {
+			counter.WithLabelValues(org, typ).Inc()
+		}
+	}
+
+	c.duration.WithLabelValues(typ, result).
+		Observe(lj.Elapsed().Seconds())
+
+	for phase, elapsed := range lj.Phases() {
+		c.phases.WithLabelValues(typ, string(phase)).
+			Observe(elapsed.Seconds())
+	}
+}
+
+func (c *PrometheusCollector) libraryJob(
+	job gitcollector.Job,
+) (*library.Job, bool) {
+	lj, ok := job.(*library.Job)
+	if !ok {
+		c.logger.Warningf("wrong job found: %T", job)
+	}
+
+	return lj, ok
+}
+
+func jobType(job *library.Job) string {
+	return job.Type.String()
+}
+
+// jobOrgs returns the organizations of the job endpoints without duplicates.
+func jobOrgs(job *library.Job) []string {
+	var (
+		orgs []string
+		seen = map[string]bool{}
+	)
+
+	for _, ep := range job.Endpoints() {
+		org := library.GetOrgFromEndpoint(ep)
+		if !seen[org] {
+			seen[org] = true
+			orgs = append(orgs, org)
+		}
+	}
+
+	if len(orgs) == 0 {
+		orgs = append(orgs, "")
+	}
+
+	return orgs
+}
+
+// tokenQuotaCollector exposes the quota of the GitHub tokens, which are
+// only known when the metrics are collected.
+type tokenQuotaCollector struct {
+	quotas    func() []discovery.TokenQuota
+	limit     *prometheus.Desc
+	remaining *prometheus.Desc
+	reset     *prometheus.Desc
+}
+
+func newTokenQuotaCollector(
+	namespace string,
+	quotas func() []discovery.TokenQuota,
+) *tokenQuotaCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", name),
+			help,
+			[]string{tokenLabel},
+			nil,
+		)
+	}
+
+	return &tokenQuotaCollector{
+		quotas: quotas,
+		limit: desc(
+			"github_token_limit_requests",
+			"Number of requests per hour allowed to a GitHub token.",
+		),
+		remaining: desc(
+			"github_token_remaining_requests",
+			"Number of requests left to a GitHub token.",
+		),
+		reset: desc(
+			"github_token_reset_timestamp_seconds",
+			"Time when the quota of a GitHub token is reset.",
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *tokenQuotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.limit
+	ch <- c.remaining
+	ch <- c.reset
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *tokenQuotaCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, q := range c.quotas() {
+		ch <- prometheus.MustNewConstMetric(
+			c.limit, prometheus.GaugeValue, float64(q.Limit), q.Token,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.remaining, prometheus.GaugeValue,
+			float64(q.Remaining), q.Token,
+		)
+
+		if !q.Reset.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				c.reset, prometheus.GaugeValue,
+				float64(q.Reset.Unix()), q.Token,
+			)
+		}
+	}
+}
+
+// MultiCollector is a gitcollector.MetricsCollector which sends the metrics
+// to several collectors, e.g. a CollectorByOrg and a PrometheusCollector.
+type MultiCollector struct {
+	collectors []gitcollector.MetricsCollector
+}
+
+var _ gitcollector.MetricsCollector = (*MultiCollector)(nil)
+
+// NewMultiCollector builds a new MultiCollector.
+func NewMultiCollector(
+	collectors ...gitcollector.MetricsCollector,
+) *MultiCollector {
+	return &MultiCollector{collectors: collectors}
+}
+
+// Start implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Start() {
+	for _, m := range c.collectors {
+		go m.Start()
+	}
+}
+
+// Stop implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Stop(immediate bool) {
+	for _, m := range c.collectors {
+		m.Stop(immediate)
+	}
+}
+
+// Success implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Success(job gitcollector.Job) {
+	for _, m := range c.collectors {
+		m.Success(job)
+	}
+}
+
+// Fail implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Fail(job gitcollector.Job) {
+	for _, m := range c.collectors {
+		m.Fail(job)
+	}
+}
+
+// Timeout implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Timeout(job gitcollector.Job) {
+	for _, m := range c.collectors {
+		m.Timeout(job)
+	}
+}
+
+// Discover implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Discover(job gitcollector.Job) {
+	for _, m := range c.collectors {
+		m.Discover(job)
+	}
+}
+
+// Filter implements the gitcollector.MetricsCollector interface.
+func (c *MultiCollector) Filter(job gitcollector.Job) {
+	for _, m := range c.collectors {
+		m.Filter(job)
+	}
+}
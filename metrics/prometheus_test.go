@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/src-d/gitcollector/library"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	var require = require.New(t)
+
+	reg := prometheus.NewRegistry()
+	mc, err := NewPrometheusCollector(&PrometheusOpts{
+		Registerer:    reg,
+		QueueDepth:    func() int { return 7 },
+		ActiveWorkers: func() int { return 3 },
+		TokenQuotas: func() []discovery.TokenQuota {
+			return []discovery.TokenQuota{
+				{Token: "0-aaaa", Limit: 5000, Remaining: 10},
+				{Token: "1-bbbb", Limit: 5000, Remaining: 4000},
+			}
+		},
+	})
+	require.NoError(err)
+
+	_, err = NewPrometheusCollector(&PrometheusOpts{Registerer: reg})
+	require.Error(err)
+
+	download := &library.Job{
+		Type: library.JobDownload,
+		ProcessFn: func(_ context.Context, j *library.Job) error {
+			j.ObservePhase(library.PhaseClone, time.Second)
+			j.ObservePhase(library.PhaseCommit, time.Second)
+			return nil
+		},
+	}
+	download.SetEndpoints([]string{"git://github.com/src-d/foo.git"})
+	require.NoError(download.Process(context.Background()))
+
+	update := &library.Job{Type: library.JobUpdate}
+	update.SetEndpoints([]string{
+		"git://github.com/src-d/bar.git",
+		"git://github.com/src-d/baz.git",
+		"git://github.com/bblfsh/bar.git",
+	})
+
+	mc.Discover(download)
+	mc.Success(download)
+	mc.Success(update)
+	mc.Fail(update)
+	mc.Timeout(download)
+	mc.Filter(update)
+
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.discovered.WithLabelValues("src-d", "download")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.succeeded.WithLabelValues("src-d", "download")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.succeeded.WithLabelValues("src-d", "update")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.succeeded.WithLabelValues("bblfsh", "update")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.failed.WithLabelValues("bblfsh", "update")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.timedOut.WithLabelValues("src-d", "download")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.failed.WithLabelValues("src-d", "download")))
+	require.Equal(1.0, testutil.ToFloat64(
+		mc.filtered.WithLabelValues("bblfsh", "update")))
+
+	families, err := reg.Gather()
+	require.NoError(err)
+
+	values := map[string]float64{}
+	remaining := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			switch {
+			case f.GetName() ==
+				"gitcollector_github_token_remaining_requests":
+				token := m.GetLabel()[0].GetValue()
+				remaining[token] = m.GetGauge().GetValue()
+			case m.GetGauge() != nil:
+				values[f.GetName()] = m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				values[f.GetName()] += float64(
+					m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	require.Equal(7.0, values["gitcollector_queued_jobs"])
+	require.Equal(3.0, values["gitcollector_active_workers"])
+	require.Equal(4.0, values["gitcollector_job_duration_seconds"])
+	require.Equal(4.0, values["gitcollector_job_phase_duration_seconds"])
+	require.Equal(map[string]float64{
+		"0-aaaa": 10,
+		"1-bbbb": 4000,
+	}, remaining)
+}
@@ -0,0 +1,250 @@
+package provider
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+var (
+	// ErrEndpointsStopped is returned when an Endpoints provider has been
+	// stopped or there are no more endpoints to read.
+	ErrEndpointsStopped = errors.NewKind("endpoints provider stopped")
+
+	// ErrEndpointsStop is returned when an Endpoints provider fails on
+	// Stop.
+	ErrEndpointsStop = errors.NewKind("endpoints provider failed on stop")
+
+	errWrongEndpointLine = errors.NewKind("wrong endpoint line: %s")
+)
+
+// StdinPath is the path used to read the endpoints from the standard input.
+const StdinPath = "-"
+
+// EndpointsOpts represents configuration options for an Endpoints.
+type EndpointsOpts struct {
+	// Follow keeps reading the source once its end is reached, so lines
+	// appended later are also provided.
+	Follow bool
+	// PollInterval is the time waited to look for new lines when Follow
+	// is set.
+	PollInterval time.Duration
+	// EnqueueTimeout is the time a job waits to be enqueued before a
+	// warning about the full queue is logged, it keeps waiting until the
+	// provider is stopped.
+	EnqueueTimeout time.Duration
+	// StopTimeout is the time the service waits to be stopped after a Stop
+	// call is performed.
+	StopTimeout time.Duration
+	// Logger is used to report the discarded lines.
+	Logger log.Logger
+}
+
+// Endpoints is a gitcollector.Provider implementation. It reads a list of
+// git endpoints from a file or the standard input and produces a download
+// gitcollector.Job for each one of them. Each line of the list can be a plain
+// endpoint or a JSON object with an "endpoint" (or "url") field. Empty lines
+// and lines starting with # are ignored.
+type Endpoints struct {
+	path   string
+	queue  chan<- gitcollector.Job
+	cancel chan struct{}
+	opts   *EndpointsOpts
+}
+
+var _ gitcollector.Provider = (*Endpoints)(nil)
+
+const pollInterval = time.Second
+
+// NewEndpoints builds a new Endpoints reading from the given path, StdinPath
+// can be used to read from the standard input.
+func NewEndpoints(
+	path string,
+	queue chan<- gitcollector.Job,
+	opts *EndpointsOpts,
+) *Endpoints {
+	if opts == nil {
+		opts = &EndpointsOpts{}
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = pollInterval
+	}
+
+	if opts.StopTimeout <= 0 {
+		opts.StopTimeout = stopTimeout
+	}
+
+	if opts.EnqueueTimeout <= 0 {
+		opts.EnqueueTimeout = enqueueTimeout
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
+	return &Endpoints{
+		path:   path,
+		queue:  queue,
+		cancel: make(chan struct{}),
+		opts:   opts,
+	}
+}
+
+// Start implements the gitcollector.Provider interface.
+func (p *Endpoints) Start() error {
+	var r io.ReadCloser = os.Stdin
+	if p.path != StdinPath {
+		f, err := os.Open(p.path)
+		if err != nil {
+			return err
+		}
+
+		r = f
+	}
+	defer r.Close()
+
+	var (
+		stop = make(chan struct{})
+		done = make(chan error, 1)
+	)
+
+	go func() {
+		done <- p.read(bufio.NewReader(r), stop)
+	}()
+
+	select {
+	case <-p.cancel:
+		close(stop)
+		return ErrEndpointsStopped.New()
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	}
+
+	return ErrEndpointsStopped.New()
+}
+
+func (p *Endpoints) read(r *bufio.Reader, stop <-chan struct{}) error {
+	var partial string
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if err == io.EOF {
+			if !p.opts.Follow {
+				return p.enqueue(partial+line, stop)
+			}
+
+			// the line could be still being written, wait for
+			// the rest of it.
+			partial += line
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(p.opts.PollInterval):
+			}
+
+			continue
+		}
+
+		line, partial = partial+line, ""
+		if err := p.enqueue(line, stop); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Endpoints) enqueue(line string, stop <-chan struct{}) error {
+	endpoint, err := parseEndpointLine(line)
+	if err != nil {
+		p.opts.Logger.Warningf(err.Error())
+		return nil
+	}
+
+	if endpoint == "" {
+		return nil
+	}
+
+	if err := library.ValidateEndpoint(endpoint); err != nil {
+		p.opts.Logger.Warningf(err.Error())
+		return nil
+	}
+
+	job := &library.Job{
+		Type: library.JobDownload,
+	}
+	job.SetEndpoints([]string{endpoint})
+
+	// slow workers only delay the rest of the endpoints.
+	for {
+		select {
+		case p.queue <- job:
+			return nil
+		case <-stop:
+			return nil
+		case <-time.After(p.opts.EnqueueTimeout):
+			p.opts.Logger.Warningf(
+				"queue is full, waiting to enqueue %s", endpoint,
+			)
+		}
+	}
+}
+
+type endpointLine struct {
+	Endpoint string `json:"endpoint"`
+	URL      string `json:"url"`
+}
+
+func parseEndpointLine(line string) (string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(line, "{") {
+		return line, nil
+	}
+
+	var el endpointLine
+	if err := json.Unmarshal([]byte(line), &el); err != nil {
+		return "", errWrongEndpointLine.Wrap(err, line)
+	}
+
+	if el.Endpoint != "" {
+		return el.Endpoint, nil
+	}
+
+	if el.URL != "" {
+		return el.URL, nil
+	}
+
+	return "", errWrongEndpointLine.New(line)
+}
+
+// Stop implements the gitcollector.Provider interface.
+func (p *Endpoints) Stop() error {
+	select {
+	case p.cancel <- struct{}{}:
+		return nil
+	case <-time.After(p.opts.StopTimeout):
+		return ErrEndpointsStop.New()
+	}
+}
@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpoints(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-endpoints")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "endpoints")
+	content := `# comment
+https://github.com/src-d/gitcollector
+
+git://github.com/src-d/go-borges.git
+{"endpoint": "https://gitlab.com/gitlab-org/gitaly.git"}
+{"url": "https://bitbucket.org/foo/bar"}
+{"endpoint":
+{"other": "field"}
+::not an endpoint::
+https://github.com/src-d/go-siva`
+	require.NoError(ioutil.WriteFile(path, []byte(content), 0644))
+
+	queue := make(chan gitcollector.Job, 10)
+	provider := NewEndpoints(path, queue, nil)
+	require.True(ErrEndpointsStopped.Is(provider.Start()))
+
+	expected := []string{
+		"https://github.com/src-d/gitcollector",
+		"git://github.com/src-d/go-borges.git",
+		"https://gitlab.com/gitlab-org/gitaly.git",
+		"https://bitbucket.org/foo/bar",
+		"https://github.com/src-d/go-siva",
+	}
+
+	require.Equal(expected, endpointsFromQueue(t, queue))
+}
+
+func TestEndpointsFullQueue(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-endpoints")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	expected := []string{
+		"https://github.com/src-d/gitcollector",
+		"https://github.com/src-d/go-borges",
+		"https://github.com/src-d/go-siva",
+	}
+
+	path := filepath.Join(dir, "endpoints")
+	require.NoError(ioutil.WriteFile(
+		path, []byte(strings.Join(expected, "\n")), 0644,
+	))
+
+	queue := make(chan gitcollector.Job, 1)
+	provider := NewEndpoints(path, queue, &EndpointsOpts{
+		EnqueueTimeout: 10 * time.Millisecond,
+	})
+
+	done := make(chan error)
+	go func() { done <- provider.Start() }()
+
+	// the provider keeps waiting for the workers after the timeout.
+	time.Sleep(100 * time.Millisecond)
+	var endpoints []string
+	for range expected {
+		job := (<-queue).(*library.Job)
+		endpoints = append(endpoints, job.Endpoints()[0])
+	}
+
+	require.True(ErrEndpointsStopped.Is(<-done))
+	require.Equal(expected, endpoints)
+}
+
+func TestEndpointsFollow(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-endpoints")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "endpoints")
+	require.NoError(ioutil.WriteFile(
+		path, []byte("https://github.com/src-d/gitcollector\n"), 0644,
+	))
+
+	queue := make(chan gitcollector.Job, 10)
+	provider := NewEndpoints(path, queue, &EndpointsOpts{
+		Follow:       true,
+		PollInterval: 10 * time.Millisecond,
+		StopTimeout:  time.Second,
+	})
+
+	done := make(chan error)
+	go func() { done <- provider.Start() }()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(err)
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = f.WriteString("https://github.com/src-d/go")
+	require.NoError(err)
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = f.WriteString("-borges\n")
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(provider.Stop())
+	require.True(ErrEndpointsStopped.Is(<-done))
+
+	require.Equal([]string{
+		"https://github.com/src-d/gitcollector",
+		"https://github.com/src-d/go-borges",
+	}, endpointsFromQueue(t, queue))
+}
+
+func endpointsFromQueue(t *testing.T, queue chan gitcollector.Job) []string {
+	t.Helper()
+
+	close(queue)
+	var endpoints []string
+	for j := range queue {
+		job, ok := j.(*library.Job)
+		require.True(t, ok)
+		require.True(t, job.Type == library.JobDownload)
+		require.Len(t, job.Endpoints(), 1)
+		endpoints = append(endpoints, job.Endpoints()[0])
+	}
+
+	return endpoints
+}
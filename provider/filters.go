@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"os"
+	"time"
+
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// LocationFilterFn reports whether a borges.Location must be processed.
+type LocationFilterFn func(borges.Location) (bool, error)
+
+// LocationIDsFilter accepts only the locations with the given IDs.
+func LocationIDsFilter(ids []borges.LocationID) LocationFilterFn {
+	set := make(map[borges.LocationID]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	return func(l borges.Location) (bool, error) {
+		_, ok := set[l.ID()]
+		return ok, nil
+	}
+}
+
+// OrgsFilter accepts the locations holding at least one repository which
+// belongs to any of the given organizations.
+func OrgsFilter(orgs []string) LocationFilterFn {
+	set := make(map[string]struct{}, len(orgs))
+	for _, org := range orgs {
+		set[org] = struct{}{}
+	}
+
+	return func(l borges.Location) (bool, error) {
+		repo, err := l.Get("", borges.ReadOnlyMode)
+		if err != nil {
+			return false, err
+		}
+		defer repo.Close()
+
+		remotes, err := repo.R().Remotes()
+		if err != nil {
+			return false, err
+		}
+
+		for _, remote := range remotes {
+			for _, url := range remote.Config().URLs {
+				org := library.GetOrgFromEndpoint(url)
+				if _, ok := set[org]; ok {
+					return true, nil
+				}
+			}
+		}
+
+		return false, nil
+	}
+}
+
+// NotUpdatedSinceFilter accepts the locations whose siva file in the given
+// billy.Filesystem hasn't been modified in the given time. The bucket level
+// must be the one used by the library.
+func NotUpdatedSinceFilter(
+	fs billy.Filesystem,
+	bucket int,
+	age time.Duration,
+) LocationFilterFn {
+	return func(l borges.Location) (bool, error) {
+		info, err := fs.Stat(library.LocationPath(l.ID(), bucket))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		return time.Since(info.ModTime()) >= age, nil
+	}
+}
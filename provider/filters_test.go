@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func TestUpdatesFilters(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-filters")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	const bucket = 2
+	ids := []borges.LocationID{"aaa", "abb", "bcc", "cdd"}
+	old := time.Now().Add(-48 * time.Hour)
+	for i, id := range ids {
+		path := filepath.Join(dir, library.LocationPath(id, bucket))
+		require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(ioutil.WriteFile(path, nil, 0644))
+		if i%2 == 0 {
+			require.NoError(os.Chtimes(path, old, old))
+		}
+	}
+
+	lib := &testLib{locIDs: append(ids, "eff")}
+	fs := osfs.New(dir)
+
+	for _, tst := range []struct {
+		name     string
+		filters  []LocationFilterFn
+		expected []borges.LocationID
+	}{
+		{"none", nil, lib.locIDs},
+		{
+			"ids",
+			[]LocationFilterFn{
+				LocationIDsFilter([]borges.LocationID{"abb", "eff"}),
+			},
+			[]borges.LocationID{"abb", "eff"},
+		},
+		{
+			"not-updated-since",
+			[]LocationFilterFn{
+				NotUpdatedSinceFilter(fs, bucket, 24*time.Hour),
+			},
+			[]borges.LocationID{"aaa", "bcc"},
+		},
+		{
+			"all",
+			[]LocationFilterFn{
+				LocationIDsFilter([]borges.LocationID{"abb", "bcc"}),
+				NotUpdatedSinceFilter(fs, bucket, 24*time.Hour),
+			},
+			[]borges.LocationID{"bcc"},
+		},
+	} {
+		queue := make(chan gitcollector.Job, 10)
+		provider := NewUpdates(lib, queue, &UpdatesOpts{
+			TriggerOnce: true,
+			Filters:     tst.filters,
+		})
+
+		require.True(ErrUpdatesStopped.Is(provider.Start()), tst.name)
+		close(queue)
+
+		var got []borges.LocationID
+		for j := range queue {
+			got = append(got, j.(*library.Job).LocationID)
+		}
+
+		require.ElementsMatch(tst.expected, got, tst.name)
+	}
+}
@@ -8,43 +8,141 @@ import (
 	"github.com/src-d/gitcollector/library"
 
 	"github.com/google/go-github/v28/github"
+	"gopkg.in/src-d/go-log.v1"
 )
 
 // NewGitHubOrg builds a new gitcollector.Provider
-// based on a discovery.Github.
+// based on a discovery.Github. The requests are configured by the given
+// iterator options, if any, e.g. to authenticate them or to reach a github
+// enterprise server.
 func NewGitHubOrg(
 	org string,
 	excludedRepos []string,
-	authToken string,
+	iterOpts *discovery.GHReposIterOpts,
 	queue chan<- gitcollector.Job,
 	opts *discovery.GitHubOpts,
 ) *discovery.GitHub {
+	return newGitHub(
+		discovery.NewGHOrgReposIter(org, excludedRepos, iterOpts),
+		iterOpts, queue, opts,
+	)
+}
+
+// NewGitHubUser builds a new gitcollector.Provider based on a discovery.Github
+// of the repositories owned by the given user. The requests are configured
+// by the given iterator options, if any.
+func NewGitHubUser(
+	user string,
+	excludedRepos []string,
+	iterOpts *discovery.GHReposIterOpts,
+	queue chan<- gitcollector.Job,
+	opts *discovery.GitHubOpts,
+) *discovery.GitHub {
+	return newGitHub(
+		discovery.NewGHUserReposIter(user, excludedRepos, iterOpts),
+		iterOpts, queue, opts,
+	)
+}
+
+// NewGitHubSearch builds a new gitcollector.Provider based on a
+// discovery.Github of the repositories found by the given search query. The
+// requests are configured by the given iterator options, if any.
+func NewGitHubSearch(
+	query string,
+	excludedRepos []string,
+	iterOpts *discovery.GHReposIterOpts,
+	queue chan<- gitcollector.Job,
+	opts *discovery.GitHubOpts,
+) *discovery.GitHub {
+	return newGitHub(
+		discovery.NewGHSearchReposIter(query, excludedRepos, iterOpts),
+		iterOpts, queue, opts,
+	)
+}
+
+// NewGitHubApp builds a new gitcollector.Provider based on a discovery.Github
+// of the repositories of all the installations of the given github app. The
+// requests are configured by the given iterator options, if any.
+func NewGitHubApp(
+	app *discovery.GHApp,
+	excludedRepos []string,
+	iterOpts *discovery.GHReposIterOpts,
+	queue chan<- gitcollector.Job,
+	opts *discovery.GitHubOpts,
+) *discovery.GitHub {
+	return newGitHub(
+		discovery.NewGHAppReposIter(app, excludedRepos, iterOpts),
+		iterOpts, queue, opts,
+	)
+}
+
+// newGitHub builds a discovery.GitHub advertising the repositories found by
+// the given iterator on the queue. If the iterator keeps checkpoints, the
+// repositories are acknowledged on them once their jobs are queued, or right
+// away if they're filtered out.
+func newGitHub(
+	iter discovery.GHRepositoriesIter,
+	iterOpts *discovery.GHReposIterOpts,
+	queue chan<- gitcollector.Job,
+	opts *discovery.GitHubOpts,
+) *discovery.GitHub {
+	var checkpoints *discovery.CheckpointStore
+	if iterOpts != nil {
+		checkpoints = iterOpts.Checkpoints
+	}
+
+	if checkpoints != nil {
+		o := discovery.GitHubOpts{}
+		if opts != nil {
+			o = *opts
+		}
+
+		filtered := o.Filtered
+		o.Filtered = func(repo *github.Repository, reason string) {
+			if filtered != nil {
+				filtered(repo, reason)
+			}
+
+			acknowledge(checkpoints, repo)
+		}
+
+		opts = &o
+	}
+
 	return discovery.NewGitHub(
-		AdvertiseGHRepositoriesOnJobQueue(queue),
-		discovery.NewGHOrgReposIter(org, excludedRepos, &discovery.GHReposIterOpts{
-			AuthToken: authToken,
-		}),
+		AdvertiseGHRepositoriesOnJobQueue(
+			queue, ghProtocol(opts), checkpoints,
+		),
+		iter,
 		opts,
 	)
 }
 
 // AdvertiseGHRepositoriesOnJobQueue sends the discovered repositories as a
 // gitcollector.Jobs to the given channel. It makes a discovery.GitHub plays
-// as a gitcollector.Provider
+// as a gitcollector.Provider. The repositories are acknowledged on the given
+// checkpoints, if any, once their jobs are queued.
 func AdvertiseGHRepositoriesOnJobQueue(
 	queue chan<- gitcollector.Job,
+	protocol discovery.Protocol,
+	checkpoints *discovery.CheckpointStore,
 ) discovery.AdvertiseGHRepositoriesFn {
 	return func(ctx context.Context, repos []*github.Repository) error {
 		for _, repo := range repos {
-			endpoint, err := discovery.GetGHEndpoint(repo)
+			endpoint, err := discovery.GetGHEndpointByProtocol(repo, protocol)
 			if err != nil {
-				return nil
+				acknowledge(checkpoints, repo)
+				continue
 			}
 
 			job := &library.Job{
 				Type: library.JobDownload,
 			}
 			job.SetEndpoints([]string{endpoint})
+			if checkpoints != nil {
+				repo := repo
+				job.Queued = func() { acknowledge(checkpoints, repo) }
+			}
 
 			select {
 			case queue <- job:
@@ -57,3 +155,53 @@ func AdvertiseGHRepositoriesOnJobQueue(
 		return nil
 	}
 }
+
+// FilteredGHRepositoriesOnMetrics registers the repositories filtered out by
+// a discovery.GitHub as filtered gitcollector.Jobs on the given
+// gitcollector.MetricsCollector, if any, and logs them with the reason.
+func FilteredGHRepositoriesOnMetrics(
+	mc gitcollector.MetricsCollector,
+	logger log.Logger,
+) discovery.FilteredGHRepositoryFn {
+	return func(repo *github.Repository, reason string) {
+		endpoint, err := discovery.GetGHEndpoint(repo)
+		if err != nil {
+			return
+		}
+
+		logger.With(log.Fields{"url": endpoint, "filter": reason}).
+			Debugf("repository filtered out")
+
+		if mc == nil {
+			return
+		}
+
+		job := &library.Job{
+			Type: library.JobDownload,
+		}
+		job.SetEndpoints([]string{endpoint})
+		mc.Filter(job)
+	}
+}
+
+// acknowledge acknowledges the given repository on the checkpoints, if any.
+func acknowledge(
+	checkpoints *discovery.CheckpointStore,
+	repo *github.Repository,
+) {
+	if checkpoints == nil {
+		return
+	}
+
+	if err := checkpoints.Acknowledge(repo); err != nil {
+		log.Errorf(err, "couldn't save discovery checkpoint")
+	}
+}
+
+func ghProtocol(opts *discovery.GitHubOpts) discovery.Protocol {
+	if opts == nil {
+		return discovery.ProtocolHTTPS
+	}
+
+	return opts.Protocol
+}
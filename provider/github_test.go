@@ -23,7 +23,7 @@ func TestGitHub(t *testing.T) {
 	provider := NewGitHubOrg(
 		org,
 		[]string{},
-		"",
+		nil,
 		queue,
 		&discovery.GitHubOpts{
 			MaxJobBuffer: 50,
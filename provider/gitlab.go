@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/src-d/gitcollector/library"
+)
+
+// NewGitLabGroup builds a new gitcollector.Provider based on a
+// discovery.GitLab. The group can also be a subgroup full path or a user.
+func NewGitLabGroup(
+	group string,
+	baseURL string,
+	excludedRepos []string,
+	authToken string,
+	queue chan<- gitcollector.Job,
+	opts *discovery.GitLabOpts,
+) *discovery.GitLab {
+	return discovery.NewGitLab(
+		AdvertiseGLProjectsOnJobQueue(queue, glProtocol(opts)),
+		discovery.NewGLNamespaceProjectsIter(
+			group,
+			excludedRepos,
+			&discovery.GLProjectsIterOpts{
+				BaseURL:   baseURL,
+				AuthToken: authToken,
+			},
+		),
+		opts,
+	)
+}
+
+// AdvertiseGLProjectsOnJobQueue sends the discovered projects as a
+// gitcollector.Jobs to the given channel. It makes a discovery.GitLab plays
+// as a gitcollector.Provider
+func AdvertiseGLProjectsOnJobQueue(
+	queue chan<- gitcollector.Job,
+	protocol discovery.Protocol,
+) discovery.AdvertiseGLProjectsFn {
+	return func(ctx context.Context, projects []*discovery.GLProject) error {
+		for _, project := range projects {
+			endpoint, err := discovery.GetGLEndpointByProtocol(project, protocol)
+			if err != nil {
+				continue
+			}
+
+			job := &library.Job{
+				Type: library.JobDownload,
+			}
+			job.SetEndpoints([]string{endpoint})
+
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return discovery.ErrAdvertiseTimeout.
+					Wrap(ctx.Err())
+			}
+		}
+
+		return nil
+	}
+}
+
+func glProtocol(opts *discovery.GitLabOpts) discovery.Protocol {
+	if opts == nil {
+		return discovery.ProtocolHTTPS
+	}
+
+	return opts.Protocol
+}
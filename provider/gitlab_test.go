@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/discovery"
+	"github.com/src-d/gitcollector/library"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitLab(t *testing.T) {
+	var req = require.New(t)
+
+	const (
+		group    = "src-d"
+		projects = 5
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v4/groups/"+group+"/projects" {
+				http.NotFound(w, r)
+				return
+			}
+
+			var ps []*discovery.GLProject
+			for i := 0; i < projects; i++ {
+				path := fmt.Sprintf("%s/project-%d", group, i)
+				ps = append(ps, &discovery.GLProject{
+					ID:                i,
+					Name:              fmt.Sprintf("project-%d", i),
+					PathWithNamespace: path,
+					HTTPURLToRepo: fmt.Sprintf(
+						"https://gitlab.test/%s.git", path,
+					),
+				})
+			}
+
+			json.NewEncoder(w).Encode(ps)
+		},
+	))
+	defer server.Close()
+
+	queue := make(chan gitcollector.Job, 50)
+	provider := NewGitLabGroup(
+		group,
+		server.URL,
+		[]string{},
+		"",
+		queue,
+		&discovery.GitLabOpts{},
+	)
+
+	err := provider.Start()
+	req.True(discovery.ErrDiscoveryStopped.Is(err))
+	close(queue)
+
+	req.Len(queue, projects)
+	for j := range queue {
+		job, ok := j.(*library.Job)
+		req.True(ok)
+		req.True(job.Type == library.JobDownload)
+		req.Len(job.Endpoints(), 1)
+		req.Equal(group, library.GetOrgFromEndpoint(job.Endpoints()[0]))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/src-d/gitcollector/library"
 	"github.com/src-d/go-borges"
 	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
 )
 
 var (
@@ -28,6 +29,11 @@ type UpdatesOpts struct {
 	// StopTimeout is the time the service waits to be stopped after a Stop
 	// call is performed.
 	StopTimeout time.Duration
+	// Filters are used to select the locations to update. Only the
+	// locations accepted by all the filters will be updated.
+	Filters []LocationFilterFn
+	// Logger is used to report the filters failing on a location.
+	Logger log.Logger
 }
 
 // Updates is a gitcollector.Provider implementation. It will periodically
@@ -70,6 +76,10 @@ func NewUpdates(
 		opts.EnqueueTimeout = enqueueTimeout
 	}
 
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
 	return &Updates{
 		lib:    lib,
 		queue:  queue,
@@ -114,6 +124,10 @@ func (p *Updates) update() error {
 		}
 
 		iter.ForEach(func(l borges.Location) error {
+			if !p.accept(l) {
+				return nil
+			}
+
 			job := &library.Job{
 				Type:       library.JobUpdate,
 				LocationID: l.ID(),
@@ -140,6 +154,23 @@ func (p *Updates) update() error {
 	return nil
 }
 
+func (p *Updates) accept(l borges.Location) bool {
+	for _, filter := range p.opts.Filters {
+		ok, err := filter(l)
+		if err != nil {
+			p.opts.Logger.With(log.Fields{"location": l.ID()}).
+				Errorf(err, "couldn't filter location")
+			return false
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Stop implements the gitcollector.Provider interface.
 func (p *Updates) Stop() error {
 	select {
@@ -0,0 +1,83 @@
+package gitcollector
+
+import (
+	"context"
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// TransientErrorFn reports whether the given error, returned by a failed Job,
+// is transient and so the Job could succeed if it's processed again.
+type TransientErrorFn func(error) bool
+
+// RetryOpts are configuration options to retry the failed Jobs.
+type RetryOpts struct {
+	// MaxAttempts is the maximum number of times a Job is processed. Zero
+	// or one means the failed jobs are never retried.
+	MaxAttempts int
+	// MinBackoff is the time to wait before the first retry.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum time to wait between retries.
+	MaxBackoff time.Duration
+	// Factor multiplies the time to wait after each retry.
+	Factor float64
+	// Transient classifies the errors returned by the jobs, only jobs
+	// failing with a transient error are retried. By default every error
+	// but a canceled context is transient.
+	Transient TransientErrorFn
+}
+
+// DeadLetter represents a sink for the Jobs which failed permanently, that is,
+// jobs that failed with a not transient error or exhausted their attempts.
+type DeadLetter interface {
+	// Put records a Job which failed permanently after the given
+	// attempts with the given error.
+	Put(job Job, attempts int, err error)
+}
+
+const (
+	// retry default configuration
+	retryMinBackoff = 1 * time.Second
+	retryMaxBackoff = 1 * time.Minute
+	retryFactor     = 2
+)
+
+func setRetryDefaults(opts *RetryOpts) *RetryOpts {
+	if opts == nil {
+		opts = &RetryOpts{}
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = retryMinBackoff
+	}
+
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = retryMaxBackoff
+	}
+
+	if opts.Factor <= 0 {
+		opts.Factor = retryFactor
+	}
+
+	if opts.Transient == nil {
+		opts.Transient = func(err error) bool {
+			return err != context.Canceled
+		}
+	}
+
+	return opts
+}
+
+func (o *RetryOpts) backoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    o.MinBackoff,
+		Max:    o.MaxBackoff,
+		Factor: o.Factor,
+		Jitter: true,
+	}
+}
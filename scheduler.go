@@ -2,6 +2,7 @@ package gitcollector
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/jpillora/backoff"
@@ -26,6 +27,7 @@ type jobScheduler struct {
 	jobs     chan Job
 	schedule JobScheduleFn
 	cancel   chan struct{}
+	once     sync.Once
 	opts     *WorkerPoolOpts
 	backoff  *backoff.Backoff
 }
@@ -67,8 +69,10 @@ func newJobScheduler(
 	}
 }
 
+// finish stops the scheduling, it can be called several times and even once
+// the scheduling already finished.
 func (s *jobScheduler) finish() {
-	s.cancel <- struct{}{}
+	s.once.Do(func() { close(s.cancel) })
 }
 
 func (s *jobScheduler) Schedule() {
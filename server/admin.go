@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+	"github.com/src-d/go-borges"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ErrEnqueueNotSupported is returned when jobs can't be enqueued through the
+// admin API.
+var ErrEnqueueNotSupported = errors.NewKind("%s jobs can't be enqueued")
+
+// AdminOpts represents configuration options for the handler built by
+// NewAdminHandler.
+type AdminOpts struct {
+	// Enqueue schedules a new library.Job. Jobs can't be enqueued if it's
+	// not set.
+	Enqueue func(*library.Job) error
+	// Queued returns the number of jobs waiting to be processed, by default
+	// the ones scheduled by the pool.
+	Queued func() int
+	Logger log.Logger
+}
+
+// NewAdminHandler builds an http.Handler to control a running
+// gitcollector.WorkerPool. The requests and responses are JSON encoded:
+//
+//	GET    /status      workers, active workers, queued jobs and if paused
+//	PUT    /workers     sets the number of workers, e.g. {"workers": 4}
+//	POST   /pause       stops taking new jobs
+//	POST   /resume      takes new jobs again
+//	GET    /jobs        jobs in progress with their endpoints and elapsed time
+//	POST   /jobs        enqueues a job, e.g. {"type": "download", "endpoints": [...]}
+//	DELETE /jobs/{id}   cancels the job in progress with the given ID
+func NewAdminHandler(
+	pool *gitcollector.WorkerPool,
+	opts *AdminOpts,
+) http.Handler {
+	if opts == nil {
+		opts = &AdminOpts{}
+	}
+
+	if opts.Queued == nil {
+		opts.Queued = pool.Queued
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
+	return &adminHandler{pool: pool, opts: opts}
+}
+
+type adminHandler struct {
+	pool *gitcollector.WorkerPool
+	opts *AdminOpts
+}
+
+// AdminStatus is the status of the pool returned by the admin API.
+type AdminStatus struct {
+	Workers int  `json:"workers"`
+	Active  int  `json:"active"`
+	Queued  int  `json:"queued"`
+	Paused  bool `json:"paused"`
+}
+
+// AdminJob describes a job in the admin API.
+type AdminJob struct {
+	ID        string     `json:"id,omitempty"`
+	Type      string     `json:"type"`
+	Endpoints []string   `json:"endpoints"`
+	Priority  int        `json:"priority,omitempty"`
+	Started   *time.Time `json:"started,omitempty"`
+	Elapsed   string     `json:"elapsed,omitempty"`
+}
+
+const jobsPath = "/jobs"
+
+// ServeHTTP implements the http.Handler interface.
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/status" && r.Method == http.MethodGet:
+		h.status(w)
+	case path == "/workers" && r.Method == http.MethodPut:
+		h.setWorkers(w, r)
+	case path == "/pause" && r.Method == http.MethodPost:
+		h.pool.Pause()
+		h.opts.Logger.Infof("worker pool paused")
+		h.status(w)
+	case path == "/resume" && r.Method == http.MethodPost:
+		h.pool.Resume()
+		h.opts.Logger.Infof("worker pool resumed")
+		h.status(w)
+	case path == jobsPath && r.Method == http.MethodGet:
+		h.jobs(w)
+	case path == jobsPath && r.Method == http.MethodPost:
+		h.enqueue(w, r)
+	case strings.HasPrefix(path, jobsPath+"/") &&
+		r.Method == http.MethodDelete:
+		h.cancel(w, strings.TrimPrefix(path, jobsPath+"/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *adminHandler) status(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, &AdminStatus{
+		Workers: h.pool.Size(),
+		Active:  h.pool.Active(),
+		Queued:  h.opts.Queued(),
+		Paused:  h.pool.Paused(),
+	})
+}
+
+func (h *adminHandler) setWorkers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Workers *int `json:"workers"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+		req.Workers == nil || *req.Workers < 0 {
+		http.Error(w, "wrong number of workers", http.StatusBadRequest)
+		return
+	}
+
+	// removing workers waits for their jobs to finish.
+	h.pool.SetWorkers(*req.Workers)
+	h.opts.Logger.Infof("number of workers set to %d", *req.Workers)
+	h.status(w)
+}
+
+func (h *adminHandler) jobs(w http.ResponseWriter) {
+	jobs := []*AdminJob{}
+	for _, rj := range h.pool.Running() {
+		rj := rj
+		job, ok := rj.Job.(*library.Job)
+		if !ok {
+			continue
+		}
+
+		jobs = append(jobs, &AdminJob{
+			ID:        job.ID,
+			Type:      job.Type.String(),
+			Endpoints: job.Endpoints(),
+			Priority:  job.Priority,
+			Started:   &rj.Started,
+			Elapsed:   time.Since(rj.Started).String(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (h *adminHandler) enqueue(w http.ResponseWriter, r *http.Request) {
+	var req AdminJob
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var typ library.JobType
+	switch req.Type {
+	case "download":
+		typ = library.JobDownload
+	case "update":
+		typ = library.JobUpdate
+	default:
+		http.Error(
+			w,
+			fmt.Sprintf("wrong job type %q", req.Type),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	if len(req.Endpoints) == 0 {
+		http.Error(w, "no endpoints given", http.StatusBadRequest)
+		return
+	}
+
+	for _, ep := range req.Endpoints {
+		if err := library.ValidateEndpoint(ep); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if h.opts.Enqueue == nil {
+		err := ErrEnqueueNotSupported.New(req.Type)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	// every endpoint is processed by its own job.
+	for _, ep := range req.Endpoints {
+		job := &library.Job{Type: typ, Priority: req.Priority}
+		job.SetEndpoints([]string{ep})
+		if err := h.opts.Enqueue(job); err != nil {
+			status := http.StatusServiceUnavailable
+			switch {
+			case ErrEnqueueNotSupported.Is(err):
+				status = http.StatusNotImplemented
+			case borges.ErrRepositoryNotExists.Is(err):
+				status = http.StatusNotFound
+			}
+
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		h.opts.Logger.With(log.Fields{"url": ep}).
+			Infof("%s job enqueued", req.Type)
+	}
+
+	writeJSON(w, http.StatusAccepted, &req)
+}
+
+func (h *adminHandler) cancel(w http.ResponseWriter, id string) {
+	cancelled := h.pool.Cancel(func(j gitcollector.Job) bool {
+		job, ok := j.(*library.Job)
+		return ok && job.ID == id
+	})
+
+	if !cancelled {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	h.opts.Logger.With(log.Fields{"id": id}).Infof("job cancelled")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitcollector"
+	"github.com/src-d/gitcollector/library"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestAdminHandler(t *testing.T) {
+	var require = require.New(t)
+
+	queue := make(chan gitcollector.Job, 10)
+	started := make(chan string, 10)
+	process := func(ctx context.Context, job *library.Job) error {
+		started <- job.ID
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	wp := gitcollector.NewWorkerPool(
+		library.NewDownloadJobScheduleFn(
+			nil, queue, process, false, nil, log.New(nil), nil,
+		),
+		&gitcollector.WorkerPoolOpts{},
+	)
+	wp.SetWorkers(1)
+	wp.Run()
+	defer wp.Stop()
+
+	var enqueued []*library.Job
+	srv := httptest.NewServer(NewAdminHandler(wp, &AdminOpts{
+		Enqueue: func(job *library.Job) error {
+			if job.Type != library.JobDownload {
+				return ErrEnqueueNotSupported.New(job.Type)
+			}
+
+			enqueued = append(enqueued, job)
+			queue <- job
+			return nil
+		},
+	}))
+	defer srv.Close()
+
+	do := func(method, path, body string, v interface{}) int {
+		req, err := http.NewRequest(
+			method, srv.URL+path, strings.NewReader(body),
+		)
+		require.NoError(err)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(err)
+		defer res.Body.Close()
+
+		if v != nil {
+			require.NoError(json.NewDecoder(res.Body).Decode(v))
+		}
+
+		return res.StatusCode
+	}
+
+	var status AdminStatus
+	require.Equal(http.StatusOK, do("GET", "/status", "", &status))
+	require.Equal(AdminStatus{Workers: 1}, status)
+
+	require.Equal(http.StatusOK, do("POST", "/pause", "", &status))
+	require.True(status.Paused)
+
+	require.Equal(http.StatusAccepted, do("POST", "/jobs",
+		`{"type": "download", "endpoints": ["https://github.com/a/b"], "priority": 2}`,
+		nil,
+	))
+	require.Len(enqueued, 1)
+	require.Equal(
+		[]string{"https://github.com/a/b"},
+		enqueued[0].Endpoints(),
+	)
+	require.Equal(2, enqueued[0].Priority)
+
+	require.Equal(http.StatusNotImplemented, do("POST", "/jobs",
+		`{"type": "update", "endpoints": ["https://github.com/a/b"]}`,
+		nil,
+	))
+	require.Equal(http.StatusBadRequest, do("POST", "/jobs",
+		`{"type": "foo", "endpoints": ["https://github.com/a/b"]}`,
+		nil,
+	))
+	require.Equal(http.StatusBadRequest, do("POST", "/jobs",
+		`{"type": "download", "endpoints": ["https://github.com/a/c", "foo"]}`,
+		nil,
+	))
+	require.Len(enqueued, 1)
+
+	var jobs []*AdminJob
+	require.Equal(http.StatusOK, do("GET", "/jobs", "", &jobs))
+	require.Len(jobs, 0)
+
+	require.Equal(http.StatusOK, do("POST", "/resume", "", &status))
+	require.False(status.Paused)
+
+	id := <-started
+	require.Equal(http.StatusOK, do("GET", "/jobs", "", &jobs))
+	require.Len(jobs, 1)
+	require.Equal(id, jobs[0].ID)
+	require.Equal("download", jobs[0].Type)
+	require.Equal([]string{"https://github.com/a/b"}, jobs[0].Endpoints)
+
+	require.Equal(http.StatusOK, do("PUT", "/workers", `{"workers": 3}`,
+		&status,
+	))
+	require.Equal(3, status.Workers)
+	require.Equal(1, status.Active)
+	require.Equal(http.StatusBadRequest, do("PUT", "/workers", `{}`, nil))
+
+	require.Equal(http.StatusNotFound, do("DELETE", "/jobs/foo", "", nil))
+	require.Equal(http.StatusNoContent, do(
+		"DELETE", fmt.Sprintf("/jobs/%s", id), "", nil,
+	))
+	require.Eventually(func() bool {
+		return wp.Active() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Equal(http.StatusNotFound, do("GET", "/foo", "", nil))
+}
+
+func TestAdminCancelQueuedJob(t *testing.T) {
+	var require = require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitcollector-admin")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q, err := library.OpenQueue(dir, nil)
+	require.NoError(err)
+	defer q.Close()
+
+	started := make(chan string, 10)
+	process := func(ctx context.Context, job *library.Job) error {
+		started <- job.ID
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// the pool takes jobs from the queue while the channel is open.
+	download := make(chan gitcollector.Job, 10)
+	defer close(download)
+
+	wp := gitcollector.NewWorkerPool(
+		library.NewQueueJobScheduleFn(
+			q, nil, download, nil, process, process, false, nil,
+			log.New(nil), nil, nil,
+		),
+		&gitcollector.WorkerPoolOpts{},
+	)
+	wp.SetWorkers(1)
+	wp.Run()
+	defer wp.Stop()
+
+	srv := httptest.NewServer(NewAdminHandler(wp, &AdminOpts{
+		Enqueue: q.Enqueue,
+	}))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/jobs", "application/json",
+		strings.NewReader(
+			`{"type": "download", "endpoints": ["https://github.com/a/b"]}`,
+		),
+	)
+	require.NoError(err)
+	res.Body.Close()
+	require.Equal(http.StatusAccepted, res.StatusCode)
+
+	id := <-started
+	req, err := http.NewRequest(
+		"DELETE", fmt.Sprintf("%s/jobs/%s", srv.URL, id), nil,
+	)
+	require.NoError(err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(err)
+	res.Body.Close()
+	require.Equal(http.StatusNoContent, res.StatusCode)
+
+	// the cancelled job isn't released to be delivered again.
+	require.Eventually(func() bool {
+		return q.Jobs(library.JobFailed) == 1
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(0, q.Jobs(library.JobEnqueued))
+	require.Equal(0, q.Jobs(library.JobLeased))
+
+	select {
+	case again := <-started:
+		require.Fail("cancelled job delivered again", again)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
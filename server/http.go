@@ -0,0 +1,260 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	gitserver "gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ErrWrongRequest is returned when an upload-pack request can't be parsed.
+var ErrWrongRequest = errors.NewKind("wrong upload-pack request")
+
+const (
+	uploadPackService  = "git-upload-pack"
+	receivePackService = "git-receive-pack"
+
+	infoRefsPath = "/info/refs"
+
+	advertisementContentType = "application/x-git-upload-pack-advertisement"
+	resultContentType        = "application/x-git-upload-pack-result"
+)
+
+// HTTPOpts represents configuration options for the handler built by
+// NewHTTPHandler.
+type HTTPOpts struct {
+	Logger log.Logger
+}
+
+// NewHTTPHandler builds an http.Handler serving the repositories of the
+// library over the git smart HTTP protocol, so they can be cloned at the
+// path given by their borges.RepositoryID, e.g.
+// http://host/github.com/src-d/gitcollector. The repositories are read from
+// the rooted repositories, the library must not be opened with the
+// RootedRepo option, and only the references fetched from the repository
+// endpoint are advertised with their original names. Pushes are rejected.
+func NewHTTPHandler(lib borges.Library, opts *HTTPOpts) http.Handler {
+	if opts == nil {
+		opts = &HTTPOpts{}
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(nil)
+	}
+
+	return &handler{lib: lib, logger: opts.Logger}
+}
+
+type handler struct {
+	lib    borges.Library
+	logger log.Logger
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case strings.HasSuffix(path, infoRefsPath) && r.Method == http.MethodGet:
+		h.infoRefs(w, r, strings.TrimSuffix(path, infoRefsPath))
+	case strings.HasSuffix(path, "/"+uploadPackService) &&
+		r.Method == http.MethodPost:
+		h.uploadPack(
+			w, r, strings.TrimSuffix(path, "/"+uploadPackService),
+		)
+	case strings.HasSuffix(path, "/"+receivePackService):
+		http.Error(w, "read-only repository", http.StatusForbidden)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) infoRefs(w http.ResponseWriter, r *http.Request, path string) {
+	switch r.URL.Query().Get("service") {
+	case uploadPackService:
+	case receivePackService:
+		http.Error(w, "read-only repository", http.StatusForbidden)
+		return
+	default:
+		http.Error(w, "only smart http is supported", http.StatusForbidden)
+		return
+	}
+
+	h.withSession(w, path, func(
+		_ storer.Storer,
+		session transport.UploadPackSession,
+	) error {
+		ar, err := session.AdvertisedReferences()
+		if err != nil {
+			return err
+		}
+
+		ar.Prefix = [][]byte{
+			[]byte("# service=" + uploadPackService),
+			pktline.Flush,
+		}
+
+		w.Header().Set("Content-Type", advertisementContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		return ar.Encode(w)
+	})
+}
+
+func (h *handler) uploadPack(w http.ResponseWriter, r *http.Request, path string) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+
+		body = gz
+	}
+
+	req, done, err := decodeUploadPackRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.withSession(w, path, func(
+		sto storer.Storer,
+		session transport.UploadPackSession,
+	) error {
+		req.Haves = commonHaves(sto, req.Haves)
+
+		w.Header().Set("Content-Type", resultContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		if !done {
+			// negotiation round of a stateless client, it
+			// sends the haves again along with the done.
+			return acknowledge(w, req.Haves)
+		}
+
+		res, err := session.UploadPack(r.Context(), req)
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+
+		return res.Encode(w)
+	})
+}
+
+// withSession opens the repository at the given path and runs fn with an
+// upload-pack session on it.
+func (h *handler) withSession(
+	w http.ResponseWriter,
+	path string,
+	fn func(storer.Storer, transport.UploadPackSession) error,
+) {
+	id := borges.RepositoryID(
+		strings.TrimSuffix(strings.Trim(path, "/"), ".git"),
+	)
+
+	logger := h.logger.New(log.Fields{"repository": id})
+	repo, err := h.lib.Get(id, borges.ReadOnlyMode)
+	if err != nil {
+		if borges.ErrRepositoryNotExists.Is(err) {
+			http.Error(w, "repository not found", http.StatusNotFound)
+			return
+		}
+
+		logger.Errorf(err, "couldn't open repository")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer repo.Close()
+
+	sto := siva.NewRootedStorage(repo.R().Storer, string(id))
+	session, err := gitserver.NewServer(storerLoader{sto}).
+		NewUploadPackSession(&transport.Endpoint{Path: string(id)}, nil)
+	if err != nil {
+		logger.Errorf(err, "couldn't start upload-pack session")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+
+	if err := fn(sto, session); err != nil {
+		logger.Errorf(err, "upload-pack failed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type storerLoader struct {
+	sto storer.Storer
+}
+
+// Load implements the go-git server.Loader interface.
+func (l storerLoader) Load(*transport.Endpoint) (storer.Storer, error) {
+	return l.sto, nil
+}
+
+// decodeUploadPackRequest decodes the wants and haves of an upload-pack
+// request. It also returns whether the client finished the negotiation.
+func decodeUploadPackRequest(
+	r io.Reader,
+) (*packp.UploadPackRequest, bool, error) {
+	req := packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(r); err != nil {
+		return nil, false, ErrWrongRequest.Wrap(err)
+	}
+
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSuffix(string(s.Bytes()), "\n")
+		switch {
+		case line == "done":
+			return req, true, nil
+		case strings.HasPrefix(line, "have "):
+			have := strings.TrimPrefix(line, "have ")
+			req.Haves = append(req.Haves, plumbing.NewHash(have))
+		case line == "":
+			// flush at the end of a negotiation round.
+		default:
+			return nil, false, ErrWrongRequest.New()
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, false, ErrWrongRequest.Wrap(err)
+	}
+
+	return req, false, nil
+}
+
+// commonHaves returns the haves of a client found in the storer.
+func commonHaves(sto storer.Storer, haves []plumbing.Hash) []plumbing.Hash {
+	var common []plumbing.Hash
+	for _, h := range haves {
+		if sto.HasEncodedObject(h) == nil {
+			common = append(common, h)
+		}
+	}
+
+	return common
+}
+
+// acknowledge answers a negotiation round with the first common object or
+// NAK if there's none.
+func acknowledge(w io.Writer, common []plumbing.Hash) error {
+	var res packp.ServerResponse
+	if len(common) > 0 {
+		res.ACKs = common[:1]
+	}
+
+	return res.Encode(w)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitcollector/downloader"
+	"github.com/src-d/gitcollector/downloader/testhelper"
+	"github.com/src-d/gitcollector/library"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+
+	var require = require.New(t)
+
+	h, close, err := testhelper.NewHelper()
+	require.NoError(err)
+	defer close()
+
+	git := func(args ...string) string {
+		args = append([]string{
+			"-c", "user.name=foo", "-c", "user.email=foo@bar",
+			"-c", "init.defaultBranch=master",
+		}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	root := filepath.Join(h.Dir, "remote")
+	src := filepath.Join(root, "org", "repo.git")
+	git("init", src)
+	git("-C", src, "commit", "--allow-empty", "-m", "first")
+	git("-C", src, "tag", "v1")
+	git("-C", src, "checkout", "-b", "dev")
+	git("-C", src, "commit", "--allow-empty", "-m", "second")
+
+	hostKey := newTestSigner(t)
+	clientKey := newTestSigner(t)
+	sshServer, err := testhelper.NewSSHServer(
+		root, hostKey, clientKey.PublicKey(),
+	)
+	require.NoError(err)
+	defer sshServer.Close()
+
+	endpoint := fmt.Sprintf("ssh://git@%s/org/repo.git", sshServer.Addr)
+	download := func() {
+		job := &library.Job{
+			Lib:         h.Lib,
+			Type:        library.JobDownload,
+			TempFS:      h.TempFS,
+			AllowUpdate: true,
+			AuthToken:   func(string) string { return "" },
+			Auth: func(string) (transport.AuthMethod, error) {
+				return &gitssh.PublicKeys{
+					User:   "git",
+					Signer: clientKey,
+					HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
+						HostKeyCallback: ssh.FixedHostKey(
+							hostKey.PublicKey(),
+						),
+					},
+				}, nil
+			},
+			Logger: log.New(nil),
+		}
+		job.SetEndpoints([]string{endpoint})
+		require.NoError(downloader.Download(context.Background(), job))
+	}
+
+	download()
+
+	srv := httptest.NewServer(NewHTTPHandler(h.Lib, nil))
+	defer srv.Close()
+
+	id, err := library.NewRepositoryID(endpoint)
+	require.NoError(err)
+	url := fmt.Sprintf("%s/%s.git", srv.URL, id)
+
+	dst := filepath.Join(h.Dir, "clone")
+	git("clone", url, dst)
+	require.Equal(git("-C", src, "rev-parse", "HEAD"),
+		git("-C", dst, "rev-parse", "HEAD"))
+	require.Equal(git("-C", src, "rev-parse", "master"),
+		git("-C", dst, "rev-parse", "origin/master"))
+	require.Equal("v1", git("-C", dst, "tag"))
+
+	// the new commits are fetched negotiating the common ones.
+	git("-C", src, "commit", "--allow-empty", "-m", "third")
+	download()
+	git("-C", dst, "fetch", "origin")
+	require.Equal(git("-C", src, "rev-parse", "dev"),
+		git("-C", dst, "rev-parse", "origin/dev"))
+
+	for path, status := range map[string]int{
+		"/github.com/foo/bar/info/refs?service=git-upload-pack":   http.StatusNotFound,
+		"/" + id.String() + "/info/refs?service=git-receive-pack": http.StatusForbidden,
+		"/" + id.String() + "/info/refs":                          http.StatusForbidden,
+	} {
+		res, err := http.Get(srv.URL + path)
+		require.NoError(err)
+		res.Body.Close()
+		require.Equal(status, res.StatusCode, path)
+	}
+
+	res, err := http.Post(
+		url+"/git-receive-pack", "application/x-git-receive-pack-request", nil,
+	)
+	require.NoError(err)
+	res.Body.Close()
+	require.Equal(http.StatusForbidden, res.StatusCode)
+
+	out, err := exec.Command("git", "-C", dst, "push", "origin", "HEAD:refs/heads/foo").
+		CombinedOutput()
+	require.Error(err, string(out))
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
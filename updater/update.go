@@ -9,7 +9,6 @@ import (
 	"github.com/src-d/go-borges/siva"
 	"gopkg.in/src-d/go-errors.v1"
 	"gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 	"gopkg.in/src-d/go-log.v1"
 )
 
@@ -49,6 +48,14 @@ func Update(ctx context.Context, job *library.Job) error {
 		return err
 	}
 
+	unlock, err := job.LockLocation(ctx, job.LocationID)
+	if err != nil {
+		logger.Errorf(err, "couldn't lock location")
+		return err
+	}
+	defer unlock()
+	locked := time.Now()
+
 	repo, err := loc.Get("", borges.RWMode)
 	if err != nil {
 		logger.Errorf(err, "couldn't get repository")
@@ -95,12 +102,17 @@ func Update(ctx context.Context, job *library.Job) error {
 		logger,
 		repo,
 		remotes,
-		job.AuthToken,
+		job.AuthMethod,
+		job.ObservePhase,
+		job.RunPhase,
+		job.RunCommit,
+		locked,
 	); err != nil {
 		logger.Errorf(err, "failed")
 		return err
 	}
 
+	job.UpdateCatalog(loc)
 	elapsed := time.Since(start).String()
 	logger.With(log.Fields{"elapsed": elapsed}).Infof("finished")
 	return nil
@@ -137,7 +149,11 @@ func updateRepository(
 	logger log.Logger,
 	repo borges.Repository,
 	remotes []*git.Remote,
-	authToken library.AuthTokenFn,
+	authMethod library.AuthMethodFn,
+	observe func(library.Phase, time.Duration),
+	run library.PhaseFn,
+	commit library.CommitFn,
+	locked time.Time,
 ) error {
 	var alreadyUpdated int
 	start := time.Now()
@@ -145,16 +161,22 @@ func updateRepository(
 		opts := &git.FetchOptions{}
 		urls := remote.Config().URLs
 		if len(urls) > 0 {
-			token := authToken(urls[0])
-			if token != "" {
-				opts.Auth = &http.BasicAuth{
-					Username: "gitcollector",
-					Password: token,
+			auth, err := authMethod(urls[0])
+			if err != nil {
+				if err := repo.Close(); err != nil {
+					logger.Warningf("couldn't close repository")
 				}
+
+				return err
 			}
+
+			opts.Auth = auth
 		}
 
-		err := remote.FetchContext(ctx, opts)
+		// every remote has its own fetch timeout.
+		err := run(ctx, library.PhaseFetch, func(ctx context.Context) error {
+			return remote.FetchContext(ctx, opts)
+		})
 		if err != nil && err != git.NoErrAlreadyUpToDate {
 			if err := repo.Close(); err != nil {
 				logger.Warningf("couldn't close repository")
@@ -176,6 +198,7 @@ func updateRepository(
 		}
 	}
 
+	observe(library.PhaseFetch, time.Since(start))
 	if len(remotes) == alreadyUpdated {
 		elapsed := time.Since(start).String()
 		logger.With(log.Fields{"elapsed": elapsed}).
@@ -187,10 +210,22 @@ func updateRepository(
 	logger.With(log.Fields{"elapsed": elapsed}).Debugf("fetched")
 
 	start = time.Now()
-	if err := repo.Commit(); err != nil {
+	committed := false
+	err := commit(ctx, locked, func() error {
+		committed = true
+		return repo.Commit()
+	})
+	if err != nil {
+		if !committed {
+			if err := repo.Close(); err != nil {
+				logger.Warningf("couldn't close repository")
+			}
+		}
+
 		return err
 	}
 
+	observe(library.PhaseCommit, time.Since(start))
 	elapsed = time.Since(start).String()
 	logger.With(log.Fields{"elapsed": elapsed}).Debugf("commited")
 	return nil
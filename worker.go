@@ -2,64 +2,145 @@ package gitcollector
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/src-d/go-errors.v1"
 )
 
 type worker struct {
-	id      string
-	jobs    chan Job
-	cancel  chan bool
-	stopped bool
-	metrics MetricsCollector
+	id         string
+	jobs       chan Job
+	cancel     chan bool
+	kill       <-chan struct{}
+	gate       *pauseGate
+	done       chan struct{}
+	inflight   sync.WaitGroup
+	mu         sync.Mutex
+	running    *RunningJob
+	cancelJob  context.CancelFunc
+	stopped    bool
+	metrics    MetricsCollector
+	retry      *RetryOpts
+	deadLetter DeadLetter
+	timeout    time.Duration
+	grace      time.Duration
+	busy       int32
 }
 
-func newWorker(jobs chan Job, metrics MetricsCollector) *worker {
+func newWorker(
+	jobs chan Job,
+	kill <-chan struct{},
+	gate *pauseGate,
+	metrics MetricsCollector,
+	retry *RetryOpts,
+	deadLetter DeadLetter,
+	timeout time.Duration,
+	grace time.Duration,
+) *worker {
 	return &worker{
-		jobs:    jobs,
-		cancel:  make(chan bool),
-		metrics: metrics,
+		jobs:       jobs,
+		cancel:     make(chan bool),
+		kill:       kill,
+		gate:       gate,
+		done:       make(chan struct{}),
+		metrics:    metrics,
+		retry:      retry,
+		deadLetter: deadLetter,
+		timeout:    timeout,
+		grace:      grace,
 	}
 }
 
 var (
+	// ErrTimeout is returned when a Job, or one of its phases, didn't
+	// finish before its deadline.
+	ErrTimeout = errors.NewKind("%s timed out after %s")
+
 	errJobsClosed    = errors.NewKind("jobs channel was closed")
 	errWorkerStopped = errors.NewKind("worker was stopped")
 )
 
 func (w *worker) start() {
+	defer close(w.done)
+
 	// It shouldn't be restarted after a call to stop.
 	if w.stopped {
 		return
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	for {
 		if err := w.consumeJob(ctx); err != nil {
-			if errJobsClosed.Is(err) || errWorkerStopped.Is(err) {
-				close(w.cancel)
-			}
-
-			return
+			break
 		}
 	}
+
+	// a job left running after an immediate stop is cancelled, it's
+	// waited for a grace period so it can roll back its changes.
+	cancel()
+	inflight := make(chan struct{})
+	go func() {
+		w.inflight.Wait()
+		close(inflight)
+	}()
+
+	select {
+	case <-inflight:
+	case <-time.After(w.grace):
+	}
 }
 
 func (w *worker) consumeJob(ctx context.Context) error {
+	// no jobs are taken while the pool is paused.
 	select {
+	case <-w.gate.wait():
 	case <-w.cancel:
 		return errWorkerStopped.New()
+	case <-w.kill:
+		return errWorkerStopped.New()
+	}
+
+	select {
+	case <-w.cancel:
+		return errWorkerStopped.New()
+	case <-w.kill:
+		return errWorkerStopped.New()
+	case <-w.gate.closing():
+		return nil
 	case job, ok := <-w.jobs:
 		if !ok {
 			return errJobsClosed.New()
 		}
 
 		var done = make(chan struct{})
+		atomic.StoreInt32(&w.busy, 1)
+		cancelled := new(int32)
+		jobCtx, cancel := context.WithCancel(
+			context.WithValue(ctx, cancelledKey{}, cancelled),
+		)
+		w.setRunning(
+			&RunningJob{Job: job, Started: time.Now()},
+			func() {
+				atomic.StoreInt32(cancelled, 1)
+				cancel()
+			},
+		)
+		w.inflight.Add(1)
 		go func() {
+			defer w.inflight.Done()
 			defer close(done)
-			if err := job.Process(ctx); err != nil {
-				w.metrics.Fail(job)
+			defer atomic.StoreInt32(&w.busy, 0)
+			defer w.setRunning(nil, nil)
+			defer cancel()
+			if err := w.process(jobCtx, job); err != nil {
+				if ErrTimeout.Is(err) {
+					w.metrics.Timeout(job)
+				} else {
+					w.metrics.Fail(job)
+				}
+
 				return
 			}
 
@@ -69,9 +150,16 @@ func (w *worker) consumeJob(ctx context.Context) error {
 		select {
 		case now := <-w.cancel:
 			if !now {
-				<-done
+				// a graceful stop can still be turned into an
+				// immediate one.
+				select {
+				case <-done:
+				case <-w.kill:
+				}
 			}
 
+			return errWorkerStopped.New()
+		case <-w.kill:
 			return errWorkerStopped.New()
 		case <-done:
 			return nil
@@ -79,11 +167,171 @@ func (w *worker) consumeJob(ctx context.Context) error {
 	}
 }
 
+// process processes the given job retrying it while it fails with a
+// transient error. Jobs failing permanently are sent to the dead letter.
+func (w *worker) process(ctx context.Context, job Job) error {
+	var (
+		backoff  = w.retry.backoff()
+		attempts int
+	)
+
+	for {
+		attempts++
+		err := w.processAttempt(ctx, job)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// the worker was stopped, the job didn't fail by itself.
+			return err
+		}
+
+		if attempts >= w.retry.MaxAttempts || !w.retry.Transient(err) {
+			if w.deadLetter != nil {
+				w.deadLetter.Put(job, attempts, err)
+			}
+
+			return err
+		}
+
+		select {
+		case <-time.After(backoff.Duration()):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// processAttempt processes the given job cancelling it once the job timeout
+// expires.
+func (w *worker) processAttempt(ctx context.Context, job Job) error {
+	if w.timeout <= 0 {
+		return job.Process(ctx)
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	err := job.Process(jobCtx)
+	if err != nil && ctx.Err() == nil &&
+		jobCtx.Err() == context.DeadlineExceeded && !ErrTimeout.Is(err) {
+		err = ErrTimeout.Wrap(err, "job", w.timeout)
+	}
+
+	return err
+}
+
+type cancelledKey struct{}
+
+// Cancelled reports whether the job processed with the given context was
+// cancelled through WorkerPool.Cancel, rather than by stopping the pool.
+func Cancelled(ctx context.Context) bool {
+	cancelled, ok := ctx.Value(cancelledKey{}).(*int32)
+	return ok && atomic.LoadInt32(cancelled) == 1
+}
+
+func (w *worker) setRunning(job *RunningJob, cancel context.CancelFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.running = job
+	w.cancelJob = cancel
+}
+
+// runningJob returns the job being processed by the worker, if any.
+func (w *worker) runningJob() *RunningJob {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.running
+}
+
+// cancelRunning cancels the job being processed if it matches.
+func (w *worker) cancelRunning(match func(Job) bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running == nil || !match(w.running.Job) {
+		return false
+	}
+
+	w.cancelJob()
+	return true
+}
+
+func (w *worker) isBusy() bool {
+	return atomic.LoadInt32(&w.busy) == 1
+}
+
 func (w *worker) stop(immediate bool) {
 	if w.stopped {
 		return
 	}
 
-	w.cancel <- immediate
+	select {
+	case w.cancel <- immediate:
+	case <-w.done:
+	}
+
 	w.stopped = true
 }
+
+// pauseGate holds the workers of a paused pool.
+type pauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{}
+	closed chan struct{}
+}
+
+func newPauseGate() *pauseGate {
+	resume := make(chan struct{})
+	close(resume)
+	return &pauseGate{resume: resume, closed: make(chan struct{})}
+}
+
+// wait returns a channel closed once the gate is open.
+func (g *pauseGate) wait() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.resume
+}
+
+// closing returns a channel closed once the gate is closed.
+func (g *pauseGate) closing() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.closed
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.paused {
+		g.paused = true
+		g.resume = make(chan struct{})
+		close(g.closed)
+	}
+}
+
+func (g *pauseGate) unpause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.paused {
+		g.paused = false
+		g.closed = make(chan struct{})
+		close(g.resume)
+	}
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.paused
+}
@@ -11,13 +11,27 @@ type WorkerPoolOpts struct {
 	ScheduleJobTimeout time.Duration
 	NotWaitNewJobs     bool
 	Metrics            MetricsCollector
+	Retry              *RetryOpts
+	DeadLetter         DeadLetter
+	// JobTimeout is the maximum time a Job is processed, its context is
+	// cancelled once it expires. Zero means no timeout.
+	JobTimeout time.Duration
+	// StopGracePeriod is the time Stop waits for the cancelled jobs to
+	// return, by default 10 seconds.
+	StopGracePeriod time.Duration
 }
 
+const stopGracePeriod = 10 * time.Second
+
 // WorkerPool holds a pool of workers to process Jobs.
 type WorkerPool struct {
 	scheduler *jobScheduler
 	workers   []*worker
 	resize    chan struct{}
+	kill      chan struct{}
+	gate      *pauseGate
+	killOnce  sync.Once
+	stopOnce  sync.Once
 	wg        sync.WaitGroup
 	opts      *WorkerPoolOpts
 }
@@ -33,9 +47,16 @@ func NewWorkerPool(
 		opts.Metrics = &hollowMetricsCollector{}
 	}
 
+	opts.Retry = setRetryDefaults(opts.Retry)
+	if opts.StopGracePeriod <= 0 {
+		opts.StopGracePeriod = stopGracePeriod
+	}
+
 	return &WorkerPool{
 		scheduler: newJobScheduler(schedule, opts),
 		resize:    resize,
+		kill:      make(chan struct{}),
+		gate:      newPauseGate(),
 		opts:      opts,
 	}
 }
@@ -54,6 +75,79 @@ func (wp *WorkerPool) Size() int {
 	return len(wp.workers)
 }
 
+// Active returns the number of workers processing a job.
+func (wp *WorkerPool) Active() int {
+	<-wp.resize
+	defer func() { wp.resize <- struct{}{} }()
+
+	var active int
+	for _, w := range wp.workers {
+		if w.isBusy() {
+			active++
+		}
+	}
+
+	return active
+}
+
+// Queued returns the number of jobs scheduled waiting for a worker.
+func (wp *WorkerPool) Queued() int {
+	return len(wp.scheduler.jobs)
+}
+
+// RunningJob is a Job being processed by a worker of the pool.
+type RunningJob struct {
+	Job     Job
+	Started time.Time
+}
+
+// Running returns the jobs being processed.
+func (wp *WorkerPool) Running() []*RunningJob {
+	<-wp.resize
+	defer func() { wp.resize <- struct{}{} }()
+
+	var jobs []*RunningJob
+	for _, w := range wp.workers {
+		if job := w.runningJob(); job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs
+}
+
+// Cancel cancels the context of the jobs being processed which match the
+// given function, they aren't retried. It returns whether any job was
+// cancelled.
+func (wp *WorkerPool) Cancel(match func(Job) bool) bool {
+	<-wp.resize
+	defer func() { wp.resize <- struct{}{} }()
+
+	var cancelled bool
+	for _, w := range wp.workers {
+		if w.cancelRunning(match) {
+			cancelled = true
+		}
+	}
+
+	return cancelled
+}
+
+// Pause stops the workers from taking new jobs, the jobs in progress go on.
+func (wp *WorkerPool) Pause() {
+	wp.gate.pause()
+}
+
+// Resume lets the workers of a paused pool take new jobs again.
+func (wp *WorkerPool) Resume() {
+	wp.gate.unpause()
+}
+
+// Paused reports whether the pool is paused.
+func (wp *WorkerPool) Paused() bool {
+	return wp.gate.isPaused()
+}
+
 // SetWorkers set the number of Workers in the pool to n.
 func (wp *WorkerPool) SetWorkers(n int) {
 	<-wp.resize
@@ -76,7 +170,16 @@ func (wp *WorkerPool) SetWorkers(n int) {
 func (wp *WorkerPool) add(n int) {
 	wp.wg.Add(n)
 	for i := 0; i < n; i++ {
-		w := newWorker(wp.scheduler.jobs, wp.opts.Metrics)
+		w := newWorker(
+			wp.scheduler.jobs,
+			wp.kill,
+			wp.gate,
+			wp.opts.Metrics,
+			wp.opts.Retry,
+			wp.opts.DeadLetter,
+			wp.opts.JobTimeout,
+			wp.opts.StopGracePeriod,
+		)
 		go func() {
 			w.start()
 			wp.wg.Done()
@@ -109,31 +212,40 @@ func (wp *WorkerPool) remove(n int) {
 // Wait waits for the workers to finish.
 func (wp *WorkerPool) Wait() {
 	wp.wg.Wait()
+	<-wp.resize
 	wp.workers = nil
-	wp.opts.Metrics.Stop(false)
+	wp.resize <- struct{}{}
+	wp.stopMetrics(false)
 }
 
-// Close stops all the workers in the pool waiting for the jobs to finish.
+// Close stops all the workers in the pool waiting for the jobs to finish. A
+// call to Stop while it's waiting stops the workers immediately.
 func (wp *WorkerPool) Close() {
 	wp.SetWorkers(0)
 	wp.wg.Wait()
 	wp.scheduler.finish()
-	wp.opts.Metrics.Stop(false)
+	wp.stopMetrics(false)
 }
 
-// Stop stops all the workers in the pool immediately.
+// Stop stops all the workers in the pool immediately. The context of the
+// jobs being processed is cancelled and they're waited to return for the
+// StopGracePeriod.
 func (wp *WorkerPool) Stop() {
+	wp.killOnce.Do(func() { close(wp.kill) })
+
 	<-wp.resize
 	defer func() { wp.resize <- struct{}{} }()
 
-	for _, w := range wp.workers {
-		w.stop(true)
-	}
-
 	wp.wg.Wait()
 	wp.workers = nil
 	wp.scheduler.finish()
-	wp.opts.Metrics.Stop(true)
+	wp.stopMetrics(true)
+}
+
+// stopMetrics stops the metrics collector only the first time it's called,
+// since Wait, Close and Stop can be called concurrently.
+func (wp *WorkerPool) stopMetrics(immediate bool) {
+	wp.stopOnce.Do(func() { wp.opts.Metrics.Stop(immediate) })
 }
 
 type hollowMetricsCollector struct{}
@@ -144,4 +256,6 @@ func (mc *hollowMetricsCollector) Start()       {}
 func (mc *hollowMetricsCollector) Stop(bool)    {}
 func (mc *hollowMetricsCollector) Success(Job)  {}
 func (mc *hollowMetricsCollector) Fail(Job)     {}
+func (mc *hollowMetricsCollector) Timeout(Job)  {}
 func (mc *hollowMetricsCollector) Discover(Job) {}
+func (mc *hollowMetricsCollector) Filter(Job)   {}
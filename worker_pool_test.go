@@ -2,6 +2,7 @@ package gitcollector
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -66,6 +67,272 @@ func TestWorkerPool(t *testing.T) {
 	require.Len(wp.workers, 0)
 }
 
+func TestWorkerPoolRetry(t *testing.T) {
+	var require = require.New(t)
+
+	var (
+		errTransient = fmt.Errorf("transient")
+		errPermanent = fmt.Errorf("permanent")
+
+		mu       sync.Mutex
+		attempts = map[string]int{}
+		process  = func(id string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts[id]++
+			switch id {
+			case "recovered":
+				if attempts[id] < 3 {
+					return errTransient
+				}
+
+				return nil
+			case "exhausted":
+				return errTransient
+			case "permanent":
+				return errPermanent
+			}
+
+			return nil
+		}
+	)
+
+	queue := make(chan Job, 10)
+	dl := &testDeadLetter{}
+	wp := NewWorkerPool(testScheduleFn(queue), &WorkerPoolOpts{
+		Retry: &RetryOpts{
+			MaxAttempts: 4,
+			MinBackoff:  time.Millisecond,
+			MaxBackoff:  5 * time.Millisecond,
+			Transient: func(err error) bool {
+				return err == errTransient
+			},
+		},
+		DeadLetter: dl,
+	})
+
+	wp.SetWorkers(3)
+	wp.Run()
+
+	for _, id := range []string{"ok", "recovered", "exhausted", "permanent"} {
+		queue <- &testJob{id: id, process: process}
+	}
+	close(queue)
+
+	wp.Wait()
+	require.Equal(map[string]int{
+		"ok":        1,
+		"recovered": 3,
+		"exhausted": 4,
+		"permanent": 1,
+	}, attempts)
+
+	require.ElementsMatch([]string{"exhausted:4", "permanent:1"}, dl.jobs)
+}
+
+func TestWorkerPoolTimeout(t *testing.T) {
+	var require = require.New(t)
+
+	queue := make(chan Job, 10)
+	mc := &testMetrics{}
+	wp := NewWorkerPool(testScheduleFn(queue), &WorkerPoolOpts{
+		Metrics:    mc,
+		JobTimeout: 20 * time.Millisecond,
+	})
+
+	wp.SetWorkers(2)
+	wp.Run()
+
+	queue <- &testCtxJob{id: "hung", process: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	queue <- &testCtxJob{id: "fail", process: func(context.Context) error {
+		return fmt.Errorf("fail")
+	}}
+	queue <- &testCtxJob{id: "ok", process: func(context.Context) error {
+		return nil
+	}}
+	close(queue)
+
+	wp.Wait()
+	require.Equal([]string{"hung"}, mc.timeouts)
+	require.Equal([]string{"fail"}, mc.fails)
+}
+
+func TestWorkerPoolCloseStop(t *testing.T) {
+	var require = require.New(t)
+
+	var (
+		started   = make(chan struct{}, 2)
+		release   = make(chan struct{})
+		cancelled = make(chan string, 2)
+	)
+
+	newPool := func() (*WorkerPool, chan Job) {
+		queue := make(chan Job, 10)
+		wp := NewWorkerPool(testScheduleFn(queue), &WorkerPoolOpts{})
+		wp.SetWorkers(2)
+		wp.Run()
+		return wp, queue
+	}
+
+	process := func(ctx context.Context) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			cancelled <- "job"
+			return ctx.Err()
+		}
+	}
+
+	// the jobs in progress finish before Close returns.
+	wp, queue := newPool()
+	queue <- &testCtxJob{id: "a", process: process}
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		wp.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		require.Fail("closed with jobs in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-closed
+	require.Len(cancelled, 0)
+
+	// Stop cancels the jobs in progress while Close is waiting for them.
+	release = make(chan struct{})
+	wp, queue = newPool()
+	queue <- &testCtxJob{id: "a", process: process}
+	queue <- &testCtxJob{id: "b", process: process}
+	<-started
+	<-started
+
+	closed = make(chan struct{})
+	go func() {
+		wp.Close()
+		close(closed)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	wp.Stop()
+	<-closed
+	require.Len(cancelled, 2)
+}
+
+func TestWorkerPoolPauseCancel(t *testing.T) {
+	var require = require.New(t)
+
+	queue := make(chan Job, 10)
+	mc := &testMetrics{}
+	wp := NewWorkerPool(testScheduleFn(queue), &WorkerPoolOpts{
+		Metrics: mc,
+	})
+
+	wp.Pause()
+	require.True(wp.Paused())
+	wp.SetWorkers(2)
+	wp.Run()
+
+	started := make(chan string, 2)
+	process := func(id string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			started <- id
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+
+	queue <- &testCtxJob{id: "a", process: process("a")}
+	queue <- &testCtxJob{id: "b", process: process("b")}
+
+	select {
+	case id := <-started:
+		require.Fail("job started while paused", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Len(wp.Running(), 0)
+
+	wp.Resume()
+	require.False(wp.Paused())
+	<-started
+	<-started
+
+	running := wp.Running()
+	require.Len(running, 2)
+	require.False(running[0].Started.IsZero())
+
+	byID := func(id string) func(Job) bool {
+		return func(j Job) bool { return j.(*testCtxJob).id == id }
+	}
+
+	require.False(wp.Cancel(byID("c")))
+	require.True(wp.Cancel(byID("a")))
+	require.Eventually(func() bool {
+		return len(wp.Running()) == 1
+	}, time.Second, 5*time.Millisecond)
+	require.Equal("b", wp.Running()[0].Job.(*testCtxJob).id)
+
+	require.True(wp.Cancel(byID("b")))
+	close(queue)
+	wp.Wait()
+	require.ElementsMatch([]string{"a", "b"}, mc.fails)
+}
+
+type testMetrics struct {
+	hollowMetricsCollector
+	mu       sync.Mutex
+	fails    []string
+	timeouts []string
+}
+
+func (mc *testMetrics) Fail(job Job) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.fails = append(mc.fails, job.(*testCtxJob).id)
+}
+
+func (mc *testMetrics) Timeout(job Job) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.timeouts = append(mc.timeouts, job.(*testCtxJob).id)
+}
+
+type testCtxJob struct {
+	id      string
+	process func(context.Context) error
+}
+
+var _ Job = (*testCtxJob)(nil)
+
+func (j *testCtxJob) Process(ctx context.Context) error {
+	return j.process(ctx)
+}
+
+type testDeadLetter struct {
+	mu   sync.Mutex
+	jobs []string
+}
+
+func (d *testDeadLetter) Put(job Job, attempts int, _ error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.jobs = append(d.jobs, fmt.Sprintf("%s:%d", job.(*testJob).id, attempts))
+}
+
 type testJob struct {
 	id      string
 	process func(id string) error